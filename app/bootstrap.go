@@ -1,9 +1,15 @@
 package app
 
 import (
+	"context"
 	"http-server/app/config"
 	"http-server/app/http"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 type App struct {
@@ -18,11 +24,46 @@ func Application() *App {
 }
 
 func (a *App) Start(port string) {
+	// The log package writes through it too once it is the default
+	slog.SetDefault(config.Logger())
 	a.server = http.NewHttpServer("localhost", port)
 	a.UseGlobalPreMiddlewares(config.GlobalPreMiddlewares())
 	a.UseGlobalPostMiddlewares(config.GlobalPostMiddlewares())
-	log.Println("Server is listening on http://localhost:" + a.server.Port)
-	a.server.Listen(a.Router)
+	if templates := config.Templates(); templates != nil {
+		a.UseTemplates(templates)
+	}
+	if proxies := config.TrustedProxies(); proxies != nil {
+		a.UseTrustedProxies(proxies)
+	}
+	certFile, keyFile, useTLS := config.TLSFiles()
+	if useTLS {
+		log.Println("Server is listening on https://localhost:" + a.server.Port)
+	} else {
+		log.Println("Server is listening on http://localhost:" + a.server.Port)
+	}
+
+	// Requests in flight get some time to finish once the server is asked to stop
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-signals
+		log.Println("Shutting down the server")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := a.server.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down the server: %v", err)
+		}
+	}()
+	if useTLS {
+		if err := a.server.ListenTLS(a.Router, certFile, keyFile); err != nil {
+			log.Fatalf("Error starting the server: %v", err)
+		}
+	} else {
+		a.server.Listen(a.Router)
+	}
+	<-stopped
 }
 
 func (a *App) Add(RouteGroup *http.Router) {
@@ -1,16 +1,179 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"html/template"
 	"http-server/app/http"
 	"http-server/middleware"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
 )
 
 func GlobalPreMiddlewares() []http.MiddlewareFunc {
-	return []http.MiddlewareFunc{}
+	return []http.MiddlewareFunc{
+		middleware.AccessLog(middleware.AccessLogOptions{Format: AccessLogFormat()}),
+		middleware.RequestID(middleware.RequestIDOptions{}),
+	}
 }
 
 func GlobalPostMiddlewares() []http.MiddlewareFunc {
-	return []http.MiddlewareFunc{
-		middleware.LoggerMiddleware,
+	return []http.MiddlewareFunc{}
+}
+
+// AccessLogFormat returns the format of the access log read from ACCESS_LOG_FORMAT,
+// one of "json", "logfmt", "common" and "combined", JSON by default
+func AccessLogFormat() middleware.AccessLogFormat {
+	switch os.Getenv("ACCESS_LOG_FORMAT") {
+	case "", "json":
+		return middleware.AccessLogJSON
+	case "logfmt":
+		return middleware.AccessLogLogfmt
+	case "common":
+		return middleware.AccessLogCommon
+	case "combined":
+		return middleware.AccessLogCombined
+	default:
+		log.Fatalf("Invalid ACCESS_LOG_FORMAT: %q", os.Getenv("ACCESS_LOG_FORMAT"))
+		return middleware.AccessLogJSON
+	}
+}
+
+// Logger returns the structured logger of the application, writing to stderr in the format read
+// from LOG_FORMAT, "text" or "json", text by default. The records logged with the context of a
+// request carry the id given by the RequestID middleware.
+func Logger() *slog.Logger {
+	var handler slog.Handler
+	switch os.Getenv("LOG_FORMAT") {
+	case "", "text":
+		handler = slog.NewTextHandler(os.Stderr, nil)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, nil)
+	default:
+		log.Fatalf("Invalid LOG_FORMAT: %q", os.Getenv("LOG_FORMAT"))
+	}
+	return slog.New(middleware.RequestIDLogHandler(handler))
+}
+
+var (
+	cookieKeys     *http.Keyring
+	cookieKeysOnce sync.Once
+)
+
+// CookieKeys returns the keyring used to sign and encrypt cookies.
+// Keys are read from APP_KEYS as a comma separated list of base64 secrets, newest first,
+// a random key is generated when it is not set so cookies won't survive a restart.
+func CookieKeys() *http.Keyring {
+	cookieKeysOnce.Do(func() {
+		var keys [][]byte
+		for _, encoded := range strings.Split(os.Getenv("APP_KEYS"), ",") {
+			encoded = strings.TrimSpace(encoded)
+			if encoded == "" {
+				continue
+			}
+			key, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				log.Fatalf("Invalid key in APP_KEYS: %v", err)
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) == 0 {
+			log.Println("APP_KEYS is not set, using a random key for cookies")
+			key := make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				log.Fatalf("Error generating cookie key: %v", err)
+			}
+			keys = append(keys, key)
+		}
+		cookieKeys = http.NewKeyring(keys...)
+	})
+	return cookieKeys
+}
+
+// TokenVerifier returns the verifier of the bearer tokens, loading the JSON Web Key Set from
+// the file named by JWKS_FILE and checking JWT_ISSUER and JWT_AUDIENCE when they are set.
+// It returns nil when JWKS_FILE is not set. A key set that cannot be loaded is logged and
+// every token is rejected until it is fixed.
+func TokenVerifier() middleware.TokenVerifier {
+	path := os.Getenv("JWKS_FILE")
+	if path == "" {
+		return nil
+	}
+	keys, err := middleware.LoadJWKS(path)
+	if err != nil {
+		log.Printf("Error loading JWKS_FILE, every bearer token will be rejected: %v", err)
+		keys = &middleware.JWKS{}
 	}
-}
\ No newline at end of file
+	return &middleware.JWTVerifier{
+		Keys:     keys,
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+	}
+}
+
+// TemplatesDir is the directory the HTML templates are loaded from
+const TemplatesDir = "views"
+
+// Templates loads the HTML templates from TemplatesDir, or returns nil when the directory doesn't exist.
+// They are parsed again on every render when APP_ENV is "development".
+func Templates() *http.Templates {
+	if _, err := os.Stat(TemplatesDir); err != nil {
+		return nil
+	}
+	templates, err := http.LoadTemplates(TemplatesDir, http.TemplateOptions{
+		Layout: "layouts/main",
+		Reload: os.Getenv("APP_ENV") == "development",
+		RequestFuncs: func(req *http.Request) template.FuncMap {
+			return template.FuncMap{
+				"csrfToken": func() string { return middleware.CSRFToken(req) },
+				"cspNonce":  func() string { return middleware.CSPNonce(req) },
+			}
+		},
+	})
+	if err != nil {
+		log.Fatalf("Error loading templates: %v", err)
+	}
+	return templates
+}
+
+// TLSFiles returns the certificate and key files read from TLS_CERT_FILE and TLS_KEY_FILE,
+// the server speaks HTTPS and HTTP/2 when both are set
+func TLSFiles() (string, string, bool) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	return certFile, keyFile, certFile != "" && keyFile != ""
+}
+
+// TrustedProxies returns the proxies whose forwarding headers are believed, read from
+// TRUSTED_PROXIES as a comma separated list of CIDRs, or nil when it is not set
+func TrustedProxies() *http.TrustedProxies {
+	cidrs := strings.TrimSpace(os.Getenv("TRUSTED_PROXIES"))
+	if cidrs == "" {
+		return nil
+	}
+	proxies, err := http.ParseTrustedProxies(strings.Split(cidrs, ",")...)
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	return proxies
+}
+
+// UpstreamPool returns the pool of the servers the legacy routes are proxied to, read from
+// UPSTREAMS as a comma separated list of URLs and health checked on UPSTREAM_HEALTH_PATH
+// when it is set. It returns nil when UPSTREAMS is not set.
+func UpstreamPool() *http.UpstreamPool {
+	targets := strings.TrimSpace(os.Getenv("UPSTREAMS"))
+	if targets == "" {
+		return nil
+	}
+	pool, err := http.NewUpstreamPool(strings.Split(targets, ","), http.UpstreamPoolOptions{
+		Strategy:        http.LeastConnections,
+		HealthCheckPath: os.Getenv("UPSTREAM_HEALTH_PATH"),
+	})
+	if err != nil {
+		log.Fatalf("Invalid UPSTREAMS: %v", err)
+	}
+	return pool
+}
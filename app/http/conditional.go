@@ -0,0 +1,124 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"time"
+)
+
+// ComputeETag returns an ETag derived from the content of body, weak tags tell clients
+// the representation is only semantically equivalent, not byte for byte identical
+func ComputeETag(body string, weak bool) string {
+	sum := sha256.Sum256([]byte(body))
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+	if weak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+// CheckPreconditions evaluates If-Match, If-Unmodified-Since, If-None-Match and If-Modified-Since
+// against the current validators of the resource, which are also sent back for GET and HEAD.
+// It returns false once the response is set to 304 or 412, the handler must then stop:
+//
+//	if !res.CheckPreconditions(req, order.ETag(), order.UpdatedAt) {
+//		return
+//	}
+func (r *Response) CheckPreconditions(req *Request, etag string, modTime time.Time) bool {
+	if method := req.GetMethod(); method == GET || method == HEAD {
+		if etag != "" {
+			r.SetHeader("ETag", etag)
+		}
+		if !modTime.IsZero() {
+			r.SetHeader("Last-Modified", modTime.UTC().Format(TimeFormat))
+		}
+	}
+	switch checkPreconditions(req, etag, modTime) {
+	case StatusNotModified:
+		r.NotModified()
+		return false
+	case StatusPreconditionFailed:
+		r.ErrorResponse(StatusPreconditionFailed, "the resource was modified, fetch it again before updating it")
+		return false
+	}
+	return true
+}
+
+// checkPreconditions evaluates the conditional headers of the request against the current
+// validators of the resource in the order of RFC 9110 section 13.2.2. It returns the status
+// to answer with instead of the resource, StatusNotModified or StatusPreconditionFailed,
+// or 0 when the request should be served normally.
+func checkPreconditions(req *Request, etag string, modTime time.Time) StatusCode {
+	method := req.GetMethod()
+	safe := method == GET || method == HEAD
+
+	if ifMatch := req.GetHeader("If-Match"); ifMatch != "" {
+		if !matchETag(ifMatch, etag, true) {
+			return StatusPreconditionFailed
+		}
+	} else if since, ok := parseHTTPDate(req.GetHeader("If-Unmodified-Since")); ok && !modTime.IsZero() {
+		if modTime.Truncate(time.Second).After(since) {
+			return StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := req.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		if matchETag(ifNoneMatch, etag, false) {
+			if safe {
+				return StatusNotModified
+			}
+			return StatusPreconditionFailed
+		}
+	} else if since, ok := parseHTTPDate(req.GetHeader("If-Modified-Since")); ok && safe && !modTime.IsZero() {
+		if !modTime.Truncate(time.Second).After(since) {
+			return StatusNotModified
+		}
+	}
+	return 0
+}
+
+// matchETag reports whether etag is listed in the value of an If-Match or If-None-Match header.
+// If-Match uses the strong comparison where weak tags never match, If-None-Match the weak one.
+func matchETag(header string, etag string, strong bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return etag != ""
+	}
+	if etag == "" || (strong && strings.HasPrefix(etag, "W/")) {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong && strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func parseHTTPDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{TimeFormat, time.RFC1123, time.RFC850, time.ANSIC} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// NotModified sends an empty 304 response, the validators and caching headers already set are kept
+func (r *Response) NotModified() {
+	r.SetStatusCode(StatusNotModified)
+	r.SetHeader("Date", time.Now().UTC().Format(time.RFC1123))
+	r.SetHeader("Server", "GoHTTP/1.0")
+	r.SetHeader("Connection", "close")
+	r.DeleteHeader("Content-Type")
+	r.DeleteHeader("Content-Length")
+	r.body = ""
+	r.stream = nil
+}
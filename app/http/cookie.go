@@ -0,0 +1,209 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoCookie is returned by Request.Cookie when the named cookie is not present
+var ErrNoCookie = errors.New("http: named cookie not present")
+
+// SameSite controls the SameSite attribute of a cookie
+type SameSite int
+
+const (
+	SameSiteDefaultMode SameSite = iota
+	SameSiteLaxMode
+	SameSiteStrictMode
+	SameSiteNoneMode
+)
+
+// String returns the attribute value of the SameSite mode
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLaxMode:
+		return "Lax"
+	case SameSiteStrictMode:
+		return "Strict"
+	case SameSiteNoneMode:
+		return "None"
+	default:
+		return ""
+	}
+}
+
+// Cookie represents an HTTP cookie as sent in the Cookie header of a request
+// or the Set-Cookie header of a response.
+//
+// MaxAge=0 means no Max-Age attribute is sent, MaxAge<0 means the cookie has
+// to be deleted right away (sent as Max-Age=0) and MaxAge>0 is the lifetime in seconds.
+type Cookie struct {
+	Name   string
+	Value  string
+	Quoted bool
+
+	Path        string
+	Domain      string
+	Expires     time.Time
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// Valid reports whether the cookie can be serialized into a Set-Cookie header
+func (c *Cookie) Valid() error {
+	if c == nil {
+		return errors.New("http: nil cookie")
+	}
+	if !isCookieName(c.Name) {
+		return fmt.Errorf("http: invalid cookie name %q", c.Name)
+	}
+	for i := 0; i < len(c.Value); i++ {
+		if !isCookieValueByte(c.Value[i]) {
+			return fmt.Errorf("http: invalid byte %q in cookie value", c.Value[i])
+		}
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return errors.New("http: cookie expires year must not be earlier than 1601")
+	}
+	for i := 0; i < len(c.Path); i++ {
+		if !isCookiePathByte(c.Path[i]) {
+			return fmt.Errorf("http: invalid byte %q in cookie path", c.Path[i])
+		}
+	}
+	if c.Domain != "" && !isCookieDomain(c.Domain) {
+		return fmt.Errorf("http: invalid cookie domain %q", c.Domain)
+	}
+	if c.Partitioned && !c.Secure {
+		return errors.New("http: partitioned cookies must be set with Secure")
+	}
+	if c.SameSite == SameSiteNoneMode && !c.Secure {
+		return errors.New("http: cookies with SameSite=None must be set with Secure")
+	}
+	return nil
+}
+
+// String returns the serialization of the cookie for use in a Set-Cookie header.
+// Spaces and commas force the value to be quoted, as do cookies parsed from quoted values.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteString("=")
+	if c.Quoted || strings.ContainsAny(c.Value, " ,") {
+		b.WriteString(`"` + c.Value + `"`)
+	} else {
+		b.WriteString(c.Value)
+	}
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.SameSite != SameSiteDefaultMode {
+		b.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// parseCookieHeader parses the value of a Cookie request header, silently
+// skipping malformed pairs the same way browsers are lenient about them
+func parseCookieHeader(line string) []*Cookie {
+	cookies := make([]*Cookie, 0)
+	for _, part := range strings.Split(line, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !isCookieName(name) {
+			continue
+		}
+		value, quoted, ok := parseCookieValue(strings.TrimSpace(value))
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value, Quoted: quoted})
+	}
+	return cookies
+}
+
+func parseCookieValue(raw string) (string, bool, bool) {
+	quoted := false
+	if len(raw) > 1 && raw[0] == '"' && raw[len(raw)-1] == '"' {
+		raw = raw[1 : len(raw)-1]
+		quoted = true
+	}
+	for i := 0; i < len(raw); i++ {
+		if !isCookieValueByte(raw[i]) {
+			return "", false, false
+		}
+	}
+	return raw, quoted, true
+}
+
+// isCookieName reports whether name is a valid RFC 7230 token
+func isCookieName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?={}`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isCookieValueByte allows the RFC 6265 cookie-octet range plus space and comma,
+// which are kept for compatibility and force the value to be quoted
+func isCookieValueByte(c byte) bool {
+	return 0x20 <= c && c < 0x7f && c != '"' && c != ';' && c != '\\'
+}
+
+func isCookiePathByte(c byte) bool {
+	return 0x20 <= c && c < 0x7f && c != ';'
+}
+
+func isCookieDomain(domain string) bool {
+	domain = strings.TrimPrefix(domain, ".")
+	if domain == "" || len(domain) > 255 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestCookies(t *testing.T) {
+	for _, headerName := range []string{"Cookie", "cookie", "COOKIE"} {
+		req, err := ParseToRequest([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n" + headerName + `: session=abc; theme="dark mode"; bad name=1; empty=` + "\r\n\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookies := req.Cookies()
+		if len(cookies) != 3 {
+			t.Fatalf("%s: cookies = %v, want session, theme and empty", headerName, cookies)
+		}
+		if cookie, err := req.Cookie("theme"); err != nil || cookie.Value != "dark mode" || !cookie.Quoted {
+			t.Errorf("%s: theme = %+v, %v", headerName, cookie, err)
+		}
+		if _, err := req.Cookie("missing"); err != ErrNoCookie {
+			t.Errorf("%s: missing cookie error = %v", headerName, err)
+		}
+	}
+}
+
+func TestCookieString(t *testing.T) {
+	tests := []struct {
+		cookie Cookie
+		want   string
+	}{
+		{Cookie{Name: "a", Value: "1"}, "a=1"},
+		{Cookie{Name: "a", Value: "x y"}, `a="x y"`},
+		{Cookie{
+			Name: "session", Value: "abc", Path: "/", Domain: ".example.com",
+			Expires: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), MaxAge: 3600,
+			HttpOnly: true, Secure: true, SameSite: SameSiteLaxMode, Partitioned: true,
+		}, "session=abc; Path=/; Domain=example.com; Expires=Fri, 02 Jan 2026 03:04:05 GMT; Max-Age=3600; HttpOnly; Secure; SameSite=Lax; Partitioned"},
+		{Cookie{Name: "gone", MaxAge: -1}, "gone=; Max-Age=0"},
+	}
+	for _, test := range tests {
+		if got := test.cookie.String(); got != test.want {
+			t.Errorf("String() = %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestCookieValid(t *testing.T) {
+	for _, cookie := range []*Cookie{
+		nil,
+		{Name: "", Value: "1"},
+		{Name: "a b", Value: "1"},
+		{Name: "a", Value: "semi;colon"},
+		{Name: "a", Value: `quo"te`},
+		{Name: "a", Path: "/x;y"},
+		{Name: "a", Domain: "exa mple.com"},
+		{Name: "a", Expires: time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		if cookie.Valid() == nil {
+			t.Errorf("cookie %+v accepted", cookie)
+		}
+	}
+}
+
+func TestResponseSetsSeveralCookies(t *testing.T) {
+	res := NewHttpResponse()
+	if err := res.SetCookie(&Cookie{Name: "a", Value: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	res.SetCookie(&Cookie{Name: "b", Value: "2", HttpOnly: true})
+	if err := res.SetCookie(&Cookie{Name: "bad;name"}); err == nil {
+		t.Error("invalid cookie set")
+	}
+	want := "Set-Cookie: a=1\r\nSet-Cookie: b=2; HttpOnly\r\n"
+	if head := res.head(); !strings.Contains(head, want) {
+		t.Errorf("head = %q, want it to contain %q", head, want)
+	}
+}
+
+func TestRequestHeadersIgnoreCaseForBodies(t *testing.T) {
+	req, err := ParseToRequest([]byte("POST / HTTP/1.1\r\nhost: example.com\r\ncontent-type: application/x-www-form-urlencoded\r\n\r\nname=gopher"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.GetBodyParam("name") != "gopher" {
+		t.Errorf("name = %q with a lowercase content-type", req.GetBodyParam("name"))
+	}
+
+	req, err = ParseToRequest([]byte("POST / HTTP/1.1\r\nhost: example.com\r\ncontent-type: application/json\r\ncontent-encoding: gzip\r\n\r\nnot json"))
+	if err != nil {
+		t.Fatalf("encoded body parsed before being decoded: %v", err)
+	}
+	if string(req.GetBody()) != "not json" {
+		t.Errorf("raw body = %q", req.GetBody())
+	}
+}
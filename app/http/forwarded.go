@@ -0,0 +1,221 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies are the networks of the proxies in front of the server, the forwarding
+// headers they send are believed while the ones sent by anybody else are ignored
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+}
+
+// ParseTrustedProxies parses a list of CIDRs like "10.0.0.0/8", single addresses are accepted too
+func ParseTrustedProxies(cidrs ...string) (*TrustedProxies, error) {
+	proxies := &TrustedProxies{}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+			}
+			proxies.prefixes = append(proxies.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		proxies.prefixes = append(proxies.prefixes, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// Contains reports whether addr belongs to a trusted network
+func (t *TrustedProxies) Contains(addr netip.Addr) bool {
+	if t == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range t.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// UseTrustedProxies makes Request.ClientIP, Scheme and Host honour the forwarding headers
+// sent by proxies, without it they only describe the connection
+func (r *Router) UseTrustedProxies(proxies *TrustedProxies) *Router {
+	r.trustedProxies = proxies
+	return r
+}
+
+// ClientIP returns the address of the client. Behind trusted proxies it is read from the
+// Forwarded, X-Forwarded-For or X-Real-IP headers, walking the chain of proxies back from
+// the server up to the first address that isn't trusted.
+func (r *Request) ClientIP() string {
+	addr := r.forwarded().addr
+	if !addr.IsValid() {
+		return ""
+	}
+	return addr.String()
+}
+
+// Scheme returns "https" or "http", as seen by the client when the request went through trusted proxies
+func (r *Request) Scheme() string {
+	return r.forwarded().proto
+}
+
+// Host returns the host the client sent the request to, with its port if any,
+// as seen by the client when the request went through trusted proxies
+func (r *Request) Host() string {
+	return r.forwarded().host
+}
+
+// forwardedHop describes the request sent by the client to the server or to the first trusted proxy
+type forwardedHop struct {
+	addr  netip.Addr
+	proto string
+	host  string
+}
+
+func (r *Request) forwarded() forwardedHop {
+	current := forwardedHop{addr: parseNodeAddr(r.remoteAddr), proto: "http", host: r.GetHeader("Host")}
+	if r.tls {
+		current.proto = "https"
+	}
+	var proxies *TrustedProxies
+	if r.router != nil {
+		proxies = r.router.trustedProxies
+	}
+	if !proxies.Contains(current.addr) {
+		return current
+	}
+
+	// Forwarded has one element per proxy with the client address, scheme and host it received,
+	// the element of the client is the one to use
+	if forwarded := r.GetHeader("Forwarded"); forwarded != "" {
+		elements := parseForwarded(forwarded)
+		for i := len(elements) - 1; i >= 0; i-- {
+			addr := parseNodeAddr(elements[i]["for"])
+			if !addr.IsValid() {
+				break
+			}
+			current.addr = addr
+			if proto := strings.ToLower(elements[i]["proto"]); proto == "http" || proto == "https" {
+				current.proto = proto
+			}
+			if host := elements[i]["host"]; host != "" {
+				current.host = host
+			}
+			if !proxies.Contains(addr) {
+				break
+			}
+		}
+		return current
+	}
+
+	// hops counts the X-Forwarded-For entries walked before the one of the client, the proxies
+	// appending to X-Forwarded-Proto and X-Forwarded-Host add their entries along
+	hops := 0
+	if forwardedFor := r.GetHeader("X-Forwarded-For"); forwardedFor != "" {
+		entries := strings.Split(forwardedFor, ",")
+		for i := len(entries) - 1; i >= 0; i-- {
+			addr := parseNodeAddr(entries[i])
+			if !addr.IsValid() {
+				break
+			}
+			current.addr = addr
+			if !proxies.Contains(addr) {
+				break
+			}
+			hops++
+		}
+	} else if addr := parseNodeAddr(r.GetHeader("X-Real-IP")); addr.IsValid() {
+		current.addr = addr
+	}
+	if proto := strings.ToLower(forwardedEntry(r.GetHeader("X-Forwarded-Proto"), hops)); proto == "http" || proto == "https" {
+		current.proto = proto
+	}
+	if host := forwardedEntry(r.GetHeader("X-Forwarded-Host"), hops); host != "" {
+		current.host = host
+	}
+	return current
+}
+
+// forwardedEntry returns the entry of a comma separated forwarding header added hops entries
+// before the last one, or the last one when the header is shorter. Entries further left may
+// come from the client itself.
+func forwardedEntry(header string, hops int) string {
+	if header == "" {
+		return ""
+	}
+	entries := strings.Split(header, ",")
+	i := len(entries) - 1 - hops
+	if i < 0 {
+		i = len(entries) - 1
+	}
+	return strings.TrimSpace(entries[i])
+}
+
+// parseNodeAddr parses an address as found in RemoteAddr or forwarding headers, with or
+// without a port, IPv6 addresses in brackets and quotes. Obfuscated and unknown nodes are invalid.
+func parseNodeAddr(node string) netip.Addr {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(node, "["), "]"))
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// parseForwarded parses the elements of a Forwarded header into their lowercase parameters, RFC 7239
+func parseForwarded(header string) []map[string]string {
+	elements := make([]map[string]string, 0)
+	for _, element := range splitQuoted(header, ',') {
+		params := make(map[string]string)
+		for _, pair := range splitQuoted(element, ';') {
+			name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if !found {
+				continue
+			}
+			value = strings.TrimSpace(value)
+			if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+			}
+			params[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+		elements = append(elements, params)
+	}
+	return elements
+}
+
+// splitQuoted splits s on separator outside of quoted strings
+func splitQuoted(s string, separator byte) []string {
+	parts := make([]string, 0)
+	quoted, escaped := false, false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == separator:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,110 @@
+package http
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8", " 192.168.1.10 ", "fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"10.1.2.3":        true,
+		"::ffff:10.1.2.3": true,
+		"192.168.1.10":    true,
+		"192.168.1.11":    false,
+		"fd12::1":         true,
+		"2001:db8::1":     false,
+		"203.0.113.7":     false,
+	} {
+		if got := proxies.Contains(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Contains(%s) = %v, want %v", addr, got, want)
+		}
+	}
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+	if _, err := ParseTrustedProxies("proxy.local"); err == nil {
+		t.Error("host name accepted")
+	}
+}
+
+func TestForwardedRequest(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    string
+		ip         string
+		scheme     string
+		host       string
+	}{
+		{"direct client", "203.0.113.7:5000",
+			"X-Forwarded-For: 10.9.9.9\r\nX-Forwarded-Proto: https\r\nX-Forwarded-Host: spoofed.example\r\n",
+			"203.0.113.7", "http", "example.com"},
+		{"one proxy", "10.0.0.1:5000",
+			"X-Forwarded-For: 203.0.113.7\r\nX-Forwarded-Proto: https\r\nX-Forwarded-Host: real.example\r\n",
+			"203.0.113.7", "https", "real.example"},
+		{"spoofed entries left of the client", "10.0.0.1:5000",
+			"X-Forwarded-For: 1.1.1.1, 203.0.113.7\r\nX-Forwarded-Proto: http, https\r\nX-Forwarded-Host: evil.example, real.example\r\n",
+			"203.0.113.7", "https", "real.example"},
+		{"chain of trusted proxies", "10.0.0.1:5000",
+			"X-Forwarded-For: 1.1.1.1, 203.0.113.7, 10.0.0.2\r\nX-Forwarded-Proto: http, https, http\r\nX-Forwarded-Host: evil.example, real.example, internal.example\r\n",
+			"203.0.113.7", "https", "real.example"},
+		{"single proto and host from the edge", "10.0.0.1:5000",
+			"X-Forwarded-For: 203.0.113.7, 10.0.0.2\r\nX-Forwarded-Proto: https\r\nX-Forwarded-Host: real.example\r\n",
+			"203.0.113.7", "https", "real.example"},
+		{"invalid proto ignored", "10.0.0.1:5000",
+			"X-Forwarded-For: 203.0.113.7\r\nX-Forwarded-Proto: gopher\r\n",
+			"203.0.113.7", "http", "example.com"},
+		{"X-Real-IP", "10.0.0.1:5000",
+			"X-Real-IP: 203.0.113.7\r\n",
+			"203.0.113.7", "http", "example.com"},
+		{"Forwarded", "10.0.0.1:5000",
+			"Forwarded: for=1.1.1.1;host=evil.example, for=203.0.113.7;proto=https;host=\"real.example:8443\", for=10.0.0.2\r\n" +
+				"X-Forwarded-For: 9.9.9.9\r\n",
+			"203.0.113.7", "https", "real.example:8443"},
+		{"Forwarded IPv6", "10.0.0.1:5000",
+			"Forwarded: for=\"[2001:db8::7]:4711\";proto=https\r\n",
+			"2001:db8::7", "https", "example.com"},
+		{"obfuscated node stops the walk", "10.0.0.1:5000",
+			"Forwarded: for=203.0.113.7, for=_hidden, for=10.0.0.2\r\n",
+			"10.0.0.2", "http", "example.com"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := ParseToRequest([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n" + test.headers + "\r\n"))
+			if err != nil {
+				t.Fatalf("parsing request: %v", err)
+			}
+			req.remoteAddr = test.remoteAddr
+			req.router = NewRouter().UseTrustedProxies(proxies)
+			if got := req.ClientIP(); got != test.ip {
+				t.Errorf("ClientIP() = %q, want %q", got, test.ip)
+			}
+			if got := req.Scheme(); got != test.scheme {
+				t.Errorf("Scheme() = %q, want %q", got, test.scheme)
+			}
+			if got := req.Host(); got != test.host {
+				t.Errorf("Host() = %q, want %q", got, test.host)
+			}
+		})
+	}
+}
+
+func TestForwardedRequestOverTLS(t *testing.T) {
+	req, err := ParseToRequest([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nX-Forwarded-Proto: http\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.remoteAddr = "203.0.113.7:5000"
+	req.tls = true
+	if req.Scheme() != "https" || req.ClientIP() != "203.0.113.7" {
+		t.Errorf("Scheme() = %q, ClientIP() = %q without trusted proxies", req.Scheme(), req.ClientIP())
+	}
+}
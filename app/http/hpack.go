@@ -0,0 +1,351 @@
+package http
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// errHPACK is a decoding error, the connection must be closed with COMPRESSION_ERROR
+var errHPACK = errors.New("http2: invalid header block")
+
+// errHeaderListTooLarge is returned when a decoded header list exceeds its limit
+var errHeaderListTooLarge = errors.New("http2: header list too large")
+
+type hpackField struct {
+	name  string
+	value string
+}
+
+// size is the size of an entry in the dynamic table, RFC 7541 section 4.1
+func (f hpackField) size() int {
+	return len(f.name) + len(f.value) + 32
+}
+
+// hpackDecoder decodes the header blocks of one connection, RFC 7541.
+// The dynamic table keeps the newest entry first.
+type hpackDecoder struct {
+	dynamic []hpackField
+	size    int
+	maxSize int
+	// allowedMaxSize is SETTINGS_HEADER_TABLE_SIZE, the limit of the size updates the peer may send
+	allowedMaxSize int
+}
+
+func newHPACKDecoder(maxSize int) *hpackDecoder {
+	return &hpackDecoder{maxSize: maxSize, allowedMaxSize: maxSize}
+}
+
+// decode returns the fields of a complete header block. The whole block is always decoded
+// to keep the dynamic table in sync, errHeaderListTooLarge is returned afterwards when the
+// total size of the fields exceeds maxListSize.
+func (d *hpackDecoder) decode(block []byte, maxListSize int) ([]hpackField, error) {
+	fields := make([]hpackField, 0)
+	listSize := 0
+	headerSeen := false
+	for len(block) > 0 {
+		var field hpackField
+		var err error
+		b := block[0]
+		switch {
+		case b&0x80 != 0:
+			// Indexed header field, section 6.1
+			var index uint64
+			index, block, err = readHPACKInteger(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			field, err = d.field(index)
+			if err != nil {
+				return nil, err
+			}
+		case b&0xc0 == 0x40:
+			// Literal with incremental indexing, section 6.2.1
+			field, block, err = d.readLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.add(field)
+		case b&0xe0 == 0x20:
+			// Dynamic table size update, section 6.3, only allowed before the first field
+			if headerSeen {
+				return nil, errHPACK
+			}
+			var size uint64
+			size, block, err = readHPACKInteger(block, 5)
+			if err != nil || size > uint64(d.allowedMaxSize) {
+				return nil, errHPACK
+			}
+			d.maxSize = int(size)
+			d.evict()
+			continue
+		default:
+			// Literal without indexing or never indexed, sections 6.2.2 and 6.2.3
+			field, block, err = d.readLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+		}
+		headerSeen = true
+		listSize += field.size()
+		if listSize <= maxListSize {
+			fields = append(fields, field)
+		}
+	}
+	if listSize > maxListSize {
+		return nil, errHeaderListTooLarge
+	}
+	return fields, nil
+}
+
+func (d *hpackDecoder) field(index uint64) (hpackField, error) {
+	switch {
+	case index == 0:
+		return hpackField{}, errHPACK
+	case index <= uint64(len(hpackStaticTable)):
+		return hpackStaticTable[index-1], nil
+	case index-uint64(len(hpackStaticTable)) <= uint64(len(d.dynamic)):
+		return d.dynamic[index-uint64(len(hpackStaticTable))-1], nil
+	default:
+		return hpackField{}, errHPACK
+	}
+}
+
+// readLiteral reads a literal field whose name is indexed with an n-bit prefix or sent as a string
+func (d *hpackDecoder) readLiteral(block []byte, n uint) (hpackField, []byte, error) {
+	index, block, err := readHPACKInteger(block, n)
+	if err != nil {
+		return hpackField{}, nil, err
+	}
+	var field hpackField
+	if index > 0 {
+		indexed, err := d.field(index)
+		if err != nil {
+			return hpackField{}, nil, err
+		}
+		field.name = indexed.name
+	} else {
+		field.name, block, err = readHPACKString(block)
+		if err != nil {
+			return hpackField{}, nil, err
+		}
+	}
+	field.value, block, err = readHPACKString(block)
+	if err != nil {
+		return hpackField{}, nil, err
+	}
+	return field, block, nil
+}
+
+func (d *hpackDecoder) add(field hpackField) {
+	if field.size() > d.maxSize {
+		// An entry larger than the table empties it, section 4.4
+		d.dynamic = d.dynamic[:0]
+		d.size = 0
+		return
+	}
+	d.dynamic = append([]hpackField{field}, d.dynamic...)
+	d.size += field.size()
+	d.evict()
+}
+
+func (d *hpackDecoder) evict() {
+	for d.size > d.maxSize && len(d.dynamic) > 0 {
+		last := d.dynamic[len(d.dynamic)-1]
+		d.dynamic = d.dynamic[:len(d.dynamic)-1]
+		d.size -= last.size()
+	}
+}
+
+// readHPACKInteger decodes an integer with an n-bit prefix, RFC 7541 section 5.1
+func readHPACKInteger(block []byte, n uint) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, errHPACK
+	}
+	max := uint64(1)<<n - 1
+	value := uint64(block[0]) & max
+	block = block[1:]
+	if value < max {
+		return value, block, nil
+	}
+	shift := uint(0)
+	for len(block) > 0 {
+		b := block[0]
+		block = block[1:]
+		value += uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value, block, nil
+		}
+		shift += 7
+		if shift > 56 {
+			break
+		}
+	}
+	return 0, nil, errHPACK
+}
+
+// readHPACKString decodes a string literal, Huffman encoded or not, RFC 7541 section 5.2
+func readHPACKString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, errHPACK
+	}
+	huffman := block[0]&0x80 != 0
+	length, block, err := readHPACKInteger(block, 7)
+	if err != nil || uint64(len(block)) < length {
+		return "", nil, errHPACK
+	}
+	raw := block[:length]
+	block = block[length:]
+	if !huffman {
+		return string(raw), block, nil
+	}
+	decoded, err := huffmanDecode(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	return decoded, block, nil
+}
+
+// hpackEncoder encodes response headers without using the dynamic table, fields matching
+// the static table are indexed and the others sent as literals, Huffman encoded when shorter
+type hpackEncoder struct{}
+
+func (hpackEncoder) encode(fields []hpackField) []byte {
+	block := make([]byte, 0, 256)
+	for _, field := range fields {
+		nameIndex := 0
+		for i, static := range hpackStaticTable {
+			if static.name != field.name {
+				continue
+			}
+			if static.value == field.value {
+				nameIndex = -(i + 1)
+				break
+			}
+			if nameIndex == 0 {
+				nameIndex = i + 1
+			}
+		}
+		if nameIndex < 0 {
+			block = appendHPACKInteger(block, 0x80, 7, uint64(-nameIndex))
+			continue
+		}
+		// Literal without indexing, section 6.2.2
+		block = appendHPACKInteger(block, 0x00, 4, uint64(nameIndex))
+		if nameIndex == 0 {
+			block = appendHPACKString(block, field.name)
+		}
+		block = appendHPACKString(block, field.value)
+	}
+	return block
+}
+
+func appendHPACKInteger(block []byte, flags byte, n uint, value uint64) []byte {
+	max := uint64(1)<<n - 1
+	if value < max {
+		return append(block, flags|byte(value))
+	}
+	block = append(block, flags|byte(max))
+	value -= max
+	for value >= 0x80 {
+		block = append(block, byte(value&0x7f)|0x80)
+		value >>= 7
+	}
+	return append(block, byte(value))
+}
+
+func appendHPACKString(block []byte, value string) []byte {
+	if length := huffmanEncodedLength(value); length < len(value) {
+		block = appendHPACKInteger(block, 0x80, 7, uint64(length))
+		return huffmanEncode(block, value)
+	}
+	block = appendHPACKInteger(block, 0x00, 7, uint64(len(value)))
+	return append(block, value...)
+}
+
+func huffmanEncodedLength(value string) int {
+	bits := 0
+	for i := 0; i < len(value); i++ {
+		bits += int(huffmanCodeLengths[value[i]])
+	}
+	return (bits + 7) / 8
+}
+
+func huffmanEncode(block []byte, value string) []byte {
+	var pending uint64
+	bits := uint(0)
+	for i := 0; i < len(value); i++ {
+		length := uint(huffmanCodeLengths[value[i]])
+		pending = pending<<length | uint64(huffmanCodes[value[i]])
+		bits += length
+		for bits >= 8 {
+			bits -= 8
+			block = append(block, byte(pending>>bits))
+		}
+	}
+	if bits > 0 {
+		// The last byte is padded with the most significant bits of EOS, which are all ones
+		block = append(block, byte(pending<<(8-bits))|byte(0xff>>bits))
+	}
+	return block
+}
+
+// huffmanNode is a node of the decoding tree, leaves have no children
+type huffmanNode struct {
+	children [2]*huffmanNode
+	symbol   byte
+}
+
+var (
+	huffmanTree     *huffmanNode
+	huffmanTreeOnce sync.Once
+)
+
+func buildHuffmanTree() {
+	huffmanTree = &huffmanNode{}
+	for symbol := 0; symbol < 256; symbol++ {
+		node := huffmanTree
+		code, length := huffmanCodes[symbol], huffmanCodeLengths[symbol]
+		for i := int(length) - 1; i >= 0; i-- {
+			bit := (code >> uint(i)) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &huffmanNode{}
+			}
+			node = node.children[bit]
+		}
+		node.symbol = byte(symbol)
+	}
+}
+
+// huffmanDecode decodes a Huffman encoded string, the padding must be shorter than
+// a byte and made of ones, RFC 7541 section 5.2
+func huffmanDecode(encoded []byte) (string, error) {
+	huffmanTreeOnce.Do(buildHuffmanTree)
+	decoded := make([]byte, 0, len(encoded)*8/5)
+	node := huffmanTree
+	depth, ones := 0, true
+	for _, b := range encoded {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+			node = node.children[bit]
+			if node == nil {
+				return "", errHPACK
+			}
+			depth++
+			ones = ones && bit == 1
+			if node.children[0] == nil && node.children[1] == nil {
+				decoded = append(decoded, node.symbol)
+				node, depth, ones = huffmanTree, 0, true
+			}
+		}
+	}
+	if depth > 7 || !ones {
+		return "", errHPACK
+	}
+	return string(decoded), nil
+}
+
+// statusField returns the :status pseudo header of a response
+func statusField(code StatusCode) hpackField {
+	return hpackField{name: ":status", value: strconv.Itoa(code.Int())}
+}
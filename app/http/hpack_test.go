@@ -0,0 +1,165 @@
+package http
+
+import (
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	decoded, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return decoded
+}
+
+func TestHPACKIntegers(t *testing.T) {
+	// Examples of RFC 7541 appendix C.1
+	tests := []struct {
+		value uint64
+		n     uint
+		hex   string
+	}{
+		{10, 5, "0a"},
+		{1337, 5, "1f9a0a"},
+		{42, 8, "2a"},
+	}
+	for _, test := range tests {
+		encoded := appendHPACKInteger(nil, 0, test.n, test.value)
+		if hex.EncodeToString(encoded) != test.hex {
+			t.Errorf("appendHPACKInteger(%d, %d) = %x, want %s", test.value, test.n, encoded, test.hex)
+		}
+		value, rest, err := readHPACKInteger(encoded, test.n)
+		if err != nil || value != test.value || len(rest) != 0 {
+			t.Errorf("readHPACKInteger(%s) = %d, %x, %v", test.hex, value, rest, err)
+		}
+	}
+	if _, _, err := readHPACKInteger([]byte{0x1f, 0x9a}, 5); err == nil {
+		t.Error("truncated integer decoded")
+	}
+}
+
+func TestHuffman(t *testing.T) {
+	// Example of RFC 7541 appendix C.4.1
+	encoded := huffmanEncode(nil, "www.example.com")
+	if hex.EncodeToString(encoded) != "f1e3c2e5f23a6ba0ab90f4ff" {
+		t.Errorf("huffmanEncode = %x", encoded)
+	}
+	for _, value := range []string{"", "a", "no-cache", "custom-value", "Mon, 21 Oct 2013 20:13:21 GMT", "\x00\xff~|{}"} {
+		decoded, err := huffmanDecode(huffmanEncode(nil, value))
+		if err != nil || decoded != value {
+			t.Errorf("huffman round trip of %q = %q, %v", value, decoded, err)
+		}
+	}
+	// Padding longer than 7 bits or not made of the EOS prefix is an error, section 5.2
+	for _, invalid := range []string{"f1e3c2e5f23a6ba0ab90f4ffff", "f1e3c2e5f23a6ba0ab90f400"} {
+		if _, err := huffmanDecode(mustHex(t, invalid)); err == nil {
+			t.Errorf("huffmanDecode(%s) accepted invalid padding", invalid)
+		}
+	}
+}
+
+type hpackExample struct {
+	block     string
+	fields    []hpackField
+	tableSize int
+}
+
+func runHPACKExamples(t *testing.T, decoder *hpackDecoder, examples []hpackExample) {
+	t.Helper()
+	for i, example := range examples {
+		fields, err := decoder.decode(mustHex(t, example.block), 1<<20)
+		if err != nil {
+			t.Fatalf("block %d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(fields, example.fields) {
+			t.Errorf("block %d: fields = %v, want %v", i+1, fields, example.fields)
+		}
+		if decoder.size != example.tableSize {
+			t.Errorf("block %d: dynamic table size = %d, want %d", i+1, decoder.size, example.tableSize)
+		}
+	}
+}
+
+func TestHPACKRequestExamples(t *testing.T) {
+	first := []hpackField{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}}
+	second := append(append([]hpackField{}, first...), hpackField{"cache-control", "no-cache"})
+	third := []hpackField{{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"}, {":authority", "www.example.com"}, {"custom-key", "custom-value"}}
+
+	t.Run("without Huffman", func(t *testing.T) {
+		// RFC 7541 appendix C.3
+		runHPACKExamples(t, newHPACKDecoder(4096), []hpackExample{
+			{"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d", first, 57},
+			{"8286 84be 5808 6e6f 2d63 6163 6865", second, 110},
+			{"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65", third, 164},
+		})
+	})
+	t.Run("with Huffman", func(t *testing.T) {
+		// RFC 7541 appendix C.4
+		runHPACKExamples(t, newHPACKDecoder(4096), []hpackExample{
+			{"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff", first, 57},
+			{"8286 84be 5886 a8eb 1064 9cbf", second, 110},
+			{"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf", third, 164},
+		})
+	})
+}
+
+func TestHPACKEviction(t *testing.T) {
+	// RFC 7541 appendix C.5, a 256 bytes table evicts the oldest entries
+	date1 := hpackField{"date", "Mon, 21 Oct 2013 20:13:21 GMT"}
+	location := hpackField{"location", "https://www.example.com"}
+	private := hpackField{"cache-control", "private"}
+	runHPACKExamples(t, newHPACKDecoder(256), []hpackExample{
+		{"4803 3330 3258 0770 7269 7661 7465 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3120 474d 546e 1768 7474 7073 3a2f 2f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+			[]hpackField{{":status", "302"}, private, date1, location}, 222},
+		{"4803 3330 37c1 c0bf",
+			[]hpackField{{":status", "307"}, private, date1, location}, 222},
+		{"88c1 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3220 474d 54c0 5a04 677a 6970 7738 666f 6f3d 4153 444a 4b48 514b 425a 584f 5157 454f 5049 5541 5851 5745 4f49 553b 206d 6178 2d61 6765 3d33 3630 303b 2076 6572 7369 6f6e 3d31",
+			[]hpackField{{":status", "200"}, private, {"date", "Mon, 21 Oct 2013 20:13:22 GMT"}, location,
+				{"content-encoding", "gzip"}, {"set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"}}, 215},
+	})
+}
+
+func TestHPACKDecoderErrors(t *testing.T) {
+	tests := map[string]string{
+		"index zero":                  "80",
+		"index past the tables":       "ff00",
+		"truncated string":            "400a6375",
+		"size update after a field":   "8220",
+		"size update over the limit":  "3fe21f",
+		"literal name index too high": "7f0001",
+	}
+	for name, block := range tests {
+		if _, err := newHPACKDecoder(4096).decode(mustHex(t, block), 1<<20); err == nil {
+			t.Errorf("%s: block %s decoded", name, block)
+		}
+	}
+
+	block := hpackEncoder{}.encode([]hpackField{{"x-large", strings.Repeat("a", 100)}})
+	if _, err := newHPACKDecoder(4096).decode(block, 64); err != errHeaderListTooLarge {
+		t.Errorf("oversized list: err = %v, want errHeaderListTooLarge", err)
+	}
+}
+
+func TestHPACKEncoderRoundTrip(t *testing.T) {
+	fields := []hpackField{
+		statusField(StatusOK),
+		{"content-type", "text/html; charset=utf-8"},
+		{"content-length", "1234"},
+		{"x-request-id", "01a15548-4fc3-7002-8e26-d3cb0504ce12"},
+		{"accept-ranges", "bytes"},
+		{"set-cookie", "session=abc; Path=/; HttpOnly"},
+	}
+	block := hpackEncoder{}.encode(fields)
+	decoded, err := newHPACKDecoder(4096).decode(block, 1<<20)
+	if err != nil || !reflect.DeepEqual(decoded, fields) {
+		t.Errorf("round trip = %v, %v", decoded, err)
+	}
+	// ":status: 200" and "accept-ranges: bytes" are in the static table, they take one byte each
+	if block[0] != 0x88 {
+		t.Errorf(":status 200 encoded as %x", block[0])
+	}
+}
@@ -0,0 +1,963 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// http2Preface is the connection preface every HTTP/2 client starts with, RFC 9113 section 3.4
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// Frame types, RFC 9113 section 6
+const (
+	http2FrameData         byte = 0x0
+	http2FrameHeaders      byte = 0x1
+	http2FramePriority     byte = 0x2
+	http2FrameRSTStream    byte = 0x3
+	http2FrameSettings     byte = 0x4
+	http2FramePushPromise  byte = 0x5
+	http2FramePing         byte = 0x6
+	http2FrameGoAway       byte = 0x7
+	http2FrameWindowUpdate byte = 0x8
+	http2FrameContinuation byte = 0x9
+)
+
+const (
+	http2FlagEndStream  byte = 0x1
+	http2FlagAck        byte = 0x1
+	http2FlagEndHeaders byte = 0x4
+	http2FlagPadded     byte = 0x8
+	http2FlagPriority   byte = 0x20
+)
+
+// Settings, RFC 9113 section 6.5.2
+const (
+	http2SettingHeaderTableSize      uint16 = 0x1
+	http2SettingEnablePush           uint16 = 0x2
+	http2SettingMaxConcurrentStreams uint16 = 0x3
+	http2SettingInitialWindowSize    uint16 = 0x4
+	http2SettingMaxFrameSize         uint16 = 0x5
+	http2SettingMaxHeaderListSize    uint16 = 0x6
+)
+
+type http2ErrorCode uint32
+
+// Error codes, RFC 9113 section 7
+const (
+	http2NoError          http2ErrorCode = 0x0
+	http2ProtocolError    http2ErrorCode = 0x1
+	http2InternalError    http2ErrorCode = 0x2
+	http2FlowControlError http2ErrorCode = 0x3
+	http2StreamClosed     http2ErrorCode = 0x5
+	http2FrameSizeError   http2ErrorCode = 0x6
+	http2RefusedStream    http2ErrorCode = 0x7
+	http2Cancel           http2ErrorCode = 0x8
+	http2CompressionError http2ErrorCode = 0x9
+	http2EnhanceYourCalm  http2ErrorCode = 0xb
+)
+
+const (
+	http2MaxWindowSize        = 1<<31 - 1
+	http2DefaultWindowSize    = 65535
+	http2DefaultMaxFrameSize  = 16384
+	http2MaxConcurrentStreams = 100
+	// http2ReceiveWindow is the flow control window of this side, for the connection and every stream
+	http2ReceiveWindow = 1 << 20
+)
+
+// http2ConnError closes the connection with a GOAWAY frame
+type http2ConnError struct {
+	code   http2ErrorCode
+	reason string
+}
+
+func (e http2ConnError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.code, e.reason)
+}
+
+// errHTTP2StreamReset is returned when writing on a stream that was reset or whose connection is gone
+var errHTTP2StreamReset = errors.New("http2: stream reset")
+
+type http2StreamState int
+
+const (
+	http2StateOpen http2StreamState = iota
+	http2StateHalfClosedRemote
+	http2StateClosed
+)
+
+type http2Stream struct {
+	id      uint32
+	state   http2StreamState
+	request *Request
+	body    bytes.Buffer
+	// contentLength is the announced size of the body, or -1
+	contentLength int64
+	sendWindow    int64
+	reset         bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+}
+
+// http2Conn serves the streams of one HTTP/2 connection, each stream is resolved
+// by the router in a goroutine of its own like an HTTP/1.1 request
+type http2Conn struct {
+	server *HttpServer
+	conn   net.Conn
+	reader *bufio.Reader
+	router *Router
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	writeMu sync.Mutex
+	writer  *bufio.Writer
+
+	// mu guards the streams and the flow control windows, cond is signalled when they change
+	mu               sync.Mutex
+	cond             *sync.Cond
+	streams          map[uint32]*http2Stream
+	lastStreamID     uint32
+	sendWindow       int64
+	peerWindowSize   int64
+	peerMaxFrameSize int
+	goingAway        bool
+	closed           bool
+	handlers         sync.WaitGroup
+
+	decoder *hpackDecoder
+	encoder hpackEncoder
+	// headerStream is the stream whose header block continues in CONTINUATION frames
+	headerStream    uint32
+	headerBlock     []byte
+	headerEndStream bool
+}
+
+type http2Frame struct {
+	frameType byte
+	flags     byte
+	streamID  uint32
+	payload   []byte
+}
+
+func newHTTP2Conn(server *HttpServer, conn net.Conn, reader *bufio.Reader, router *Router) *http2Conn {
+	c := &http2Conn{
+		server:           server,
+		conn:             conn,
+		reader:           reader,
+		router:           router,
+		writer:           bufio.NewWriterSize(conn, 2*http2DefaultMaxFrameSize),
+		streams:          make(map[uint32]*http2Stream),
+		sendWindow:       http2DefaultWindowSize,
+		peerWindowSize:   http2DefaultWindowSize,
+		peerMaxFrameSize: http2DefaultMaxFrameSize,
+		decoder:          newHPACKDecoder(4096),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	c.ctx, c.cancel = context.WithCancel(server.ctx)
+	return c
+}
+
+// serve runs the connection until the client or the server closes it. For connections
+// upgraded from HTTP/1.1, upgrade is the request answered on stream 1 and settings the
+// decoded HTTP2-Settings header.
+func (c *http2Conn) serve(upgrade *Request, settings []byte) error {
+	defer c.shutdown()
+
+	c.writeFrame(http2FrameSettings, 0, 0, http2SettingsPayload(map[uint16]uint32{
+		http2SettingMaxConcurrentStreams: http2MaxConcurrentStreams,
+		http2SettingInitialWindowSize:    http2ReceiveWindow,
+		http2SettingMaxHeaderListSize:    MaxHeaderBytes,
+	}))
+	c.writeWindowUpdate(0, http2ReceiveWindow-http2DefaultWindowSize)
+
+	preface := make([]byte, len(http2Preface))
+	if _, err := io.ReadFull(c.reader, preface); err != nil || string(preface) != http2Preface {
+		return errors.New("http2: invalid connection preface")
+	}
+
+	if upgrade != nil {
+		if err := c.applySettings(settings); err != nil {
+			return c.goAway(err)
+		}
+		stream := c.newStream(1, http2StateHalfClosedRemote, -1)
+		c.lastStreamID = 1
+		upgrade.ctx = stream.ctx
+		stream.request = upgrade
+		c.dispatch(stream)
+	}
+
+	// The server sends GOAWAY and lets the streams in flight finish when it shuts down
+	go func() {
+		select {
+		case <-c.server.ctx.Done():
+			c.goAway(http2ConnError{code: http2NoError, reason: "server shutting down"})
+		case <-c.ctx.Done():
+		}
+	}()
+
+	first := true
+	for {
+		frame, err := c.readFrame()
+		if err != nil {
+			if errors.As(err, new(http2ConnError)) {
+				return c.goAway(err)
+			}
+			return nil
+		}
+		if first && frame.frameType != http2FrameSettings {
+			return c.goAway(http2ConnError{code: http2ProtocolError, reason: "expected SETTINGS"})
+		}
+		first = false
+		if err := c.processFrame(frame); err != nil {
+			return c.goAway(err)
+		}
+	}
+}
+
+// shutdown cancels the streams still running once the connection is done and waits for their handlers
+func (c *http2Conn) shutdown() {
+	c.mu.Lock()
+	c.closed = true
+	for _, stream := range c.streams {
+		stream.cancel()
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.cancel()
+	c.conn.Close()
+	c.handlers.Wait()
+}
+
+// goAway sends a GOAWAY frame. Connection errors close the connection right away, a
+// graceful GOAWAY closes it once the streams in flight are done.
+func (c *http2Conn) goAway(err error) error {
+	connErr := http2ConnError{code: http2InternalError, reason: err.Error()}
+	errors.As(err, &connErr)
+
+	c.mu.Lock()
+	alreadyGoingAway := c.goingAway
+	c.goingAway = true
+	lastStreamID := c.lastStreamID
+	idle := len(c.streams) == 0
+	c.mu.Unlock()
+
+	if !alreadyGoingAway || connErr.code != http2NoError {
+		payload := make([]byte, 8, 8+len(connErr.reason))
+		binary.BigEndian.PutUint32(payload, lastStreamID)
+		binary.BigEndian.PutUint32(payload[4:], uint32(connErr.code))
+		c.writeFrame(http2FrameGoAway, 0, 0, append(payload, connErr.reason...))
+	}
+	if connErr.code != http2NoError || idle {
+		c.conn.Close()
+	}
+	if connErr.code != http2NoError {
+		return err
+	}
+	return nil
+}
+
+func (c *http2Conn) readFrame() (*http2Frame, error) {
+	var header [9]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return nil, err
+	}
+	length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	frame := &http2Frame{
+		frameType: header[3],
+		flags:     header[4],
+		streamID:  binary.BigEndian.Uint32(header[5:]) & 0x7fffffff,
+	}
+	if length > http2DefaultMaxFrameSize {
+		return nil, http2ConnError{code: http2FrameSizeError, reason: "frame too large"}
+	}
+	frame.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, frame.payload); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (c *http2Conn) processFrame(frame *http2Frame) error {
+	if c.headerStream != 0 && (frame.frameType != http2FrameContinuation || frame.streamID != c.headerStream) {
+		return http2ConnError{code: http2ProtocolError, reason: "expected CONTINUATION"}
+	}
+
+	switch frame.frameType {
+	case http2FrameData:
+		return c.processData(frame)
+	case http2FrameHeaders:
+		return c.processHeaders(frame)
+	case http2FrameContinuation:
+		if c.headerStream == 0 {
+			return http2ConnError{code: http2ProtocolError, reason: "unexpected CONTINUATION"}
+		}
+		c.headerBlock = append(c.headerBlock, frame.payload...)
+		if len(c.headerBlock) > MaxHeaderBytes {
+			return http2ConnError{code: http2EnhanceYourCalm, reason: "header block too large"}
+		}
+		if frame.flags&http2FlagEndHeaders != 0 {
+			streamID, block := c.headerStream, c.headerBlock
+			c.headerStream, c.headerBlock = 0, nil
+			return c.processHeaderBlock(streamID, block, c.headerEndStream)
+		}
+		return nil
+	case http2FramePriority:
+		if frame.streamID == 0 {
+			return http2ConnError{code: http2ProtocolError, reason: "PRIORITY on stream 0"}
+		}
+		if len(frame.payload) != 5 {
+			c.resetStream(frame.streamID, http2FrameSizeError)
+		}
+		return nil
+	case http2FrameRSTStream:
+		return c.processRSTStream(frame)
+	case http2FrameSettings:
+		return c.processSettings(frame)
+	case http2FramePushPromise:
+		return http2ConnError{code: http2ProtocolError, reason: "clients cannot push"}
+	case http2FramePing:
+		if frame.streamID != 0 {
+			return http2ConnError{code: http2ProtocolError, reason: "PING on a stream"}
+		}
+		if len(frame.payload) != 8 {
+			return http2ConnError{code: http2FrameSizeError, reason: "invalid PING"}
+		}
+		if frame.flags&http2FlagAck == 0 {
+			c.writeFrame(http2FramePing, http2FlagAck, 0, frame.payload)
+		}
+		return nil
+	case http2FrameGoAway:
+		if frame.streamID != 0 {
+			return http2ConnError{code: http2ProtocolError, reason: "GOAWAY on a stream"}
+		}
+		c.mu.Lock()
+		c.goingAway = true
+		c.mu.Unlock()
+		return nil
+	case http2FrameWindowUpdate:
+		return c.processWindowUpdate(frame)
+	default:
+		// Unknown frame types are ignored, RFC 9113 section 4.1
+		return nil
+	}
+}
+
+func (c *http2Conn) processData(frame *http2Frame) error {
+	if frame.streamID == 0 {
+		return http2ConnError{code: http2ProtocolError, reason: "DATA on stream 0"}
+	}
+	data, err := removePadding(frame)
+	if err != nil {
+		return err
+	}
+	// Bodies are buffered, so the whole frame is given back to the peer right away
+	if len(frame.payload) > 0 {
+		c.writeWindowUpdate(0, uint32(len(frame.payload)))
+	}
+
+	c.mu.Lock()
+	stream := c.streams[frame.streamID]
+	idle := frame.streamID > c.lastStreamID
+	open := stream != nil && stream.state == http2StateOpen
+	c.mu.Unlock()
+	if !open {
+		if idle {
+			return http2ConnError{code: http2ProtocolError, reason: "DATA on an idle stream"}
+		}
+		c.resetStream(frame.streamID, http2StreamClosed)
+		return nil
+	}
+	if len(frame.payload) > 0 && frame.flags&http2FlagEndStream == 0 {
+		c.writeWindowUpdate(frame.streamID, uint32(len(frame.payload)))
+	}
+
+	stream.body.Write(data)
+	if stream.body.Len() > MaxBodyBytes {
+		c.refuse(stream, StatusRequestEntityTooLarge, "request body is too large")
+		return nil
+	}
+	if frame.flags&http2FlagEndStream != 0 {
+		if stream.contentLength >= 0 && int64(stream.body.Len()) != stream.contentLength {
+			c.closeStream(stream)
+			c.resetStream(stream.id, http2ProtocolError)
+			return nil
+		}
+		c.halfCloseRemote(stream)
+		c.dispatch(stream)
+	}
+	return nil
+}
+
+func (c *http2Conn) processHeaders(frame *http2Frame) error {
+	if frame.streamID == 0 {
+		return http2ConnError{code: http2ProtocolError, reason: "HEADERS on stream 0"}
+	}
+	block, err := removePadding(frame)
+	if err != nil {
+		return err
+	}
+	if frame.flags&http2FlagPriority != 0 {
+		if len(block) < 5 {
+			return http2ConnError{code: http2FrameSizeError, reason: "invalid HEADERS"}
+		}
+		block = block[5:]
+	}
+	endStream := frame.flags&http2FlagEndStream != 0
+	if frame.flags&http2FlagEndHeaders == 0 {
+		c.headerStream = frame.streamID
+		c.headerBlock = append([]byte(nil), block...)
+		c.headerEndStream = endStream
+		return nil
+	}
+	return c.processHeaderBlock(frame.streamID, block, endStream)
+}
+
+// processHeaderBlock handles a complete header block, which opens a stream or carries its trailers
+func (c *http2Conn) processHeaderBlock(streamID uint32, block []byte, endStream bool) error {
+	fields, err := c.decoder.decode(block, MaxHeaderBytes)
+	tooLarge := errors.Is(err, errHeaderListTooLarge)
+	if err != nil && !tooLarge {
+		return http2ConnError{code: http2CompressionError, reason: err.Error()}
+	}
+
+	c.mu.Lock()
+	stream := c.streams[streamID]
+	open := stream != nil && stream.state == http2StateOpen
+	c.mu.Unlock()
+	if stream != nil {
+		// Trailers end the request, they are checked but not passed on to the handler
+		if !open || !endStream {
+			c.resetStream(streamID, http2ProtocolError)
+			return nil
+		}
+		for _, field := range fields {
+			if strings.HasPrefix(field.name, ":") {
+				c.resetStream(streamID, http2ProtocolError)
+				return nil
+			}
+		}
+		c.halfCloseRemote(stream)
+		c.dispatch(stream)
+		return nil
+	}
+
+	if streamID%2 == 0 || streamID <= c.lastStreamID {
+		return http2ConnError{code: http2ProtocolError, reason: "invalid stream id"}
+	}
+	c.mu.Lock()
+	c.lastStreamID = streamID
+	refused := c.goingAway || len(c.streams) >= http2MaxConcurrentStreams
+	c.mu.Unlock()
+	if refused {
+		c.resetStream(streamID, http2RefusedStream)
+		return nil
+	}
+
+	state := http2StateOpen
+	if endStream {
+		state = http2StateHalfClosedRemote
+	}
+	stream = c.newStream(streamID, state, -1)
+	if tooLarge {
+		c.refuse(stream, StatusRequestHeaderFieldsTooLarge, "request headers are too large")
+		return nil
+	}
+	request, err := c.newRequest(stream, fields)
+	if err != nil {
+		c.closeStream(stream)
+		c.resetStream(streamID, http2ProtocolError)
+		return nil
+	}
+	stream.request = request
+	if endStream {
+		c.dispatch(stream)
+	}
+	return nil
+}
+
+// newRequest builds the request of a stream from its header fields, RFC 9113 section 8.3
+func (c *http2Conn) newRequest(stream *http2Stream, fields []hpackField) (*Request, error) {
+	var method, path, authority string
+	pseudo := make(map[string]bool)
+	headers := make(map[string]string)
+	regularSeen := false
+	for _, field := range fields {
+		if strings.HasPrefix(field.name, ":") {
+			if regularSeen || pseudo[field.name] {
+				return nil, errors.New("misplaced or repeated pseudo header")
+			}
+			pseudo[field.name] = true
+			switch field.name {
+			case ":method":
+				method = field.value
+			case ":path":
+				path = field.value
+			case ":authority":
+				authority = field.value
+			case ":scheme":
+			default:
+				return nil, errors.New("unknown pseudo header")
+			}
+			continue
+		}
+		regularSeen = true
+		if field.name != strings.ToLower(field.name) || strings.ContainsAny(field.value, "\r\n\x00") {
+			return nil, errors.New("invalid header field")
+		}
+		switch field.name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			return nil, errors.New("connection-specific header field")
+		case "te":
+			if field.value != "trailers" {
+				return nil, errors.New("invalid te header")
+			}
+		}
+		name := textproto.CanonicalMIMEHeaderKey(field.name)
+		separator := ", "
+		if name == "Cookie" {
+			separator = "; "
+		}
+		if existing, exists := headers[name]; exists {
+			headers[name] = existing + separator + field.value
+		} else {
+			headers[name] = field.value
+		}
+	}
+	if method == "" || path == "" || !pseudo[":scheme"] || method == "CONNECT" {
+		return nil, errors.New("missing pseudo header")
+	}
+	if _, exists := headers["Host"]; !exists && authority != "" {
+		headers["Host"] = authority
+	}
+	if value, exists := headers["Content-Length"]; exists {
+		length, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || length < 0 {
+			return nil, errors.New("invalid content-length")
+		}
+		stream.contentLength = length
+	}
+
+	request := &Request{
+		method:     ParseToMethod(method),
+		headers:    headers,
+		ctx:        stream.ctx,
+		remoteAddr: c.conn.RemoteAddr().String(),
+		proto:      "HTTP/2.0",
+	}
+	_, request.tls = c.conn.(*tls.Conn)
+	request.setTarget(path)
+	return request, nil
+}
+
+func (c *http2Conn) processRSTStream(frame *http2Frame) error {
+	if frame.streamID == 0 {
+		return http2ConnError{code: http2ProtocolError, reason: "RST_STREAM on stream 0"}
+	}
+	if len(frame.payload) != 4 {
+		return http2ConnError{code: http2FrameSizeError, reason: "invalid RST_STREAM"}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if frame.streamID > c.lastStreamID {
+		return http2ConnError{code: http2ProtocolError, reason: "RST_STREAM on an idle stream"}
+	}
+	if stream, exists := c.streams[frame.streamID]; exists {
+		stream.reset = true
+		stream.cancel()
+		c.cond.Broadcast()
+	}
+	return nil
+}
+
+func (c *http2Conn) processSettings(frame *http2Frame) error {
+	if frame.streamID != 0 {
+		return http2ConnError{code: http2ProtocolError, reason: "SETTINGS on a stream"}
+	}
+	if frame.flags&http2FlagAck != 0 {
+		if len(frame.payload) != 0 {
+			return http2ConnError{code: http2FrameSizeError, reason: "SETTINGS acknowledgement with a payload"}
+		}
+		return nil
+	}
+	if err := c.applySettings(frame.payload); err != nil {
+		return err
+	}
+	c.writeFrame(http2FrameSettings, http2FlagAck, 0, nil)
+	return nil
+}
+
+// applySettings applies the settings of the peer, a new initial window size is applied
+// to the send window of every open stream
+func (c *http2Conn) applySettings(payload []byte) error {
+	if len(payload)%6 != 0 {
+		return http2ConnError{code: http2FrameSizeError, reason: "invalid SETTINGS"}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ; len(payload) > 0; payload = payload[6:] {
+		id := binary.BigEndian.Uint16(payload)
+		value := binary.BigEndian.Uint32(payload[2:])
+		switch id {
+		case http2SettingEnablePush:
+			if value > 1 {
+				return http2ConnError{code: http2ProtocolError, reason: "invalid SETTINGS_ENABLE_PUSH"}
+			}
+		case http2SettingInitialWindowSize:
+			if value > http2MaxWindowSize {
+				return http2ConnError{code: http2FlowControlError, reason: "invalid SETTINGS_INITIAL_WINDOW_SIZE"}
+			}
+			delta := int64(value) - c.peerWindowSize
+			for _, stream := range c.streams {
+				stream.sendWindow += delta
+				if stream.sendWindow > http2MaxWindowSize {
+					return http2ConnError{code: http2FlowControlError, reason: "stream window overflow"}
+				}
+			}
+			c.peerWindowSize = int64(value)
+		case http2SettingMaxFrameSize:
+			if value < http2DefaultMaxFrameSize || value > 1<<24-1 {
+				return http2ConnError{code: http2ProtocolError, reason: "invalid SETTINGS_MAX_FRAME_SIZE"}
+			}
+			c.peerMaxFrameSize = int(value)
+		}
+		// The header table size is ignored since the encoder doesn't use the dynamic table
+	}
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *http2Conn) processWindowUpdate(frame *http2Frame) error {
+	if len(frame.payload) != 4 {
+		return http2ConnError{code: http2FrameSizeError, reason: "invalid WINDOW_UPDATE"}
+	}
+	increment := int64(binary.BigEndian.Uint32(frame.payload) & 0x7fffffff)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if frame.streamID == 0 {
+		if increment == 0 {
+			return http2ConnError{code: http2ProtocolError, reason: "zero WINDOW_UPDATE"}
+		}
+		c.sendWindow += increment
+		if c.sendWindow > http2MaxWindowSize {
+			return http2ConnError{code: http2FlowControlError, reason: "connection window overflow"}
+		}
+		c.cond.Broadcast()
+		return nil
+	}
+
+	if frame.streamID > c.lastStreamID {
+		return http2ConnError{code: http2ProtocolError, reason: "WINDOW_UPDATE on an idle stream"}
+	}
+	stream, exists := c.streams[frame.streamID]
+	if !exists {
+		return nil
+	}
+	if increment == 0 || stream.sendWindow+increment > http2MaxWindowSize {
+		code := http2ProtocolError
+		if increment != 0 {
+			code = http2FlowControlError
+		}
+		stream.reset = true
+		stream.cancel()
+		go c.resetStream(stream.id, code)
+		// The window of a reset stream is left as it was, writers blocked on it give up
+		c.cond.Broadcast()
+		return nil
+	}
+	stream.sendWindow += increment
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *http2Conn) newStream(id uint32, state http2StreamState, contentLength int64) *http2Stream {
+	stream := &http2Stream{id: id, state: state, contentLength: contentLength}
+	stream.ctx, stream.cancel = context.WithCancel(c.ctx)
+	c.mu.Lock()
+	stream.sendWindow = c.peerWindowSize
+	c.streams[id] = stream
+	c.mu.Unlock()
+	return stream
+}
+
+// halfCloseRemote marks the request of a stream as complete
+func (c *http2Conn) halfCloseRemote(stream *http2Stream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stream.state = http2StateHalfClosedRemote
+}
+
+// closeStream forgets a stream, once the server is going away the connection is closed with the last stream
+func (c *http2Conn) closeStream(stream *http2Stream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stream.state = http2StateClosed
+	stream.cancel()
+	delete(c.streams, stream.id)
+	if c.goingAway && len(c.streams) == 0 {
+		c.conn.Close()
+	}
+}
+
+// dispatch resolves the request of a stream with the router and writes the response
+func (c *http2Conn) dispatch(stream *http2Stream) {
+	request := stream.request
+	request.rawBody = stream.body.Bytes()
+	encoding := request.GetHeader("Content-Encoding")
+	if len(request.rawBody) > 0 && (encoding == "" || encoding == "identity") {
+		body, err := request.parseBody(request.rawBody)
+		if err != nil {
+			c.refuse(stream, StatusBadRequest, "request body cannot be parsed")
+			return
+		}
+		request.body = body
+	}
+	if request.method == InvalidMethod {
+		c.refuse(stream, StatusNotImplemented, "unsupported method")
+		return
+	}
+
+	c.handlers.Add(1)
+	go func() {
+		defer c.handlers.Done()
+		defer c.closeStream(stream)
+
+		response := NewHttpResponse()
+		defer response.runAfterWrite()
+		response.commit = func() (io.Writer, error) {
+			if err := c.writeHeaders(stream, response, false); err != nil {
+				return nil, err
+			}
+			return &http2BodyWriter{conn: c, stream: stream}, nil
+		}
+		c.router.Resolve(request, response)
+		if response.committed {
+			if response.events != nil {
+				response.events.Close()
+			}
+			c.writeData(stream, nil, true)
+			return
+		}
+		response.runBeforeWrite()
+		c.writeResponse(stream, response, request.GetMethod() != HEAD)
+	}()
+}
+
+// refuse answers a stream with an error response without going through the router,
+// the rest of the request is then refused with RST_STREAM. The response is written by
+// another goroutine since it may wait for WINDOW_UPDATE frames this one has to read.
+func (c *http2Conn) refuse(stream *http2Stream, code StatusCode, message string) {
+	c.mu.Lock()
+	open := stream.state == http2StateOpen
+	stream.state = http2StateHalfClosedRemote
+	c.mu.Unlock()
+
+	c.handlers.Add(1)
+	go func() {
+		defer c.handlers.Done()
+		defer c.closeStream(stream)
+		response := NewHttpResponse()
+		response.ErrorResponse(code, message)
+		c.writeResponse(stream, response, true)
+		if open {
+			c.resetStream(stream.id, http2NoError)
+		}
+	}()
+}
+
+func (c *http2Conn) writeResponse(stream *http2Stream, response *Response, withBody bool) error {
+	if !withBody || (response.stream == nil && response.body == "") {
+		return c.writeHeaders(stream, response, true)
+	}
+	if err := c.writeHeaders(stream, response, false); err != nil {
+		return err
+	}
+	if response.stream == nil {
+		if err := c.writeData(stream, []byte(response.body), true); err != nil {
+			return err
+		}
+		response.written.Add(int64(len(response.body)))
+		return nil
+	}
+
+	var w io.Writer = &countingWriter{w: &http2BodyWriter{conn: c, stream: stream}, n: &response.written}
+	if response.wrapWriter != nil {
+		wrapped := response.wrapWriter(w)
+		if err := response.stream(wrapped); err != nil {
+			wrapped.Close()
+			c.resetStream(stream.id, http2InternalError)
+			return err
+		}
+		if err := wrapped.Close(); err != nil {
+			return err
+		}
+	} else if err := response.stream(w); err != nil {
+		c.resetStream(stream.id, http2InternalError)
+		return err
+	}
+	return c.writeData(stream, nil, true)
+}
+
+// writeHeaders sends the status and headers of a response in a HEADERS frame followed by
+// as many CONTINUATION frames as needed, connection-specific headers are dropped
+func (c *http2Conn) writeHeaders(stream *http2Stream, response *Response, endStream bool) error {
+	fields := []hpackField{statusField(response.statusCode)}
+	for name, value := range response.headers {
+		name = strings.ToLower(name)
+		switch name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			continue
+		}
+		fields = append(fields, hpackField{name: name, value: value})
+	}
+	for _, cookie := range response.cookies {
+		fields = append(fields, hpackField{name: "set-cookie", value: cookie.String()})
+	}
+	block := c.encoder.encode(fields)
+
+	c.mu.Lock()
+	maxFrameSize := c.peerMaxFrameSize
+	reset := stream.reset || c.closed
+	c.mu.Unlock()
+	if reset {
+		return errHTTP2StreamReset
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	frameType := http2FrameHeaders
+	var flags byte
+	if endStream {
+		flags = http2FlagEndStream
+	}
+	for {
+		chunk := block
+		if len(chunk) > maxFrameSize {
+			chunk = chunk[:maxFrameSize]
+		}
+		block = block[len(chunk):]
+		if len(block) == 0 {
+			flags |= http2FlagEndHeaders
+		}
+		if err := c.writeFrameLocked(frameType, flags, stream.id, chunk); err != nil {
+			return err
+		}
+		if len(block) == 0 {
+			return nil
+		}
+		frameType, flags = http2FrameContinuation, 0
+	}
+}
+
+// writeData sends data in DATA frames as the flow control windows of the connection and
+// of the stream allow, waiting for WINDOW_UPDATE frames when they are exhausted
+func (c *http2Conn) writeData(stream *http2Stream, data []byte, endStream bool) error {
+	for {
+		c.mu.Lock()
+		for len(data) > 0 && !stream.reset && !c.closed && (c.sendWindow <= 0 || stream.sendWindow <= 0) {
+			c.cond.Wait()
+		}
+		if stream.reset || c.closed {
+			c.mu.Unlock()
+			return errHTTP2StreamReset
+		}
+		n := int64(len(data))
+		n = min(n, c.sendWindow, stream.sendWindow, int64(c.peerMaxFrameSize))
+		c.sendWindow -= n
+		stream.sendWindow -= n
+		c.mu.Unlock()
+
+		var flags byte
+		last := int(n) == len(data)
+		if last && endStream {
+			flags = http2FlagEndStream
+		}
+		if err := c.writeFrame(http2FrameData, flags, stream.id, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+		if last {
+			return nil
+		}
+	}
+}
+
+func (c *http2Conn) resetStream(streamID uint32, code http2ErrorCode) {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(code))
+	c.writeFrame(http2FrameRSTStream, 0, streamID, payload)
+}
+
+func (c *http2Conn) writeWindowUpdate(streamID uint32, increment uint32) {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, increment)
+	c.writeFrame(http2FrameWindowUpdate, 0, streamID, payload)
+}
+
+func (c *http2Conn) writeFrame(frameType byte, flags byte, streamID uint32, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrameLocked(frameType, flags, streamID, payload)
+}
+
+func (c *http2Conn) writeFrameLocked(frameType byte, flags byte, streamID uint32, payload []byte) error {
+	header := [9]byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), frameType, flags}
+	binary.BigEndian.PutUint32(header[5:], streamID)
+	if _, err := c.writer.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := c.writer.Write(payload); err != nil {
+		return err
+	}
+	return c.writer.Flush()
+}
+
+// http2BodyWriter writes a streamed body as DATA frames, every write is sent right away
+type http2BodyWriter struct {
+	conn   *http2Conn
+	stream *http2Stream
+}
+
+func (w *http2BodyWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.conn.writeData(w.stream, p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush is a no-op since every write is sent right away
+func (w *http2BodyWriter) Flush() error {
+	return nil
+}
+
+func removePadding(frame *http2Frame) ([]byte, error) {
+	if frame.flags&http2FlagPadded == 0 {
+		return frame.payload, nil
+	}
+	if len(frame.payload) == 0 || int(frame.payload[0]) >= len(frame.payload) {
+		return nil, http2ConnError{code: http2ProtocolError, reason: "invalid padding"}
+	}
+	return frame.payload[1 : len(frame.payload)-int(frame.payload[0])], nil
+}
+
+func http2SettingsPayload(settings map[uint16]uint32) []byte {
+	payload := make([]byte, 0, 6*len(settings))
+	for id, value := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, id)
+		payload = binary.BigEndian.AppendUint32(payload, value)
+	}
+	return payload
+}
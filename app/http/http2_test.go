@@ -0,0 +1,323 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	nethttp "net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and its key to dir
+func writeTestCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func http2TestRouter() *Router {
+	router := NewRouter()
+	router.Get("/hello", func(req *Request, res *Response) {
+		res.HttpResponse("hello over "+req.GetProto(), StatusOK)
+		res.SetHeader("X-Custom", "value")
+	})
+	router.Post("/echo", func(req *Request, res *Response) {
+		res.HttpResponse(string(req.GetBody()), StatusCreated)
+	})
+	router.Get("/large", func(req *Request, res *Response) {
+		res.HttpResponse(strings.Repeat("0123456789", 50000), StatusOK)
+	})
+	router.Get("/stream", func(req *Request, res *Response) {
+		res.Stream(StatusOK, "text/plain", func(w io.Writer) error {
+			for i := 0; i < 3; i++ {
+				if _, err := io.WriteString(w, "part\n"); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	return router
+}
+
+func TestHTTP2OverTLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t, t.TempDir())
+	server := NewHttpServer("127.0.0.1", "0")
+	go server.ListenTLS(http2TestRouter(), certFile, keyFile)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+	baseURL := "https://" + server.Addr().String()
+	client := &nethttp.Client{Transport: &nethttp.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+
+	response, err := client.Get(baseURL + "/hello")
+	if err != nil {
+		t.Fatalf("GET /hello: %v", err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if response.ProtoMajor != 2 {
+		t.Fatalf("protocol = %s, want HTTP/2", response.Proto)
+	}
+	if string(body) != "hello over HTTP/2.0" || response.Header.Get("X-Custom") != "value" {
+		t.Errorf("body = %q, X-Custom = %q", body, response.Header.Get("X-Custom"))
+	}
+
+	response, err = client.Post(baseURL+"/echo", "text/plain", strings.NewReader(strings.Repeat("b", 100000)))
+	if err != nil {
+		t.Fatalf("POST /echo: %v", err)
+	}
+	body, _ = io.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != 201 || len(body) != 100000 {
+		t.Errorf("echo: status = %d, %d bytes", response.StatusCode, len(body))
+	}
+
+	// Larger than the initial flow control window, the client has to send WINDOW_UPDATE frames
+	response, err = client.Get(baseURL + "/large")
+	if err != nil {
+		t.Fatalf("GET /large: %v", err)
+	}
+	body, _ = io.ReadAll(response.Body)
+	response.Body.Close()
+	if len(body) != 500000 || response.Header.Get("Content-Length") != "500000" {
+		t.Errorf("large body of %d bytes, Content-Length = %q", len(body), response.Header.Get("Content-Length"))
+	}
+
+	response, err = client.Get(baseURL + "/stream")
+	if err != nil {
+		t.Fatalf("GET /stream: %v", err)
+	}
+	body, _ = io.ReadAll(response.Body)
+	response.Body.Close()
+	if string(body) != "part\npart\npart\n" || response.Header.Get("Connection") != "" {
+		t.Errorf("stream body = %q, Connection = %q", body, response.Header.Get("Connection"))
+	}
+}
+
+// h2cClient speaks HTTP/2 with prior knowledge over a raw connection
+type h2cClient struct {
+	t       *testing.T
+	conn    net.Conn
+	reader  *bufio.Reader
+	decoder *hpackDecoder
+}
+
+func dialH2C(t *testing.T, baseURL string) *h2cClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(baseURL, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	client := &h2cClient{t: t, conn: conn, reader: bufio.NewReader(conn), decoder: newHPACKDecoder(4096)}
+	io.WriteString(conn, http2Preface)
+	client.writeFrame(http2FrameSettings, 0, 0, nil)
+	return client
+}
+
+func (c *h2cClient) writeFrame(frameType byte, flags byte, streamID uint32, payload []byte) {
+	c.t.Helper()
+	header := []byte{byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)), frameType, flags, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(header[5:], streamID)
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		c.t.Fatalf("writing frame: %v", err)
+	}
+}
+
+func (c *h2cClient) readFrame() *http2Frame {
+	c.t.Helper()
+	var header [9]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		c.t.Fatalf("reading frame: %v", err)
+	}
+	length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	frame := &http2Frame{frameType: header[3], flags: header[4], streamID: binary.BigEndian.Uint32(header[5:]) & 0x7fffffff}
+	frame.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, frame.payload); err != nil {
+		c.t.Fatalf("reading frame payload: %v", err)
+	}
+	return frame
+}
+
+// response reads frames until the end of streamID and returns its header fields and body
+func (c *h2cClient) response(streamID uint32) (map[string]string, string) {
+	c.t.Helper()
+	headers := make(map[string]string)
+	var body strings.Builder
+	for {
+		frame := c.readFrame()
+		if frame.streamID != streamID {
+			continue
+		}
+		switch frame.frameType {
+		case http2FrameHeaders:
+			fields, err := c.decoder.decode(frame.payload, 1<<20)
+			if err != nil {
+				c.t.Fatalf("decoding headers: %v", err)
+			}
+			for _, field := range fields {
+				headers[field.name] = field.value
+			}
+		case http2FrameData:
+			body.Write(frame.payload)
+		case http2FrameRSTStream:
+			c.t.Fatalf("stream reset with code %d", binary.BigEndian.Uint32(frame.payload))
+		}
+		if frame.flags&http2FlagEndStream != 0 {
+			return headers, body.String()
+		}
+	}
+}
+
+func TestH2CPriorKnowledge(t *testing.T) {
+	baseURL := StartTestServer(t, http2TestRouter())
+	client := dialH2C(t, baseURL)
+
+	block := hpackEncoder{}.encode([]hpackField{
+		{":method", "GET"}, {":scheme", "http"}, {":path", "/hello"}, {":authority", "example.com"},
+	})
+	client.writeFrame(http2FrameHeaders, http2FlagEndHeaders|http2FlagEndStream, 1, block)
+	headers, body := client.response(1)
+	if headers[":status"] != "200" || headers["x-custom"] != "value" || body != "hello over HTTP/2.0" {
+		t.Errorf("headers = %v, body = %q", headers, body)
+	}
+
+	// A request split in HEADERS and CONTINUATION with its body in a DATA frame
+	block = hpackEncoder{}.encode([]hpackField{
+		{":method", "POST"}, {":scheme", "http"}, {":path", "/echo"}, {":authority", "example.com"}, {"content-length", "5"},
+	})
+	client.writeFrame(http2FrameHeaders, 0, 3, block[:4])
+	client.writeFrame(http2FrameContinuation, http2FlagEndHeaders, 3, block[4:])
+	client.writeFrame(http2FrameData, http2FlagEndStream, 3, []byte("hello"))
+	headers, body = client.response(3)
+	if headers[":status"] != "201" || body != "hello" {
+		t.Errorf("headers = %v, body = %q", headers, body)
+	}
+}
+
+func TestH2CConnectionErrors(t *testing.T) {
+	baseURL := StartTestServer(t, http2TestRouter())
+	tests := []struct {
+		name string
+		send func(client *h2cClient)
+		code http2ErrorCode
+	}{
+		{"DATA on stream 0", func(client *h2cClient) {
+			client.writeFrame(http2FrameData, 0, 0, []byte("x"))
+		}, http2ProtocolError},
+		{"even stream ID", func(client *h2cClient) {
+			block := hpackEncoder{}.encode([]hpackField{{":method", "GET"}, {":scheme", "http"}, {":path", "/hello"}})
+			client.writeFrame(http2FrameHeaders, http2FlagEndHeaders|http2FlagEndStream, 2, block)
+		}, http2ProtocolError},
+		{"invalid header block", func(client *h2cClient) {
+			client.writeFrame(http2FrameHeaders, http2FlagEndHeaders|http2FlagEndStream, 1, []byte{0x80})
+		}, http2CompressionError},
+		{"interrupted header block", func(client *h2cClient) {
+			client.writeFrame(http2FrameHeaders, 0, 1, []byte{0x82})
+			client.writeFrame(http2FramePing, 0, 0, make([]byte, 8))
+		}, http2ProtocolError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := dialH2C(t, baseURL)
+			test.send(client)
+			for {
+				frame := client.readFrame()
+				if frame.frameType != http2FrameGoAway {
+					continue
+				}
+				if code := http2ErrorCode(binary.BigEndian.Uint32(frame.payload[4:8])); code != test.code {
+					t.Errorf("GOAWAY code = %d (%s), want %d", code, frame.payload[8:], test.code)
+				}
+				return
+			}
+		})
+	}
+}
+
+func TestH2CPing(t *testing.T) {
+	client := dialH2C(t, StartTestServer(t, http2TestRouter()))
+	client.writeFrame(http2FramePing, 0, 0, []byte("12345678"))
+	for {
+		frame := client.readFrame()
+		if frame.frameType == http2FramePing {
+			if frame.flags&http2FlagAck == 0 || string(frame.payload) != "12345678" {
+				t.Errorf("PING flags = %x, payload = %q", frame.flags, frame.payload)
+			}
+			return
+		}
+	}
+}
+
+func TestH2CStreamWindowOverflow(t *testing.T) {
+	client := dialH2C(t, StartTestServer(t, http2TestRouter()))
+	// The response is larger than the initial window, so the stream waits for WINDOW_UPDATE frames
+	block := hpackEncoder{}.encode([]hpackField{
+		{":method", "GET"}, {":scheme", "http"}, {":path", "/large"}, {":authority", "example.com"},
+	})
+	client.writeFrame(http2FrameHeaders, http2FlagEndHeaders|http2FlagEndStream, 1, block)
+	increment := make([]byte, 4)
+	binary.BigEndian.PutUint32(increment, 0x7fffffff)
+	client.writeFrame(http2FrameWindowUpdate, 0, 1, increment)
+
+	for {
+		frame := client.readFrame()
+		if frame.frameType == http2FrameGoAway {
+			t.Fatal("stream window overflow closed the connection")
+		}
+		if frame.frameType == http2FrameRSTStream && frame.streamID == 1 {
+			if code := http2ErrorCode(binary.BigEndian.Uint32(frame.payload)); code != http2FlowControlError {
+				t.Errorf("RST_STREAM code = %d, want FLOW_CONTROL_ERROR", code)
+			}
+			break
+		}
+	}
+	client.writeFrame(http2FramePing, 0, 0, []byte("still up"))
+	for {
+		if frame := client.readFrame(); frame.frameType == http2FramePing {
+			return
+		}
+	}
+}
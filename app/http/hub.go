@@ -0,0 +1,260 @@
+package http
+
+import (
+	"sync"
+	"time"
+)
+
+// HubOptions configures a Hub
+type HubOptions struct {
+	// SendQueueSize is how many messages can wait for a client, a client whose queue
+	// is full is too slow and gets disconnected
+	SendQueueSize int
+	// WriteTimeout is how long writing one message may take before the client is disconnected
+	WriteTimeout time.Duration
+	// OnConnect runs once a client is registered, it can join rooms or disconnect the client
+	OnConnect func(client *HubClient)
+	// OnDisconnect runs once a client is unregistered
+	OnDisconnect func(client *HubClient)
+}
+
+// DefaultHubOptions are used by NewHub for every zero field of the given options
+var DefaultHubOptions = HubOptions{
+	SendQueueSize: 64,
+	WriteTimeout:  10 * time.Second,
+}
+
+// Hub keeps track of WebSocket clients and the rooms they joined to broadcast messages to them.
+// Clients are authenticated during the upgrade by the middlewares of their route:
+//
+//	hub := http.NewHub(http.HubOptions{})
+//	router.WebSocket("/chat", hub.Handler(onMessage)).
+//		UsePreMiddlewares([]http.MiddlewareFunc{
+//			middleware.WebSocketToken("access_token"),
+//			middleware.BearerAuth("chat", verifier),
+//		}).
+//		Require("member")
+type Hub struct {
+	options HubOptions
+
+	mu      sync.RWMutex
+	clients map[*HubClient]bool
+	rooms   map[string]map[*HubClient]bool
+}
+
+// HubClient is a WebSocket connection registered on a hub, messages sent to it are queued
+// and written by a goroutine of its own
+type HubClient struct {
+	hub   *Hub
+	conn  *WebSocketConn
+	send  chan hubMessage
+	rooms map[string]bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+	// evictOnce starts a single eviction however many messages are dropped meanwhile
+	evictOnce sync.Once
+}
+
+type hubMessage struct {
+	messageType MessageType
+	data        []byte
+}
+
+// NewHub creates an empty hub
+func NewHub(options HubOptions) *Hub {
+	if options.SendQueueSize == 0 {
+		options.SendQueueSize = DefaultHubOptions.SendQueueSize
+	}
+	if options.WriteTimeout == 0 {
+		options.WriteTimeout = DefaultHubOptions.WriteTimeout
+	}
+	return &Hub{
+		options: options,
+		clients: make(map[*HubClient]bool),
+		rooms:   make(map[string]map[*HubClient]bool),
+	}
+}
+
+// Handler returns a WebSocket handler registering every connection on the hub and calling
+// onMessage with the messages it receives, the client is unregistered once it disconnects
+func (h *Hub) Handler(onMessage func(client *HubClient, messageType MessageType, data []byte)) func(conn *WebSocketConn) {
+	return func(conn *WebSocketConn) {
+		client := h.Register(conn)
+		defer h.Unregister(client)
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if onMessage != nil {
+				onMessage(client, messageType, data)
+			}
+		}
+	}
+}
+
+// Register adds a connection to the hub and starts writing its queued messages
+func (h *Hub) Register(conn *WebSocketConn) *HubClient {
+	client := &HubClient{
+		hub:   h,
+		conn:  conn,
+		send:  make(chan hubMessage, h.options.SendQueueSize),
+		rooms: make(map[string]bool),
+		done:  make(chan struct{}),
+	}
+	h.mu.Lock()
+	h.clients[client] = true
+	h.mu.Unlock()
+
+	go client.writeLoop()
+	if h.options.OnConnect != nil {
+		h.options.OnConnect(client)
+	}
+	return client
+}
+
+// Unregister removes a client from the hub and its rooms, then closes its connection
+func (h *Hub) Unregister(client *HubClient) {
+	h.mu.Lock()
+	_, registered := h.clients[client]
+	delete(h.clients, client)
+	for room := range client.rooms {
+		h.removeFromRoom(client, room)
+	}
+	h.mu.Unlock()
+
+	client.closeOnce.Do(func() {
+		close(client.done)
+	})
+	client.conn.Close()
+	if registered && h.options.OnDisconnect != nil {
+		h.options.OnDisconnect(client)
+	}
+}
+
+// Join adds a client to a room, rooms are created on the fly
+func (h *Hub) Join(client *HubClient, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.clients[client] {
+		return
+	}
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[*HubClient]bool)
+	}
+	h.rooms[room][client] = true
+	client.rooms[room] = true
+}
+
+// Leave removes a client from a room, empty rooms are deleted
+func (h *Hub) Leave(client *HubClient, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeFromRoom(client, room)
+}
+
+func (h *Hub) removeFromRoom(client *HubClient, room string) {
+	delete(h.rooms[room], client)
+	if len(h.rooms[room]) == 0 {
+		delete(h.rooms, room)
+	}
+	delete(client.rooms, room)
+}
+
+// Broadcast sends a message to every client of the hub
+func (h *Hub) Broadcast(messageType MessageType, data []byte) {
+	h.mu.RLock()
+	recipients := make([]*HubClient, 0, len(h.clients))
+	for client := range h.clients {
+		recipients = append(recipients, client)
+	}
+	h.mu.RUnlock()
+	for _, client := range recipients {
+		client.Send(messageType, data)
+	}
+}
+
+// BroadcastRoom sends a message to every client in room
+func (h *Hub) BroadcastRoom(room string, messageType MessageType, data []byte) {
+	h.mu.RLock()
+	recipients := make([]*HubClient, 0, len(h.rooms[room]))
+	for client := range h.rooms[room] {
+		recipients = append(recipients, client)
+	}
+	h.mu.RUnlock()
+	for _, client := range recipients {
+		client.Send(messageType, data)
+	}
+}
+
+// Count returns the number of clients registered on the hub
+func (h *Hub) Count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// RoomCount returns the number of clients in room
+func (h *Hub) RoomCount(room string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.rooms[room])
+}
+
+// Conn returns the WebSocket connection of the client
+func (c *HubClient) Conn() *WebSocketConn {
+	return c.conn
+}
+
+// Principal returns the principal authenticated during the upgrade, or nil
+func (c *HubClient) Principal() *Principal {
+	return c.conn.Request().Principal()
+}
+
+// Join adds the client to a room
+func (c *HubClient) Join(room string) {
+	c.hub.Join(c, room)
+}
+
+// Leave removes the client from a room
+func (c *HubClient) Leave(room string) {
+	c.hub.Leave(c, room)
+}
+
+// Send queues a message for the client without blocking. It returns false when the client
+// is gone or when its queue is full, the slow client is then disconnected.
+func (c *HubClient) Send(messageType MessageType, data []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.send <- hubMessage{messageType: messageType, data: data}:
+		return true
+	default:
+		c.evictOnce.Do(func() {
+			go func() {
+				c.conn.CloseWithReason(ClosePolicyViolation, "client too slow")
+				c.hub.Unregister(c)
+			}()
+		})
+		return false
+	}
+}
+
+func (c *HubClient) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case message := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.options.WriteTimeout))
+			if err := c.conn.WriteMessage(message.messageType, message.data); err != nil {
+				c.hub.Unregister(c)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hubTestServer serves hub on /ws, clients join the room named in a "join:" message
+func hubTestServer(t *testing.T, hub *Hub) string {
+	t.Helper()
+	router := NewRouter()
+	router.WebSocket("/ws", hub.Handler(func(client *HubClient, messageType MessageType, data []byte) {
+		if room, ok := strings.CutPrefix(string(data), "join:"); ok {
+			client.Join(room)
+			client.Send(TextMessage, []byte("joined"))
+		}
+	}))
+	return StartTestServer(t, router)
+}
+
+// waitFor polls condition until it holds or a second went by
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func expectText(t *testing.T, reader *bufio.Reader, want string) {
+	t.Helper()
+	frame, err := readServerFrame(t, reader)
+	if err != nil || frame.opcode != TextMessage || string(frame.payload) != want {
+		t.Fatalf("got %d %q, %v, want %q", frame.opcode, frame.payload, err, want)
+	}
+}
+
+func TestHubRoomsAndBroadcast(t *testing.T) {
+	disconnected := make(chan *HubClient, 2)
+	hub := NewHub(HubOptions{OnDisconnect: func(client *HubClient) { disconnected <- client }})
+	baseURL := hubTestServer(t, hub)
+
+	memberConn, member, _ := dialWebSocket(t, baseURL, "/ws")
+	_, other, _ := dialWebSocket(t, baseURL, "/ws")
+	waitFor(t, "both clients", func() bool { return hub.Count() == 2 })
+
+	writeClientFrame(t, memberConn, true, false, TextMessage, []byte("join:news"), true)
+	expectText(t, member, "joined")
+	if hub.RoomCount("news") != 1 {
+		t.Fatalf("room count = %d, want 1", hub.RoomCount("news"))
+	}
+
+	hub.BroadcastRoom("news", TextMessage, []byte("to the room"))
+	hub.Broadcast(TextMessage, []byte("to everyone"))
+	expectText(t, member, "to the room")
+	expectText(t, member, "to everyone")
+	// The other client only gets the broadcast, the room message would come first otherwise
+	expectText(t, other, "to everyone")
+
+	memberConn.Close()
+	waitFor(t, "the member to be unregistered", func() bool { return hub.Count() == 1 })
+	if hub.RoomCount("news") != 0 {
+		t.Errorf("room count = %d after the member left", hub.RoomCount("news"))
+	}
+	select {
+	case client := <-disconnected:
+		client.Join("news")
+		if hub.RoomCount("news") != 0 {
+			t.Error("unregistered client joined a room")
+		}
+	case <-time.After(time.Second):
+		t.Error("OnDisconnect not called")
+	}
+}
+
+func TestHubDisconnectsSlowClients(t *testing.T) {
+	clients := make(chan *HubClient, 1)
+	hub := NewHub(HubOptions{
+		SendQueueSize: 1,
+		WriteTimeout:  100 * time.Millisecond,
+		OnConnect:     func(client *HubClient) { clients <- client },
+	})
+	baseURL := hubTestServer(t, hub)
+	dialWebSocket(t, baseURL, "/ws")
+	client := <-clients
+
+	// The client never reads, so the socket buffers fill up and then its queue
+	large := bytes.Repeat([]byte("x"), 64<<10)
+	dropped := false
+	for i := 0; i < 10000 && !dropped; i++ {
+		dropped = !client.Send(BinaryMessage, large)
+	}
+	if !dropped {
+		t.Fatal("messages for a client that never reads were all queued")
+	}
+	waitFor(t, "the slow client to be disconnected", func() bool { return hub.Count() == 0 })
+	if client.Send(TextMessage, []byte("late")) {
+		t.Error("message queued for a disconnected client")
+	}
+}
+
+func TestHubClientPrincipal(t *testing.T) {
+	clients := make(chan *HubClient, 1)
+	hub := NewHub(HubOptions{OnConnect: func(client *HubClient) { clients <- client }})
+	router := NewRouter()
+	router.WebSocket("/ws", hub.Handler(nil)).UsePreMiddlewares([]MiddlewareFunc{
+		func(req *Request, res *Response, next func()) {
+			req.SetPrincipal(&Principal{ID: "gopher"})
+			next()
+		},
+	})
+	dialWebSocket(t, StartTestServer(t, router), "/ws")
+	select {
+	case client := <-clients:
+		if principal := client.Principal(); principal == nil || principal.ID != "gopher" {
+			t.Errorf("principal = %+v", principal)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client not registered")
+	}
+}
@@ -0,0 +1,115 @@
+package http
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrInvalidSignature is returned when a signed value was tampered with or signed by an unknown key
+	ErrInvalidSignature = errors.New("http: invalid signature")
+	// ErrDecryption is returned when an encrypted value cannot be opened with any key
+	ErrDecryption = errors.New("http: value cannot be decrypted")
+)
+
+// Keyring holds the secrets used to sign and encrypt cookies.
+// The first key is the newest one and is used to sign and encrypt,
+// every key is tried when verifying so old keys can be rotated out gradually.
+type Keyring struct {
+	keys [][]byte
+}
+
+// NewKeyring creates a keyring from one or more secrets, newest first
+func NewKeyring(keys ...[]byte) *Keyring {
+	if len(keys) == 0 {
+		panic("http: keyring needs at least one key")
+	}
+	return &Keyring{keys: keys}
+}
+
+// Sign returns value followed by an HMAC-SHA256 signature bound to name
+func (k *Keyring) Sign(name, value string) string {
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac(k.keys[0], name, value))
+}
+
+// Verify checks a value produced by Sign against every key and returns the original value
+func (k *Keyring) Verify(name, signed string) (string, error) {
+	i := strings.LastIndexByte(signed, '.')
+	if i < 0 {
+		return "", ErrInvalidSignature
+	}
+	value := signed[:i]
+	signature, err := base64.RawURLEncoding.DecodeString(signed[i+1:])
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	for _, key := range k.keys {
+		if hmac.Equal(signature, mac(key, name, value)) {
+			return value, nil
+		}
+	}
+	return "", ErrInvalidSignature
+}
+
+// Encrypt seals value with AES-256-GCM using the newest key, name is authenticated as additional data
+func (k *Keyring) Encrypt(name, value string) (string, error) {
+	aead, err := newAEAD(k.keys[0])
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt, trying every key of the keyring
+func (k *Keyring) Decrypt(name, encrypted string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", ErrDecryption
+	}
+	for _, key := range k.keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return "", err
+		}
+		if len(sealed) < aead.NonceSize() {
+			return "", ErrDecryption
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return string(plaintext), nil
+		}
+	}
+	return "", ErrDecryption
+}
+
+func mac(key []byte, name, value string) []byte {
+	h := hmac.New(sha256.New, deriveKey(key, "sign"))
+	h.Write([]byte(name + "=" + value))
+	return h.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(key, "encrypt"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKey separates the signing and encryption keys so a secret is never used for both
+func deriveKey(secret []byte, purpose string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(purpose))
+	return h.Sum(nil)
+}
@@ -0,0 +1,136 @@
+package http
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestKeyringSignAndVerify(t *testing.T) {
+	keys := NewKeyring([]byte("new secret"), []byte("old secret"))
+	signed := keys.Sign("session", "user=42")
+	if !strings.HasPrefix(signed, "user=42.") {
+		t.Fatalf("signed = %q, want the value followed by its signature", signed)
+	}
+	if value, err := keys.Verify("session", signed); err != nil || value != "user=42" {
+		t.Errorf("Verify = %q, %v", value, err)
+	}
+
+	tampered := []string{
+		"user=43" + signed[len("user=42"):],
+		signed[:len(signed)-2] + "AA",
+		"user=42",
+		"user=42.not base64!",
+	}
+	for _, value := range tampered {
+		if _, err := keys.Verify("session", value); err != ErrInvalidSignature {
+			t.Errorf("Verify(%q) error = %v, want ErrInvalidSignature", value, err)
+		}
+	}
+	// The signature is bound to the cookie name
+	if _, err := keys.Verify("other", signed); err != ErrInvalidSignature {
+		t.Errorf("value signed for session verified as other: %v", err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	old := NewKeyring([]byte("old secret"))
+	rotated := NewKeyring([]byte("new secret"), []byte("old secret"))
+	retired := NewKeyring([]byte("new secret"))
+
+	signed := old.Sign("session", "v")
+	if _, err := rotated.Verify("session", signed); err != nil {
+		t.Errorf("value signed with the old key rejected during rotation: %v", err)
+	}
+	if _, err := retired.Verify("session", signed); err == nil {
+		t.Error("value signed with a retired key accepted")
+	}
+	if rotated.Sign("session", "v") != retired.Sign("session", "v") {
+		t.Error("rotated keyring doesn't sign with its newest key")
+	}
+
+	encrypted, err := old.Encrypt("session", "secret data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := rotated.Decrypt("session", encrypted); err != nil || value != "secret data" {
+		t.Errorf("Decrypt during rotation = %q, %v", value, err)
+	}
+	if _, err := retired.Decrypt("session", encrypted); err != ErrDecryption {
+		t.Errorf("value encrypted with a retired key: error = %v", err)
+	}
+}
+
+func TestKeyringEncryptAndDecrypt(t *testing.T) {
+	keys := NewKeyring([]byte("secret"))
+	first, err := keys.Encrypt("prefs", "theme=dark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := keys.Encrypt("prefs", "theme=dark")
+	if first == second {
+		t.Error("same value encrypted twice gives the same output, the nonce isn't random")
+	}
+	if strings.Contains(first, "dark") {
+		t.Error("plaintext visible in the encrypted value")
+	}
+	if value, err := keys.Decrypt("prefs", first); err != nil || value != "theme=dark" {
+		t.Errorf("Decrypt = %q, %v", value, err)
+	}
+
+	sealed, _ := base64.RawURLEncoding.DecodeString(first)
+	sealed[len(sealed)-1] ^= 1
+	for name, value := range map[string]string{
+		"flipped bit":          base64.RawURLEncoding.EncodeToString(sealed),
+		"not base64":           "***",
+		"shorter than a nonce": "AAAA",
+	} {
+		if _, err := keys.Decrypt("prefs", value); err != ErrDecryption {
+			t.Errorf("%s: error = %v, want ErrDecryption", name, err)
+		}
+	}
+	if _, err := keys.Decrypt("other", first); err != ErrDecryption {
+		t.Errorf("value encrypted for prefs decrypted as other: %v", err)
+	}
+}
+
+func TestSignedAndEncryptedCookies(t *testing.T) {
+	keys := NewKeyring([]byte("secret"))
+	res := NewHttpResponse()
+	if err := res.SetSignedCookie(&Cookie{Name: "user", Value: "42", HttpOnly: true}, keys); err != nil {
+		t.Fatal(err)
+	}
+	if err := res.SetEncryptedCookie(&Cookie{Name: "prefs", Value: "theme=dark"}, keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.cookies) != 2 || !res.cookies[0].HttpOnly {
+		t.Fatalf("cookies = %v", res.cookies)
+	}
+
+	header := res.cookies[0].Name + "=" + res.cookies[0].Value + "; " + res.cookies[1].Name + "=" + res.cookies[1].Value
+	req, err := ParseToRequest([]byte("GET / HTTP/1.1\r\nHost: example.com\r\nCookie: " + header + "; forged=1.AAAA\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cookie, err := req.SignedCookie("user", keys); err != nil || cookie.Value != "42" {
+		t.Errorf("SignedCookie = %+v, %v", cookie, err)
+	}
+	if cookie, err := req.EncryptedCookie("prefs", keys); err != nil || cookie.Value != "theme=dark" {
+		t.Errorf("EncryptedCookie = %+v, %v", cookie, err)
+	}
+	if _, err := req.SignedCookie("forged", keys); err != ErrInvalidSignature {
+		t.Errorf("forged cookie error = %v", err)
+	}
+	if _, err := req.SignedCookie("missing", keys); err != ErrNoCookie {
+		t.Errorf("missing cookie error = %v", err)
+	}
+}
+
+func TestNewKeyringNeedsAKey(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewKeyring without keys didn't panic")
+		}
+	}()
+	NewKeyring()
+}
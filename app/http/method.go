@@ -11,10 +11,12 @@ const (
 	PUT
 	DELETE
 	PATCH
+	HEAD
+	OPTIONS
 )
 
 func (m Method) String() string {
-	return []string{"InvalidMethod", "GET", "POST", "PUT", "DELETE", "PATCH"}[m]
+	return []string{"InvalidMethod", "GET", "POST", "PUT", "DELETE", "PATCH", "HEAD", "OPTIONS"}[m]
 }
 
 func ParseToMethod(method string) Method {
@@ -29,6 +31,10 @@ func ParseToMethod(method string) Method {
 		return DELETE
 	case "PATCH":
 		return PATCH
+	case "HEAD":
+		return HEAD
+	case "OPTIONS":
+		return OPTIONS
 	default:
 		return InvalidMethod
 	}
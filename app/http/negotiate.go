@@ -0,0 +1,281 @@
+package http
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnsupportedPayload is returned by an Encoder that can't represent a payload,
+// Negotiate then tries the next acceptable media type
+var ErrUnsupportedPayload = errors.New("http: payload cannot be encoded in this media type")
+
+// Encoder writes payload in the representation of one media type
+type Encoder func(w io.Writer, payload interface{}) error
+
+type registeredEncoder struct {
+	mediaType string
+	encode    Encoder
+}
+
+var (
+	encodersMu sync.RWMutex
+	// encoders are listed in server preference order, used to break ties between accepted types
+	encoders = []registeredEncoder{
+		{"application/json", encodeJSON},
+		{"application/xml", encodeXML},
+		{"text/csv", encodeCSV},
+		{"text/plain", encodeText},
+		{"text/html", encodeHTML},
+	}
+)
+
+// RegisterEncoder adds an encoder for mediaType or replaces the existing one,
+// new media types come last in the server preference order
+func RegisterEncoder(mediaType string, encoder Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	mediaType = strings.ToLower(mediaType)
+	for i, registered := range encoders {
+		if registered.mediaType == mediaType {
+			encoders[i].encode = encoder
+			return
+		}
+	}
+	encoders = append(encoders, registeredEncoder{mediaType, encoder})
+}
+
+// Negotiate sends payload in the registered media type the client prefers according to
+// the q-values of its Accept header, or a 406 when none of them is acceptable
+func (r *Response) Negotiate(payload interface{}) {
+	accept := ""
+	if r.request != nil {
+		accept = r.request.GetHeader("Accept")
+	}
+	r.AppendHeader("Vary", "Accept")
+
+	for _, candidate := range acceptableEncoders(accept) {
+		var body bytes.Buffer
+		err := candidate.encode(&body, payload)
+		if errors.Is(err, ErrUnsupportedPayload) {
+			continue
+		}
+		if err != nil {
+			r.ErrorResponse(StatusInternalServerError, "response cannot be encoded")
+			return
+		}
+		contentType := candidate.mediaType
+		if strings.HasPrefix(contentType, "text/") || strings.HasSuffix(contentType, "/json") || strings.HasSuffix(contentType, "/xml") {
+			contentType += "; charset=utf-8"
+		}
+		r.SetStatusCode(StatusOK)
+		r.SetHeader("Date", time.Now().UTC().Format(time.RFC1123))
+		r.SetHeader("Server", "GoHTTP/1.0")
+		r.SetHeader("Connection", "close")
+		r.SetHeader("Content-Type", contentType)
+		r.SetBody(body.String())
+		return
+	}
+	r.ErrorResponse(StatusNotAcceptable, "none of the accepted media types can be produced")
+}
+
+// acceptableEncoders returns the encoders matching an Accept header, best first
+func acceptableEncoders(accept string) []registeredEncoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	if strings.TrimSpace(accept) == "" {
+		return append([]registeredEncoder(nil), encoders...)
+	}
+
+	ranges := parseMediaRanges(accept)
+	type scored struct {
+		encoder registeredEncoder
+		q       float64
+		order   int
+	}
+	candidates := make([]scored, 0)
+	for i, encoder := range encoders {
+		if q := mediaTypeQuality(ranges, encoder.mediaType); q > 0 {
+			candidates = append(candidates, scored{encoder, q, i})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	result := make([]registeredEncoder, len(candidates))
+	for i, candidate := range candidates {
+		result[i] = candidate.encoder
+	}
+	return result
+}
+
+type mediaRange struct {
+	mainType string
+	subType  string
+	q        float64
+}
+
+func parseMediaRanges(accept string) []mediaRange {
+	ranges := make([]mediaRange, 0)
+	for _, item := range strings.Split(accept, ",") {
+		parts := strings.Split(item, ";")
+		mainType, subType, found := strings.Cut(strings.ToLower(strings.TrimSpace(parts[0])), "/")
+		if !found {
+			continue
+		}
+		q := 1.0
+		for _, param := range parts[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		ranges = append(ranges, mediaRange{mainType, subType, q})
+	}
+	return ranges
+}
+
+// mediaTypeQuality returns the q-value of the most specific range matching mediaType
+func mediaTypeQuality(ranges []mediaRange, mediaType string) float64 {
+	mainType, subType, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, r := range ranges {
+		current := -1
+		switch {
+		case r.mainType == mainType && r.subType == subType:
+			current = 2
+		case r.mainType == mainType && r.subType == "*":
+			current = 1
+		case r.mainType == "*" && r.subType == "*":
+			current = 0
+		}
+		if current > specificity {
+			q, specificity = r.q, current
+		}
+	}
+	return q
+}
+
+func encodeJSON(w io.Writer, payload interface{}) error {
+	return json.NewEncoder(w).Encode(payload)
+}
+
+func encodeXML(w io.Writer, payload interface{}) error {
+	body, err := xml.Marshal(payload)
+	if err != nil {
+		// Maps and other types without an XML mapping are left to the other encoders
+		return ErrUnsupportedPayload
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+func encodeText(w io.Writer, payload interface{}) error {
+	_, err := fmt.Fprint(w, payload)
+	return err
+}
+
+func encodeHTML(w io.Writer, payload interface{}) error {
+	body, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "<!doctype html>\n<meta charset=\"utf-8\">\n<pre>%s</pre>\n", html.EscapeString(string(body)))
+	return err
+}
+
+// encodeCSV supports [][]string, slices of structs, using the csv tag or the field name
+// as column header, and slices of maps, using the sorted keys as column headers
+func encodeCSV(w io.Writer, payload interface{}) error {
+	writer := csv.NewWriter(w)
+	if records, ok := payload.([][]string); ok {
+		if err := writer.WriteAll(records); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	value := reflect.ValueOf(payload)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return ErrUnsupportedPayload
+	}
+	elemType := value.Type().Elem()
+	for elemType.Kind() == reflect.Pointer {
+		elemType = elemType.Elem()
+	}
+
+	switch elemType.Kind() {
+	case reflect.Struct:
+		fields := make([]int, 0)
+		header := make([]string, 0)
+		for i := 0; i < elemType.NumField(); i++ {
+			field := elemType.Field(i)
+			name := field.Tag.Get("csv")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			fields = append(fields, i)
+			header = append(header, name)
+		}
+		writer.Write(header)
+		for i := 0; i < value.Len(); i++ {
+			elem := reflect.Indirect(value.Index(i))
+			record := make([]string, len(fields))
+			if elem.IsValid() {
+				for j, field := range fields {
+					record[j] = fmt.Sprint(elem.Field(field).Interface())
+				}
+			}
+			writer.Write(record)
+		}
+	case reflect.Map:
+		if elemType.Key().Kind() != reflect.String {
+			return ErrUnsupportedPayload
+		}
+		keySet := make(map[string]bool)
+		for i := 0; i < value.Len(); i++ {
+			for _, key := range value.Index(i).MapKeys() {
+				keySet[key.String()] = true
+			}
+		}
+		header := make([]string, 0, len(keySet))
+		for key := range keySet {
+			header = append(header, key)
+		}
+		sort.Strings(header)
+		writer.Write(header)
+		for i := 0; i < value.Len(); i++ {
+			elem := value.Index(i)
+			record := make([]string, len(header))
+			for j, key := range header {
+				if item := elem.MapIndex(reflect.ValueOf(key).Convert(elemType.Key())); item.IsValid() {
+					record[j] = fmt.Sprint(item.Interface())
+				}
+			}
+			writer.Write(record)
+		}
+	default:
+		return ErrUnsupportedPayload
+	}
+	writer.Flush()
+	return writer.Error()
+}
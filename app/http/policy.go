@@ -0,0 +1,78 @@
+package http
+
+// RequirementKind tells how the values of a requirement are checked
+type RequirementKind int
+
+const (
+	RoleRequirement RequirementKind = iota
+	PermissionRequirement
+)
+
+// Requirement is a condition a principal has to meet to access a route
+type Requirement struct {
+	Kind   RequirementKind
+	Values []string
+}
+
+// Policy decides whether a principal meets a requirement, it can be replaced with Router.UsePolicy
+// to plug role hierarchies, wildcard permissions or an external authorization service
+type Policy interface {
+	Allow(principal *Principal, requirement Requirement) bool
+}
+
+// DefaultPolicy grants a role requirement when the principal has any of its roles
+// and a permission requirement when the principal has all of its permissions
+type DefaultPolicy struct{}
+
+func (DefaultPolicy) Allow(principal *Principal, requirement Requirement) bool {
+	switch requirement.Kind {
+	case RoleRequirement:
+		for _, role := range requirement.Values {
+			if principal.HasRole(role) {
+				return true
+			}
+		}
+		return false
+	case PermissionRequirement:
+		for _, permission := range requirement.Values {
+			if !principal.HasPermission(permission) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// authorize evaluates the requirements of route and of every router serving it, anonymous
+// requests get a 401 and authenticated ones missing a requirement get a 403. The policy of the
+// innermost router having one is used.
+func authorize(req *Request, res *Response, route *Route) bool {
+	requirements := route.requirements
+	var policy Policy
+	for _, router := range route.routers {
+		requirements = append(requirements[:len(requirements):len(requirements)], router.requirements...)
+		if policy == nil {
+			policy = router.policy
+		}
+	}
+	if len(requirements) == 0 {
+		return true
+	}
+	principal := req.Principal()
+	if principal == nil {
+		res.ErrorResponse(StatusUnauthorized, "authentication is required")
+		return false
+	}
+	if policy == nil {
+		policy = DefaultPolicy{}
+	}
+	for _, requirement := range requirements {
+		if !policy.Allow(principal, requirement) {
+			res.ErrorResponse(StatusForbidden, "you are not allowed to access this resource")
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package http
+
+import (
+	"strings"
+	"testing"
+)
+
+// rolesFromHeader authenticates the request as a principal with the roles listed in X-Roles
+func rolesFromHeader(req *Request, res *Response, next func()) {
+	if roles := req.GetHeader("X-Roles"); roles != "" {
+		req.SetPrincipal(&Principal{ID: "user", Roles: strings.Split(roles, ",")})
+	}
+	next()
+}
+
+func okHandler(req *Request, res *Response) {
+	res.HttpResponse("ok", StatusOK)
+}
+
+func dispatchAs(t *testing.T, router *Router, path string, roles string) StatusCode {
+	t.Helper()
+	raw := "GET " + path + " HTTP/1.1\r\nHost: example.com\r\n"
+	if roles != "" {
+		raw += "X-Roles: " + roles + "\r\n"
+	}
+	req, err := ParseToRequest([]byte(raw + "\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router.Dispatch(req).GetStatusCode()
+}
+
+func TestRouterRequireOnServingRouter(t *testing.T) {
+	router := NewRouter()
+	router.UseGlobalPreMiddlewares([]MiddlewareFunc{rolesFromHeader})
+	router.Require("admin")
+	router.Get("/", okHandler)
+
+	for roles, want := range map[string]StatusCode{"": StatusUnauthorized, "user": StatusForbidden, "user,admin": StatusOK} {
+		if status := dispatchAs(t, router, "/", roles); status != want {
+			t.Errorf("roles %q: status = %d, want %d", roles, status.Int(), want.Int())
+		}
+	}
+}
+
+func TestRouterRequireAfterMerge(t *testing.T) {
+	group := NewRouter()
+	group.Get("/admin", okHandler)
+	group.Static("/files", t.TempDir())
+	app := NewRouter()
+	app.UseGlobalPreMiddlewares([]MiddlewareFunc{rolesFromHeader})
+	app.Get("/", okHandler)
+	app.MergeRouter(group)
+	group.Require("admin")
+
+	if status := dispatchAs(t, app, "/admin", "user"); status != StatusForbidden {
+		t.Errorf("group requirement set after the merge: status = %d, want 403", status.Int())
+	}
+	if status := dispatchAs(t, app, "/files/x", "user"); status != StatusForbidden {
+		t.Errorf("prefix route: status = %d, want 403", status.Int())
+	}
+	if status := dispatchAs(t, app, "/", "user"); status != StatusOK {
+		t.Errorf("group requirement applied to the app routes: status = %d", status.Int())
+	}
+
+	app.Can("audit")
+	if status := dispatchAs(t, app, "/admin", "admin"); status != StatusForbidden {
+		t.Errorf("app requirement set after the merge: status = %d, want 403", status.Int())
+	}
+}
+
+// allowAll grants every requirement
+type allowAll struct{}
+
+func (allowAll) Allow(principal *Principal, requirement Requirement) bool { return true }
+
+func TestRouterPolicyOfInnermostRouter(t *testing.T) {
+	group := NewRouter().UsePolicy(allowAll{})
+	group.Get("/open", okHandler).Require("admin")
+	app := NewRouter()
+	app.UseGlobalPreMiddlewares([]MiddlewareFunc{rolesFromHeader})
+	app.Get("/closed", okHandler).Require("admin")
+	app.MergeRouter(group)
+
+	if status := dispatchAs(t, app, "/open", "user"); status != StatusOK {
+		t.Errorf("group policy ignored: status = %d", status.Int())
+	}
+	if status := dispatchAs(t, app, "/closed", "user"); status != StatusForbidden {
+		t.Errorf("group policy applied to the app routes: status = %d", status.Int())
+	}
+}
+
+func TestRouterRejectsRoutesAfterMerge(t *testing.T) {
+	group := NewRouter()
+	NewRouter().MergeRouter(group)
+	defer func() {
+		if recover() == nil {
+			t.Error("route added to a merged router without a panic")
+		}
+	}()
+	group.Get("/late", okHandler)
+}
@@ -0,0 +1,43 @@
+package http
+
+type principalContextKey struct{}
+
+// Principal is the authenticated identity behind a request, set by the auth middlewares
+type Principal struct {
+	ID          string
+	Scheme      string
+	Roles       []string
+	Permissions []string
+	Claims      map[string]interface{}
+}
+
+// HasRole reports whether the principal was granted role
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the principal was granted permission
+func (p *Principal) HasPermission(permission string) bool {
+	for _, perm := range p.Permissions {
+		if perm == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal returns the authenticated identity of the request or nil when it is anonymous
+func (r *Request) Principal() *Principal {
+	principal, _ := r.Context().Value(principalContextKey{}).(*Principal)
+	return principal
+}
+
+// SetPrincipal attaches the authenticated identity to the request
+func (r *Request) SetPrincipal(principal *Principal) {
+	r.WithValue(principalContextKey{}, principal)
+}
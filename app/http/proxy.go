@@ -0,0 +1,482 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	nethttp "net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ProxyOptions configures a ReverseProxy
+type ProxyOptions struct {
+	// StripPrefix is removed from the request path before it is forwarded
+	StripPrefix string
+	// Rewrite changes the forwarded path once StripPrefix is removed, the path of the target
+	// is prepended to its result
+	Rewrite func(path string) string
+	// PreserveHost forwards the Host header of the client instead of the host of the target
+	PreserveHost bool
+	// DialTimeout is how long connecting to the upstream may take before a 502 is sent
+	DialTimeout time.Duration
+	// ResponseTimeout is how long the upstream has to send the response headers before a 504 is sent
+	ResponseTimeout time.Duration
+}
+
+// DefaultProxyOptions are used by NewReverseProxy for every zero field of the given options
+var DefaultProxyOptions = ProxyOptions{
+	DialTimeout:     10 * time.Second,
+	ResponseTimeout: 30 * time.Second,
+}
+
+// hopHeaders only apply to one connection and are never forwarded, RFC 9110 section 7.6.1
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ReverseProxy forwards requests to an upstream server and streams its responses back,
+// WebSocket upgrades are tunnelled to the upstream as well. Request bodies are read whole
+// before they are forwarded, so like every request they are limited to MaxBodyBytes and
+// larger ones get a 413 without reaching the upstream:
+//
+//	legacy, err := http.NewReverseProxy("http://10.0.0.12:8080", http.ProxyOptions{StripPrefix: "/legacy"})
+//	router.Proxy("/legacy", legacy)
+type ReverseProxy struct {
+	target    *url.URL
+	pool      *UpstreamPool
+	options   ProxyOptions
+	transport *nethttp.Transport
+}
+
+// NewReverseProxy creates a proxy to target, an http or https URL whose path prefixes
+// every forwarded path
+func NewReverseProxy(target string, options ProxyOptions) (*ReverseProxy, error) {
+	targetURL, err := parseProxyTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	proxy := newReverseProxy(options)
+	proxy.target = targetURL
+	return proxy, nil
+}
+
+// NewBalancedProxy creates a proxy spreading requests over the upstreams of pool
+func NewBalancedProxy(pool *UpstreamPool, options ProxyOptions) *ReverseProxy {
+	proxy := newReverseProxy(options)
+	proxy.pool = pool
+	return proxy
+}
+
+func newReverseProxy(options ProxyOptions) *ReverseProxy {
+	if options.DialTimeout == 0 {
+		options.DialTimeout = DefaultProxyOptions.DialTimeout
+	}
+	if options.ResponseTimeout == 0 {
+		options.ResponseTimeout = DefaultProxyOptions.ResponseTimeout
+	}
+	return &ReverseProxy{
+		options: options,
+		transport: &nethttp.Transport{
+			DialContext:           (&net.Dialer{Timeout: options.DialTimeout, KeepAlive: 30 * time.Second}).DialContext,
+			TLSHandshakeTimeout:   options.DialTimeout,
+			ResponseHeaderTimeout: options.ResponseTimeout,
+			MaxIdleConnsPerHost:   32,
+			IdleConnTimeout:       90 * time.Second,
+			// The Accept-Encoding of the client is forwarded, bodies are relayed as they are
+			DisableCompression: true,
+		},
+	}
+}
+
+func parseProxyTarget(target string) (*url.URL, error) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: invalid target %q: %w", target, err)
+	}
+	if (targetURL.Scheme != "http" && targetURL.Scheme != "https") || targetURL.Host == "" {
+		return nil, fmt.Errorf("proxy: target %q must be an absolute http or https URL", target)
+	}
+	return targetURL, nil
+}
+
+// Proxy forwards every request under prefix to proxy, whatever its method
+func (r *Router) Proxy(prefix string, proxy *ReverseProxy) *Route {
+	route := &Route{
+		handler:        proxy.Handle,
+		preMiddleware:  make([]MiddlewareFunc, 0),
+		postMiddleware: make([]MiddlewareFunc, 0),
+	}
+	for _, method := range []Method{GET, POST, PUT, DELETE, PATCH, HEAD, OPTIONS} {
+		r.addPrefixRoute(method, prefix, route)
+	}
+	return route
+}
+
+// Handle forwards the request to the upstream and relays its response. A 502 is sent when
+// the upstream can't be reached and a 504 when it doesn't answer in time.
+func (p *ReverseProxy) Handle(req *Request, res *Response) {
+	if p.pool != nil {
+		p.pool.serve(p, req, res)
+		return
+	}
+	if err := p.forward(req, res, p.target); err != nil {
+		proxyError(res, err)
+	}
+}
+
+// forward sends the request to target and relays the response. An error means the upstream
+// failed before answering, nothing was relayed then.
+func (p *ReverseProxy) forward(req *Request, res *Response, target *url.URL) error {
+	if isUpgradeRequest(req) {
+		return p.tunnel(req, res, target)
+	}
+	upstream, err := p.roundTrip(req, target)
+	if err != nil {
+		return err
+	}
+	relayResponse(res, upstream)
+	return nil
+}
+
+// roundTrip sends the request to target and returns the upstream response, whose body
+// is released once the request context is done
+func (p *ReverseProxy) roundTrip(req *Request, target *url.URL) (*nethttp.Response, error) {
+	out, err := p.outgoingRequest(req, target)
+	if err != nil {
+		return nil, err
+	}
+	return p.transport.RoundTrip(out)
+}
+
+// outgoingRequest builds the request sent upstream, without the hop-by-hop headers
+// of the client but with the X-Forwarded-* and Forwarded headers describing it
+func (p *ReverseProxy) outgoingRequest(req *Request, target *url.URL) (*nethttp.Request, error) {
+	path := req.GetPath()
+	if prefix := strings.TrimSuffix(p.options.StripPrefix, "/"); prefix != "" {
+		path = strings.TrimPrefix(path, prefix)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	if p.options.Rewrite != nil {
+		path = p.options.Rewrite(path)
+	}
+	rawURL := target.Scheme + "://" + target.Host + joinProxyPath(target.EscapedPath(), path)
+	if query := req.GetRawQuery(); query != "" {
+		rawURL += "?" + query
+	}
+
+	var body io.Reader
+	if len(req.GetBody()) > 0 {
+		body = bytes.NewReader(req.GetBody())
+	}
+	out, err := nethttp.NewRequestWithContext(req.Context(), req.GetMethod().String(), rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for headerName, headerValue := range req.headers {
+		out.Header.Set(headerName, headerValue)
+	}
+	removeHopHeaders(out.Header)
+	// No User-Agent must stay none instead of the one of the Go client
+	if _, exists := out.Header["User-Agent"]; !exists {
+		out.Header.Set("User-Agent", "")
+	}
+
+	out.Host = target.Host
+	if p.options.PreserveHost && req.GetHeader("Host") != "" {
+		out.Host = req.GetHeader("Host")
+	}
+	setForwardedHeaders(req, out.Header)
+	return out, nil
+}
+
+// joinProxyPath appends the request path to the path of the target with a single slash between them
+func joinProxyPath(base string, path string) string {
+	if base == "" || base == "/" {
+		return path
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// removeHopHeaders removes the hop-by-hop headers and the ones listed in Connection
+func removeHopHeaders(header nethttp.Header) {
+	for _, connection := range header.Values("Connection") {
+		for _, token := range splitTokens(connection) {
+			header.Del(token)
+		}
+	}
+	for _, headerName := range hopHeaders {
+		header.Del(headerName)
+	}
+}
+
+// setForwardedHeaders describes the client to the upstream, the client address is appended
+// to the X-Forwarded-For and Forwarded lists set by the proxies in front of this server
+func setForwardedHeaders(req *Request, header nethttp.Header) {
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr())
+	if err != nil {
+		clientIP = req.RemoteAddr()
+	}
+	scheme := req.Scheme()
+	host := req.Host()
+
+	if prior := header.Get("X-Forwarded-For"); prior != "" {
+		header.Set("X-Forwarded-For", prior+", "+clientIP)
+	} else if clientIP != "" {
+		header.Set("X-Forwarded-For", clientIP)
+	}
+	header.Set("X-Forwarded-Proto", scheme)
+	if host != "" {
+		header.Set("X-Forwarded-Host", host)
+	}
+
+	// IPv6 addresses and hosts with a port are quoted, RFC 7239 section 6
+	node := clientIP
+	if strings.Contains(node, ":") {
+		node = `"[` + node + `]"`
+	}
+	forwarded := "for=" + node + ";proto=" + scheme
+	if host != "" {
+		forwarded += ";host=" + quoteForwardedValue(host)
+	}
+	if prior := header.Get("Forwarded"); prior != "" {
+		forwarded = prior + ", " + forwarded
+	}
+	header.Set("Forwarded", forwarded)
+}
+
+// quoteForwardedValue quotes the values that aren't a token, like hosts with a port
+func quoteForwardedValue(value string) string {
+	if isCookieName(value) {
+		return value
+	}
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+// relayResponse copies the status and headers of the upstream response and streams its body,
+// every chunk is flushed right away so event streams aren't held back
+func relayResponse(res *Response, upstream *nethttp.Response) {
+	removeHopHeaders(upstream.Header)
+	body := upstream.Body
+	res.Stream(StatusCode(upstream.StatusCode), upstream.Header.Get("Content-Type"), func(w io.Writer) error {
+		defer body.Close()
+		return copyFlushing(w, body)
+	})
+	if upstream.Header.Get("Content-Type") == "" {
+		res.DeleteHeader("Content-Type")
+	}
+	for headerName, values := range upstream.Header {
+		if headerName == "Set-Cookie" {
+			continue
+		}
+		res.SetHeader(headerName, strings.Join(values, ", "))
+	}
+	for _, line := range upstream.Header.Values("Set-Cookie") {
+		if cookie := parseSetCookie(line); cookie != nil {
+			res.SetCookie(cookie)
+		}
+	}
+}
+
+func copyFlushing(w io.Writer, body io.Reader) error {
+	flusher, _ := w.(interface{ Flush() error })
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buffer)
+		if n > 0 {
+			if _, err := w.Write(buffer[:n]); err != nil {
+				return err
+			}
+			if flusher != nil {
+				if err := flusher.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseSetCookie parses an upstream Set-Cookie header, it returns nil when the header is invalid
+func parseSetCookie(line string) *Cookie {
+	parsed := (&nethttp.Response{Header: nethttp.Header{"Set-Cookie": {line}}}).Cookies()
+	if len(parsed) == 0 {
+		return nil
+	}
+	cookie := &Cookie{
+		Name:     parsed[0].Name,
+		Value:    parsed[0].Value,
+		Path:     parsed[0].Path,
+		Domain:   parsed[0].Domain,
+		Expires:  parsed[0].Expires,
+		MaxAge:   parsed[0].MaxAge,
+		Secure:   parsed[0].Secure,
+		HttpOnly: parsed[0].HttpOnly,
+	}
+	switch parsed[0].SameSite {
+	case nethttp.SameSiteLaxMode:
+		cookie.SameSite = SameSiteLaxMode
+	case nethttp.SameSiteStrictMode:
+		cookie.SameSite = SameSiteStrictMode
+	case nethttp.SameSiteNoneMode:
+		cookie.SameSite = SameSiteNoneMode
+	}
+	_, raw, _ := strings.Cut(line, "=")
+	value, _, _ := strings.Cut(raw, ";")
+	cookie.Quoted = len(strings.TrimSpace(value)) > 1 && strings.HasPrefix(strings.TrimSpace(value), `"`)
+	for _, attribute := range strings.Split(line, ";")[1:] {
+		if strings.EqualFold(strings.TrimSpace(attribute), "Partitioned") {
+			cookie.Partitioned = true
+		}
+	}
+	return cookie
+}
+
+// proxyError answers with a 504 when the upstream timed out and a 502 for any other failure
+func proxyError(res *Response, err error) {
+	log.Printf("Proxy error: %v", err)
+	var netErr net.Error
+	var opErr *net.OpError
+	timedOut := errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+	if timedOut && !(errors.As(err, &opErr) && opErr.Op == "dial") {
+		res.ErrorResponse(StatusGatewayTimeout, "the upstream server did not answer in time")
+		return
+	}
+	res.ErrorResponse(StatusBadGateway, "the upstream server could not be reached")
+}
+
+func isUpgradeRequest(req *Request) bool {
+	return req.GetHeader("Upgrade") != "" && headerContainsToken(req.GetHeader("Connection"), "upgrade")
+}
+
+// tunnel forwards an upgrade request, like a WebSocket handshake, on a connection of its own.
+// Once the upstream switches protocols the client connection is hijacked and bytes are copied
+// both ways until one side closes or the server shuts down. Like forward, it only returns
+// the errors happening before the upstream answers.
+func (p *ReverseProxy) tunnel(req *Request, res *Response, target *url.URL) error {
+	out, err := p.outgoingRequest(req, target)
+	if err != nil {
+		return err
+	}
+	out.Header.Set("Connection", "Upgrade")
+	out.Header.Set("Upgrade", req.GetHeader("Upgrade"))
+
+	upstreamConn, err := p.dial(req.Context(), target)
+	if err != nil {
+		return err
+	}
+	// Closing the connection unblocks the reads of the upstream once the request is over
+	context.AfterFunc(req.Context(), func() {
+		upstreamConn.Close()
+	})
+
+	upstreamConn.SetDeadline(time.Now().Add(p.options.ResponseTimeout))
+	upstreamReader := bufio.NewReader(upstreamConn)
+	if err := out.Write(upstreamConn); err != nil {
+		upstreamConn.Close()
+		return err
+	}
+	upstream, err := nethttp.ReadResponse(upstreamReader, out)
+	if err != nil {
+		upstreamConn.Close()
+		return err
+	}
+	upstreamConn.SetDeadline(time.Time{})
+	if upstream.StatusCode != nethttp.StatusSwitchingProtocols {
+		upstream.Body = &connBody{ReadCloser: upstream.Body, conn: upstreamConn}
+		relayResponse(res, upstream)
+		return nil
+	}
+	defer upstreamConn.Close()
+
+	protocol := upstream.Header.Get("Upgrade")
+	removeHopHeaders(upstream.Header)
+	res.SetStatusCode(StatusSwitchingProtocols)
+	for headerName, values := range upstream.Header {
+		res.SetHeader(headerName, strings.Join(values, ", "))
+	}
+	res.SetHeader("Connection", "Upgrade")
+	res.SetHeader("Upgrade", protocol)
+	res.DeleteHeader("Content-Length")
+	res.body = ""
+	res.stream = nil
+
+	conn, reader, err := res.Hijack()
+	if err != nil {
+		res.ErrorResponse(StatusInternalServerError, "upgrades can only be proxied on a connection")
+		return nil
+	}
+	res.runBeforeWrite()
+	if _, err := io.WriteString(conn, res.head()); err != nil {
+		return nil
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstreamConn, reader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstreamReader)
+		done <- struct{}{}
+	}()
+	select {
+	case <-done:
+	case <-req.Context().Done():
+	}
+	conn.Close()
+	upstreamConn.Close()
+	<-done
+	return nil
+}
+
+// connBody closes the connection of a tunnel refused by the upstream once its response is relayed
+type connBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (b *connBody) Close() error {
+	b.ReadCloser.Close()
+	return b.conn.Close()
+}
+
+// dial opens a connection to target for a tunnel, with TLS for https targets
+func (p *ReverseProxy) dial(ctx context.Context, target *url.URL) (net.Conn, error) {
+	address := target.Host
+	if target.Port() == "" {
+		port := "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+		address = net.JoinHostPort(target.Hostname(), port)
+	}
+	dialer := &net.Dialer{Timeout: p.options.DialTimeout}
+	if target.Scheme == "https" {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: target.Hostname()}}
+		return tlsDialer.DialContext(ctx, "tcp", address)
+	}
+	return dialer.DialContext(ctx, "tcp", address)
+}
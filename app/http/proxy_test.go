@@ -0,0 +1,256 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sendRawRequest writes raw to the server at baseURL and reads the response
+func sendRawRequest(t *testing.T, baseURL string, raw string) (*nethttp.Response, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(baseURL, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatalf("writing request: %v", err)
+	}
+	response, err := nethttp.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	return response, string(body)
+}
+
+// proxyTestServer serves a router proxying prefix to target with options
+func proxyTestServer(t *testing.T, prefix string, target string, options ProxyOptions) string {
+	t.Helper()
+	proxy, err := NewReverseProxy(target, options)
+	if err != nil {
+		t.Fatalf("NewReverseProxy: %v", err)
+	}
+	router := NewRouter()
+	router.Proxy(prefix, proxy)
+	return StartTestServer(t, router)
+}
+
+func TestProxyPaths(t *testing.T) {
+	upstream := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		io.WriteString(w, r.URL.RequestURI())
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name    string
+		target  string
+		options ProxyOptions
+		path    string
+		want    string
+	}{
+		{"path kept", upstream.URL, ProxyOptions{}, "/api/users?page=2", "/api/users?page=2"},
+		{"prefix stripped", upstream.URL, ProxyOptions{StripPrefix: "/api"}, "/api/users?page=2", "/users?page=2"},
+		{"prefix alone", upstream.URL, ProxyOptions{StripPrefix: "/api/"}, "/api", "/"},
+		{"target path prepended", upstream.URL + "/v2/", ProxyOptions{StripPrefix: "/api"}, "/api/users", "/v2/users"},
+		{"rewritten", upstream.URL, ProxyOptions{
+			StripPrefix: "/api",
+			Rewrite:     func(path string) string { return strings.Replace(path, "/users", "/accounts", 1) },
+		}, "/api/users/7", "/accounts/7"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			baseURL := proxyTestServer(t, "/api", test.target, test.options)
+			response, body := sendRawRequest(t, baseURL, "GET "+test.path+" HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+			if response.StatusCode != 200 || body != test.want {
+				t.Errorf("status = %d, upstream saw %q, want %q", response.StatusCode, body, test.want)
+			}
+		})
+	}
+}
+
+func TestProxyHeaders(t *testing.T) {
+	received := make(chan *nethttp.Request, 1)
+	upstream := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-Upstream", "yes")
+		w.Header().Add("Set-Cookie", "a=1; Path=/")
+		w.Header().Add("Set-Cookie", "b=2; HttpOnly")
+		w.WriteHeader(nethttp.StatusCreated)
+		w.Write(body)
+	}))
+	defer upstream.Close()
+	baseURL := proxyTestServer(t, "/api", upstream.URL, ProxyOptions{})
+
+	response, body := sendRawRequest(t, baseURL, "POST /api/items HTTP/1.1\r\nHost: example.com\r\n"+
+		"Connection: close, X-Hop\r\nX-Hop: secret\r\nKeep-Alive: timeout=5\r\nProxy-Authorization: Basic eDp5\r\n"+
+		"Te: trailers\r\nX-Custom: kept\r\nContent-Length: 5\r\n\r\nhello")
+	if response.StatusCode != 201 || body != "hello" {
+		t.Fatalf("status = %d, body = %q", response.StatusCode, body)
+	}
+	if response.Header.Get("X-Upstream") != "yes" || response.Header.Get("Keep-Alive") != "" {
+		t.Errorf("X-Upstream = %q, Keep-Alive = %q", response.Header.Get("X-Upstream"), response.Header.Get("Keep-Alive"))
+	}
+	if cookies := response.Header.Values("Set-Cookie"); len(cookies) != 2 {
+		t.Errorf("Set-Cookie = %q, want both upstream cookies", cookies)
+	}
+
+	out := <-received
+	for _, headerName := range []string{"X-Hop", "Keep-Alive", "Proxy-Authorization", "Te"} {
+		if value := out.Header.Get(headerName); value != "" {
+			t.Errorf("hop-by-hop header %s forwarded as %q", headerName, value)
+		}
+	}
+	if out.Header.Get("X-Custom") != "kept" {
+		t.Errorf("X-Custom = %q", out.Header.Get("X-Custom"))
+	}
+	if out.Host != strings.TrimPrefix(upstream.URL, "http://") {
+		t.Errorf("Host = %q, want the host of the target", out.Host)
+	}
+	if out.Header.Get("X-Forwarded-For") != "127.0.0.1" || out.Header.Get("X-Forwarded-Proto") != "http" ||
+		out.Header.Get("X-Forwarded-Host") != "example.com" {
+		t.Errorf("X-Forwarded-For = %q, X-Forwarded-Proto = %q, X-Forwarded-Host = %q",
+			out.Header.Get("X-Forwarded-For"), out.Header.Get("X-Forwarded-Proto"), out.Header.Get("X-Forwarded-Host"))
+	}
+	if out.Header.Get("Forwarded") != "for=127.0.0.1;proto=http;host=example.com" {
+		t.Errorf("Forwarded = %q", out.Header.Get("Forwarded"))
+	}
+}
+
+func TestProxyAppendsToForwardingHeaders(t *testing.T) {
+	received := make(chan *nethttp.Request, 1)
+	upstream := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		received <- r
+	}))
+	defer upstream.Close()
+	baseURL := proxyTestServer(t, "/", upstream.URL, ProxyOptions{PreserveHost: true})
+
+	sendRawRequest(t, baseURL, "GET / HTTP/1.1\r\nHost: example.com:8443\r\nConnection: close\r\n"+
+		"X-Forwarded-For: 203.0.113.7\r\nForwarded: for=203.0.113.7\r\n\r\n")
+	out := <-received
+	if out.Host != "example.com:8443" {
+		t.Errorf("Host = %q, want the one of the client", out.Host)
+	}
+	if out.Header.Get("X-Forwarded-For") != "203.0.113.7, 127.0.0.1" {
+		t.Errorf("X-Forwarded-For = %q", out.Header.Get("X-Forwarded-For"))
+	}
+	if out.Header.Get("Forwarded") != `for=203.0.113.7, for=127.0.0.1;proto=http;host="example.com:8443"` {
+		t.Errorf("Forwarded = %q", out.Header.Get("Forwarded"))
+	}
+}
+
+func TestProxyUpstreamFailures(t *testing.T) {
+	t.Run("unreachable", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		closedAddr := listener.Addr().String()
+		listener.Close()
+
+		baseURL := proxyTestServer(t, "/", "http://"+closedAddr, ProxyOptions{DialTimeout: time.Second})
+		if response, _ := sendRawRequest(t, baseURL, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n"); response.StatusCode != 502 {
+			t.Errorf("status = %d, want 502", response.StatusCode)
+		}
+	})
+	t.Run("too slow", func(t *testing.T) {
+		upstream := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		defer upstream.Close()
+
+		baseURL := proxyTestServer(t, "/", upstream.URL, ProxyOptions{ResponseTimeout: 100 * time.Millisecond})
+		start := time.Now()
+		response, _ := sendRawRequest(t, baseURL, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+		if response.StatusCode != 504 {
+			t.Errorf("status = %d, want 504", response.StatusCode)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("504 sent after %v", elapsed)
+		}
+	})
+}
+
+func TestProxyRejectsLargeBodies(t *testing.T) {
+	reached := false
+	upstream := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		reached = true
+	}))
+	defer upstream.Close()
+
+	baseURL := proxyTestServer(t, "/", upstream.URL, ProxyOptions{})
+	raw := "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: " + strconv.Itoa(MaxBodyBytes+1) + "\r\n\r\n"
+	if response, _ := sendRawRequest(t, baseURL, raw); response.StatusCode != 413 {
+		t.Errorf("status = %d, want 413", response.StatusCode)
+	}
+	if reached {
+		t.Error("body over the limit forwarded to the upstream")
+	}
+}
+
+func TestProxyTunnelsWebSockets(t *testing.T) {
+	upstream := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("X-Forwarded-For") == "" {
+			w.WriteHeader(nethttp.StatusBadRequest)
+			return
+		}
+		conn, buffered, err := w.(nethttp.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buffered.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + websocketAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+		buffered.Flush()
+		io.Copy(conn, buffered)
+	}))
+	defer upstream.Close()
+	baseURL := proxyTestServer(t, "/ws", upstream.URL, ProxyOptions{})
+
+	conn, reader, response := dialWebSocket(t, baseURL, "/ws/chat")
+	if response.StatusCode != 101 {
+		t.Fatalf("status = %d, want 101", response.StatusCode)
+	}
+	if response.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" || response.Header.Get("Upgrade") != "websocket" {
+		t.Errorf("handshake headers = %v", response.Header)
+	}
+	for _, message := range []string{"first", "second"} {
+		io.WriteString(conn, message)
+		echoed := make([]byte, len(message))
+		if _, err := io.ReadFull(reader, echoed); err != nil || string(echoed) != message {
+			t.Fatalf("echo = %q, %v", echoed, err)
+		}
+	}
+}
+
+func TestProxyRelaysRefusedUpgrade(t *testing.T) {
+	upstream := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		nethttp.Error(w, "no websockets here", nethttp.StatusForbidden)
+	}))
+	defer upstream.Close()
+	baseURL := proxyTestServer(t, "/ws", upstream.URL, ProxyOptions{})
+
+	_, reader, response := dialWebSocket(t, baseURL, "/ws/chat")
+	if response.StatusCode != 403 {
+		t.Fatalf("status = %d, want 403", response.StatusCode)
+	}
+	body, _ := io.ReadAll(io.LimitReader(reader, int64(len("no websockets here\n"))))
+	if string(body) != "no websockets here\n" {
+		t.Errorf("body = %q", body)
+	}
+}
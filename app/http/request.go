@@ -1,8 +1,10 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -12,6 +14,18 @@ type Request struct {
 	queryParams map[string]string
 	headers     map[string]string
 	body        map[string]string
+	ctx         context.Context
+	remoteAddr  string
+	rawBody     []byte
+	rawQuery    string
+	router      *Router
+	// route is the route matched by the router, nil when none matched
+	route *Route
+
+	// tls reports whether the request came over a TLS connection
+	tls bool
+	// proto is the protocol version, like "HTTP/1.1"
+	proto string
 }
 
 func ParseToRequest(rawRequest []byte) (*Request, error) {
@@ -22,7 +36,7 @@ func ParseToRequest(rawRequest []byte) (*Request, error) {
 		return nil, fmt.Errorf("empty request")
 	}
 
-	parts := strings.Split(string(rawRequest), "\r\n\r\n")
+	parts := strings.SplitN(string(rawRequest), "\r\n\r\n", 2)
 	if len(parts) == 0 {
 		return nil, fmt.Errorf("invalid request format")
 	}
@@ -47,24 +61,8 @@ func ParseToRequest(rawRequest []byte) (*Request, error) {
 	request.method = method
 
 	// Parse path and query
-	pathAndQuery := strings.Split(firstLine[1], "?")
-	if len(pathAndQuery[0]) == 0 {
-		request.path = "/"
-	} else {
-		request.path = pathAndQuery[0]
-	}
-
-	// Parse query parameters
-	if len(pathAndQuery) > 1 {
-		request.queryParams = make(map[string]string)
-		queries := strings.Split(pathAndQuery[1], "&")
-		for _, pair := range queries {
-			keyValue := strings.Split(pair, "=")
-			if len(keyValue) == 2 {
-				request.queryParams[keyValue[0]] = keyValue[1]
-			}
-		}
-	}
+	request.setTarget(firstLine[1])
+	request.proto = firstLine[2]
 
 	// Parse headers
 	request.headers = make(map[string]string)
@@ -73,7 +71,7 @@ func ParseToRequest(rawRequest []byte) (*Request, error) {
 			if header == "" {
 				continue
 			}
-			keyValue := strings.Split(header, ": ")
+			keyValue := strings.SplitN(header, ": ", 2)
 			if len(keyValue) != 2 {
 				return nil, fmt.Errorf("invalid header format: %s", header)
 			}
@@ -81,13 +79,17 @@ func ParseToRequest(rawRequest []byte) (*Request, error) {
 		}
 	}
 
-	// Parse body
-	if len(parts) > 1 {
-		body, err := request.parseBody([]byte(parts[1]))
+	// Parse body, encoded bodies are left for a middleware to decode and parse with SetBody
+	encoding := request.GetHeader("Content-Encoding")
+	if len(parts) > 1 && len(parts[1]) > 0 && (encoding == "" || encoding == "identity") {
+		request.rawBody = []byte(parts[1])
+		body, err := request.parseBody(request.rawBody)
 		if err != nil {
 			return nil, fmt.Errorf("parsing body: %w", err)
 		}
 		request.body = body
+	} else if len(parts) > 1 {
+		request.rawBody = []byte(parts[1])
 	}
 
 	return request, nil
@@ -141,6 +143,29 @@ func ParseToRequest(rawRequest []byte) (*Request, error) {
 //     return request
 // }
 
+// setTarget sets the path and the query parameters from the request target
+func (r *Request) setTarget(target string) {
+	pathAndQuery := strings.Split(target, "?")
+	if len(pathAndQuery[0]) == 0 {
+		r.path = "/"
+	} else {
+		r.path = pathAndQuery[0]
+	}
+
+	// Parse query parameters
+	if len(pathAndQuery) > 1 {
+		r.rawQuery = strings.Join(pathAndQuery[1:], "?")
+		r.queryParams = make(map[string]string)
+		queries := strings.Split(pathAndQuery[1], "&")
+		for _, pair := range queries {
+			keyValue := strings.Split(pair, "=")
+			if len(keyValue) == 2 {
+				r.queryParams[keyValue[0]] = keyValue[1]
+			}
+		}
+	}
+}
+
 func (r *Request) GetMethod() Method {
 	return r.method
 }
@@ -149,16 +174,169 @@ func (r *Request) GetPath() string {
 	return r.path
 }
 
+// GetProto returns the protocol version of the request, "HTTP/1.1" or "HTTP/2.0"
+func (r *Request) GetProto() string {
+	return r.proto
+}
+
 func (r *Request) GetQueryParam(key string) string {
 	return r.queryParams[key]
 }
 
+// GetRawQuery returns the query string of the request target, without the "?"
+func (r *Request) GetRawQuery() string {
+	return r.rawQuery
+}
+
+// GetHeader returns a request header, header names are case insensitive
 func (r *Request) GetHeader(headerName string) string {
-	return r.headers[headerName]
+	if value, exists := r.headers[headerName]; exists {
+		return value
+	}
+	for name, value := range r.headers {
+		if strings.EqualFold(name, headerName) {
+			return value
+		}
+	}
+	return ""
+}
+
+// SetHeader replaces a request header, middlewares use it to normalize requests before the handler runs
+func (r *Request) SetHeader(headerName string, headerValue string) {
+	r.headers[headerName] = headerValue
+}
+
+// DeleteHeader removes a request header, whatever the case of its name
+func (r *Request) DeleteHeader(headerName string) {
+	for name := range r.headers {
+		if strings.EqualFold(name, headerName) {
+			delete(r.headers, name)
+		}
+	}
+}
+
+// GetBody returns the raw request body
+func (r *Request) GetBody() []byte {
+	return r.rawBody
+}
+
+// SetBody replaces the raw request body and parses it again according to its Content-Type
+func (r *Request) SetBody(body []byte) error {
+	parsed, err := r.parseBody(body)
+	if err != nil {
+		return err
+	}
+	r.rawBody = body
+	r.body = parsed
+	r.headers["Content-Length"] = strconv.Itoa(len(body))
+	return nil
+}
+
+// GetBodyParam returns a field of a JSON or form encoded body
+func (r *Request) GetBodyParam(key string) string {
+	return r.body[key]
+}
+
+// RemoteAddr returns the network address of the peer that sent the request, as "host:port"
+func (r *Request) RemoteAddr() string {
+	return r.remoteAddr
+}
+
+// Router returns the router resolving the request, middlewares use it to dispatch sub-requests
+func (r *Request) Router() *Router {
+	return r.router
+}
+
+// RouteValue returns the value the matched route holds for key, nil when there is none
+func (r *Request) RouteValue(key interface{}) interface{} {
+	if r.route == nil {
+		return nil
+	}
+	return r.route.values[key]
+}
+
+// Clone returns a copy of the request that can be modified and dispatched independently,
+// the context is kept but detached from the cancellation of the original request
+func (r *Request) Clone() *Request {
+	clone := *r
+	clone.headers = make(map[string]string, len(r.headers))
+	for headerName, headerValue := range r.headers {
+		clone.headers[headerName] = headerValue
+	}
+	clone.queryParams = make(map[string]string, len(r.queryParams))
+	for key, value := range r.queryParams {
+		clone.queryParams[key] = value
+	}
+	clone.body = make(map[string]string, len(r.body))
+	for key, value := range r.body {
+		clone.body[key] = value
+	}
+	clone.ctx = context.WithoutCancel(r.Context())
+	return &clone
+}
+
+// Context returns the request context, it is never nil
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// SetContext replaces the request context, middlewares use it to pass values down the chain
+func (r *Request) SetContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// WithValue stores a value on the request context under key
+func (r *Request) WithValue(key, value interface{}) {
+	r.ctx = context.WithValue(r.Context(), key, value)
+}
+
+// Cookies returns all cookies sent with the request
+func (r *Request) Cookies() []*Cookie {
+	return parseCookieHeader(r.GetHeader("Cookie"))
+}
+
+// Cookie returns the named cookie sent with the request or ErrNoCookie if it is missing
+func (r *Request) Cookie(name string) (*Cookie, error) {
+	for _, cookie := range r.Cookies() {
+		if cookie.Name == name {
+			return cookie, nil
+		}
+	}
+	return nil, ErrNoCookie
+}
+
+// SignedCookie returns the named cookie after verifying its signature with keys,
+// the returned cookie holds the original unsigned value
+func (r *Request) SignedCookie(name string, keys *Keyring) (*Cookie, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil, err
+	}
+	value, err := keys.Verify(name, cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &Cookie{Name: name, Value: value}, nil
+}
+
+// EncryptedCookie returns the named cookie after decrypting its value with keys
+func (r *Request) EncryptedCookie(name string, keys *Keyring) (*Cookie, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil, err
+	}
+	value, err := keys.Decrypt(name, cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &Cookie{Name: name, Value: value}, nil
 }
 
 func (r *Request) parseBody(body []byte) (map[string]string, error) {
-	contentType := r.headers["Content-Type"]
+	contentType := r.GetHeader("Content-Type")
 
 	switch contentType {
 	case "application/json":
@@ -1,28 +1,178 @@
 package http
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"http-server/helpers"
+	"io"
+	"net"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// TimeFormat is the IMF-fixdate format used for HTTP date headers and cookie expiry
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
 type Response struct {
 	statusCode StatusCode
 	headers    map[string]string
+	cookies    []*Cookie
 	body       string
+	stream     func(w io.Writer) error
+	wrapWriter func(w io.Writer) io.WriteCloser
+	request    *Request
+
+	beforeWrite []func()
+	afterWrite  []func()
+	// written counts the body bytes sent to the client
+	written atomic.Int64
+
+	// conn and reader are the connection the request was read from, they are nil for
+	// sub-requests. Once hijacked the server doesn't write the response itself.
+	conn     net.Conn
+	reader   *bufio.Reader
+	hijacked bool
+	events   *EventStream
+	// commit sends the head of the response right away and returns the writer for the body,
+	// it is set by the server the response belongs to
+	commit    func() (io.Writer, error)
+	committed bool
 }
 
+// ErrNotHijackable is returned when the response isn't tied to a connection,
+// like the responses of sub-requests, or when it was already hijacked
+var ErrNotHijackable = errors.New("http: connection cannot be hijacked")
+
 func NewHttpResponse() *Response {
 	response := &Response{}
 	response.headers = make(map[string]string)
 	return response
 }
 
+// SetHeader replaces a response header, whatever the case it was set with. Header names
+// are only stored in one case, so replacing one set with the same case doesn't look further.
 func (r *Response) SetHeader(headerName string, headerValue string) {
+	if _, exists := r.headers[headerName]; !exists {
+		r.DeleteHeader(headerName)
+	}
 	r.headers[headerName] = headerValue
 }
 
+// DeleteHeader removes a header from the response, whatever the case of its name
+func (r *Response) DeleteHeader(headerName string) {
+	for name := range r.headers {
+		if strings.EqualFold(name, headerName) {
+			delete(r.headers, name)
+		}
+	}
+}
+
+// GetHeader returns the value of a response header or an empty string, whatever the case of its name
+func (r *Response) GetHeader(headerName string) string {
+	if value, exists := r.headers[headerName]; exists {
+		return value
+	}
+	for name, value := range r.headers {
+		if strings.EqualFold(name, headerName) {
+			return value
+		}
+	}
+	return ""
+}
+
+// GetHeaders returns a copy of every response header, Set-Cookie excepted
+func (r *Response) GetHeaders() map[string]string {
+	headers := make(map[string]string, len(r.headers))
+	for headerName, headerValue := range r.headers {
+		headers[headerName] = headerValue
+	}
+	return headers
+}
+
+// HasCookies reports whether the response sets cookies
+func (r *Response) HasCookies() bool {
+	return len(r.cookies) > 0
+}
+
+// AppendHeader adds value to a comma separated header like Vary, unless it is already listed
+func (r *Response) AppendHeader(headerName string, headerValue string) {
+	current := r.GetHeader(headerName)
+	if current == "" {
+		r.SetHeader(headerName, headerValue)
+		return
+	}
+	for _, existing := range strings.Split(current, ",") {
+		if strings.EqualFold(strings.TrimSpace(existing), headerValue) {
+			return
+		}
+	}
+	r.SetHeader(headerName, current+", "+headerValue)
+}
+
+// SetCookie adds a Set-Cookie header to the response, several cookies can be set on the same response
+func (r *Response) SetCookie(cookie *Cookie) error {
+	if err := cookie.Valid(); err != nil {
+		return err
+	}
+	r.cookies = append(r.cookies, cookie)
+	return nil
+}
+
+// SetSignedCookie signs the cookie value with the newest key of keys before setting it,
+// the value stays readable by the client but any change is detected by Request.SignedCookie
+func (r *Response) SetSignedCookie(cookie *Cookie, keys *Keyring) error {
+	signed := *cookie
+	signed.Value = keys.Sign(cookie.Name, cookie.Value)
+	return r.SetCookie(&signed)
+}
+
+// SetEncryptedCookie encrypts the cookie value with the newest key of keys before setting it,
+// the value can be any string since it is base64 encoded once encrypted
+func (r *Response) SetEncryptedCookie(cookie *Cookie, keys *Keyring) error {
+	value, err := keys.Encrypt(cookie.Name, cookie.Value)
+	if err != nil {
+		return err
+	}
+	encrypted := *cookie
+	encrypted.Value = value
+	return r.SetCookie(&encrypted)
+}
+
+// BeforeWrite registers fn to run once the handler and middlewares are done, right before
+// the response is written to the connection. Hooks run in reverse order of registration.
+func (r *Response) BeforeWrite(fn func()) {
+	r.beforeWrite = append(r.beforeWrite, fn)
+}
+
+func (r *Response) runBeforeWrite() {
+	for i := len(r.beforeWrite) - 1; i >= 0; i-- {
+		r.beforeWrite[i]()
+	}
+	r.beforeWrite = nil
+}
+
+// AfterWrite registers fn to run once the response is sent, or once the connection is released
+// when it was hijacked. Hooks run in order of registration, they don't run for sub-requests
+// since those are never sent.
+func (r *Response) AfterWrite(fn func()) {
+	r.afterWrite = append(r.afterWrite, fn)
+}
+
+func (r *Response) runAfterWrite() {
+	for _, fn := range r.afterWrite {
+		fn()
+	}
+	r.afterWrite = nil
+}
+
+// GetBytesWritten returns the number of body bytes sent so far, the bytes written to a hijacked connection aren't counted
+func (r *Response) GetBytesWritten() int64 {
+	return r.written.Load()
+}
+
 func (r *Response) SetStatusCode(code StatusCode) {
 	r.statusCode = code
 }
@@ -37,15 +187,151 @@ func (r *Response) Redirect(url string) {
 }
 
 func (r *Response) String() string {
+	return r.head() + r.body
+}
+
+// head returns the status line and the headers, up to the empty line starting the body
+func (r *Response) head() string {
 	var rawResponse string
 	rawResponse = fmt.Sprintf("HTTP/1.1 %v %v\r\n", r.statusCode.Int(), r.statusCode)
 	for headerName, headerValue := range r.headers {
 		rawResponse += fmt.Sprintf("%v: %v\r\n", headerName, headerValue)
 	}
-	rawResponse += fmt.Sprintf("\r\n%v", r.body)
+	for _, cookie := range r.cookies {
+		rawResponse += fmt.Sprintf("Set-Cookie: %v\r\n", cookie)
+	}
+	rawResponse += "\r\n"
 	return rawResponse
 }
 
+// writeTo writes the response to the connection, streaming the body when the response has a stream
+func (r *Response) writeTo(w io.Writer, withBody bool) error {
+	if _, err := io.WriteString(w, r.head()); err != nil {
+		return err
+	}
+	if !withBody {
+		return nil
+	}
+	w = &countingWriter{w: w, n: &r.written}
+	if r.stream == nil {
+		_, err := io.WriteString(w, r.body)
+		return err
+	}
+	if r.wrapWriter == nil {
+		return r.stream(w)
+	}
+	wrapped := r.wrapWriter(w)
+	if err := r.stream(wrapped); err != nil {
+		wrapped.Close()
+		return err
+	}
+	return wrapped.Close()
+}
+
+// GetBody returns the buffered body of the response, it is empty for streamed responses
+func (r *Response) GetBody() string {
+	return r.body
+}
+
+// SetBody replaces the buffered body of the response and updates its Content-Length
+func (r *Response) SetBody(body string) {
+	r.body = body
+	r.stream = nil
+	r.SetHeader("Content-Length", strconv.Itoa(len(body)))
+}
+
+// Stream sends a body of unknown length, fn is called with the connection once the headers
+// are written. The body ends when the connection is closed, so no Content-Length is sent.
+func (r *Response) Stream(code StatusCode, contentType string, fn func(w io.Writer) error) {
+	r.SetStatusCode(code)
+	r.SetHeader("Date", time.Now().UTC().Format(time.RFC1123))
+	r.SetHeader("Server", "GoHTTP/1.0")
+	r.SetHeader("Connection", "close")
+	r.SetHeader("Content-Type", contentType)
+	r.DeleteHeader("Content-Length")
+	r.body = ""
+	r.stream = fn
+}
+
+// IsStreaming reports whether the body is produced by a Stream function or written to a hijacked connection
+func (r *Response) IsStreaming() bool {
+	return r.stream != nil || r.hijacked || r.committed
+}
+
+// commitHead runs the BeforeWrite hooks and sends the status line and headers right away,
+// it returns the writer for the body. The server doesn't write the response afterwards.
+func (r *Response) commitHead() (io.Writer, error) {
+	if r.commit == nil || r.committed {
+		return nil, ErrNotHijackable
+	}
+	r.committed = true
+	r.runBeforeWrite()
+	w, err := r.commit()
+	if err != nil {
+		return nil, err
+	}
+	return &countingWriter{w: w, n: &r.written}, nil
+}
+
+// countingWriter counts the bytes written to w, flushes are passed on
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c *countingWriter) Flush() error {
+	if flusher, ok := c.w.(interface{ Flush() error }); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// Hijack hands the connection over to the caller, along with the reader holding any
+// data the client sent after the request. The server won't write the response and closes
+// the connection once the request is resolved, so the caller must be done with it by then.
+func (r *Response) Hijack() (net.Conn, *bufio.Reader, error) {
+	if r.conn == nil || r.hijacked {
+		return nil, nil, ErrNotHijackable
+	}
+	r.hijacked = true
+	return r.conn, r.reader, nil
+}
+
+// WrapStreamWriter makes a streamed body go through the writer returned by wrap,
+// which is closed once the stream function returns. Writers that buffer, like compressors,
+// should expose a Flush method so stream functions can push data early.
+func (r *Response) WrapStreamWriter(wrap func(w io.Writer) io.WriteCloser) {
+	if r.wrapWriter == nil {
+		r.wrapWriter = wrap
+		return
+	}
+	inner := r.wrapWriter
+	r.wrapWriter = func(w io.Writer) io.WriteCloser {
+		outer := wrap(w)
+		return &chainedWriter{WriteCloser: inner(outer), next: outer}
+	}
+}
+
+// chainedWriter closes the writer it wraps once it is closed itself
+type chainedWriter struct {
+	io.WriteCloser
+	next io.WriteCloser
+}
+
+func (c *chainedWriter) Close() error {
+	if err := c.WriteCloser.Close(); err != nil {
+		c.next.Close()
+		return err
+	}
+	return c.next.Close()
+}
+
 func (r *Response) JsonResponse(payload interface{}) {
 	contentLength, body := helpers.MustToJSONString(payload)
 	r.SetStatusCode(StatusOK)
@@ -67,6 +353,25 @@ func (r *Response) HttpResponse(payload string, code StatusCode) {
 	r.body = payload
 }
 
+// ErrorResponse sends a JSON error body, it is the format used by every error produced by the framework itself
+func (r *Response) ErrorResponse(code StatusCode, message string) {
+	r.JsonResponse(map[string]interface{}{
+		"status":  code.Int(),
+		"error":   code.String(),
+		"message": message,
+	})
+	r.SetStatusCode(code)
+}
+
+// NoContent sends an empty 204 response, which must not carry a Content-Length
+func (r *Response) NoContent() {
+	r.SetStatusCode(StatusNoContent)
+	r.SetHeader("Date", time.Now().UTC().Format(time.RFC1123))
+	r.SetHeader("Server", "GoHTTP/1.0")
+	r.SetHeader("Connection", "close")
+	r.body = ""
+}
+
 func (r *Response) NotFound() {
 	r.SetStatusCode(StatusNotFound)
 	r.SetHeader("Date", time.Now().UTC().Format(time.RFC1123))
@@ -0,0 +1,48 @@
+package http
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResponseHeadersIgnoreCase(t *testing.T) {
+	res := NewHttpResponse()
+	res.SetHeader("X-Request-ID", "first")
+	res.SetHeader("x-request-id", "second")
+	if headers := res.GetHeaders(); len(headers) != 1 || headers["x-request-id"] != "second" {
+		t.Errorf("headers = %v, want only the last value under the last name", headers)
+	}
+	if res.GetHeader("X-REQUEST-ID") != "second" {
+		t.Errorf("GetHeader = %q", res.GetHeader("X-REQUEST-ID"))
+	}
+	if head := res.head(); strings.Count(strings.ToLower(head), "x-request-id") != 1 {
+		t.Errorf("head sends the header more than once:\n%s", head)
+	}
+
+	res.SetHeader("ETag", `"a"`)
+	res.SetHeader("ETag", `"b"`)
+	if res.GetHeader("etag") != `"b"` {
+		t.Errorf("ETag = %q", res.GetHeader("etag"))
+	}
+
+	res.DeleteHeader("etag")
+	if res.GetHeader("ETag") != "" {
+		t.Error("header deleted with another case still set")
+	}
+	if res.GetHeader("Missing") != "" {
+		t.Error("missing header not empty")
+	}
+}
+
+func TestResponseAppendHeader(t *testing.T) {
+	res := NewHttpResponse()
+	res.AppendHeader("Vary", "Accept-Encoding")
+	res.AppendHeader("vary", "Origin")
+	res.AppendHeader("VARY", "origin")
+	if headers := res.GetHeaders(); len(headers) != 1 {
+		t.Errorf("headers = %v, want one Vary header", headers)
+	}
+	if res.GetHeader("Vary") != "Accept-Encoding, Origin" {
+		t.Errorf("Vary = %q", res.GetHeader("Vary"))
+	}
+}
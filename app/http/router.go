@@ -1,5 +1,7 @@
 package http
 
+import "strings"
+
 // Middleware function type
 type MiddlewareFunc func(req *Request, res *Response, next func())
 
@@ -12,6 +14,12 @@ type Route struct {
 	handler        func(req *Request, res *Response)
 	preMiddleware  []MiddlewareFunc
 	postMiddleware []MiddlewareFunc
+	requirements   []Requirement
+	// routers the route was registered on or merged into, innermost first. Their requirements
+	// and policy are read on every request so the order of the calls doesn't matter
+	routers []*Router
+	// values are read by middlewares through Request.RouteValue
+	values map[interface{}]interface{}
 }
 
 // UsePreMiddlewares adds one or more middlewares to run before the handler does run for a specific route
@@ -26,10 +34,56 @@ func (route *Route) UsePostMiddlewares(middlewares []MiddlewareFunc) *Route{
 	return route
 }
 
+// Require restricts the route to principals having at least one of the given roles
+func (route *Route) Require(roles ...string) *Route {
+	route.requirements = append(route.requirements, Requirement{Kind: RoleRequirement, Values: roles})
+	return route
+}
+
+// Can restricts the route to principals having all of the given permissions
+func (route *Route) Can(permissions ...string) *Route {
+	route.requirements = append(route.requirements, Requirement{Kind: PermissionRequirement, Values: permissions})
+	return route
+}
+
+// WithValue sets a value middlewares can read with Request.RouteValue, it lets a route opt out of
+// or configure a global middleware
+func (route *Route) WithValue(key, value interface{}) *Route {
+	if route.values == nil {
+		route.values = make(map[interface{}]interface{})
+	}
+	route.values[key] = value
+	return route
+}
+
+// servedBy records that router serves the route
+func (route *Route) servedBy(router *Router) {
+	for _, existing := range route.routers {
+		if existing == router {
+			return
+		}
+	}
+	route.routers = append(route.routers, router)
+}
+
+// prefixRoute matches every path under prefix, it is used to mount file servers
+type prefixRoute struct {
+	method Method
+	prefix string
+	route  *Route
+}
+
 type Router struct {
 	routes map[routeKey]*Route
+	prefixRoutes         []prefixRoute
 	globalPreMiddleware  []MiddlewareFunc
 	globalPostMiddleware []MiddlewareFunc
+	requirements         []Requirement
+	policy               Policy
+	// merged is set once the router is merged into another one, which copies its routes
+	merged               bool
+	templates            *Templates
+	trustedProxies       *TrustedProxies
 }
 
 func NewRouter() *Router {
@@ -42,21 +96,72 @@ func NewRouter() *Router {
 }
 
 func (r *Router) MergeRouter(other *Router) {
+    // A route can be registered under several keys (GET and HEAD), it must only be extended once
+    merged := make(map[*Route]bool)
+    merge := func(route *Route) *Route {
+        if !merged[route] {
+            merged[route] = true
+            route.UsePreMiddlewares(other.globalPreMiddleware).UsePostMiddlewares(other.globalPostMiddleware)
+            route.servedBy(r)
+        }
+        return route
+    }
+    other.merged = true
     for key, route := range other.routes {
-        r.routes[key] = route.UsePreMiddlewares(other.globalPreMiddleware).UsePostMiddlewares(other.globalPostMiddleware)
+        r.routes[key] = merge(route)
+    }
+    for _, prefixed := range other.prefixRoutes {
+        prefixed.route = merge(prefixed.route)
+        r.prefixRoutes = append(r.prefixRoutes, prefixed)
     }
 }
 
 func (r *Router) addRoute(method Method, path string, handler func(req *Request, res *Response)) *Route {
+	r.checkNotMerged()
 	route := routeKey{Method: method, Path: path}
 	r.routes[route] = &Route{
 		handler:        handler,
 		preMiddleware:  make([]MiddlewareFunc, 0),
 		postMiddleware: make([]MiddlewareFunc, 0),
+		routers:        []*Router{r},
 	}
 	return r.routes[route]
 }
 
+// addPrefixRoute registers route for every path equal to prefix or below it
+func (r *Router) addPrefixRoute(method Method, prefix string, route *Route) {
+	r.checkNotMerged()
+	route.servedBy(r)
+	r.prefixRoutes = append(r.prefixRoutes, prefixRoute{method: method, prefix: strings.TrimSuffix(prefix, "/"), route: route})
+}
+
+// checkNotMerged panics when a route is added to a router already merged into another one,
+// the route would never be served by the router it was merged into
+func (r *Router) checkNotMerged() {
+	if r.merged {
+		panic("http: routes must be registered before the router is merged into another one")
+	}
+}
+
+// findRoute looks for an exact route first, then for the longest prefix route containing path
+func (r *Router) findRoute(method Method, path string) (*Route, bool) {
+	if route, exists := r.routes[routeKey{Method: method, Path: path}]; exists {
+		return route, true
+	}
+	var found *Route
+	longest := -1
+	for _, prefixed := range r.prefixRoutes {
+		if prefixed.method != method || len(prefixed.prefix) <= longest {
+			continue
+		}
+		if path == prefixed.prefix || strings.HasPrefix(path, prefixed.prefix+"/") {
+			found = prefixed.route
+			longest = len(prefixed.prefix)
+		}
+	}
+	return found, found != nil
+}
+
 func (r *Router) Get(path string, handler func(req *Request, res *Response)) *Route{
 	return r.addRoute(GET, path, handler)
 }
@@ -77,6 +182,14 @@ func (r *Router) Delete(path string, handler func(req *Request, res *Response))
 	return r.addRoute(DELETE, path, handler)
 }
 
+func (r *Router) Head(path string, handler func(req *Request, res *Response)) *Route{
+	return r.addRoute(HEAD, path, handler)
+}
+
+func (r *Router) Options(path string, handler func(req *Request, res *Response)) *Route{
+	return r.addRoute(OPTIONS, path, handler)
+}
+
 // UseGlobalPreMiddlewares adds one or more middlewares to run before the handler does run for all routes registered in a specific router
 func (r *Router) UseGlobalPreMiddlewares(middlewares []MiddlewareFunc) *Router{
 	r.globalPreMiddleware = append(r.globalPreMiddleware, middlewares...)
@@ -89,10 +202,62 @@ func (r *Router) UseGlobalPostMiddlewares(middlewares []MiddlewareFunc) *Router{
 	return r
 }
 
-func (r *Router) Resolve(req *Request, res *Response) {
-	routeKey := routeKey{Method: req.GetMethod(), Path: req.GetPath()}
+// Require restricts every route of the router to principals having at least one of the given roles,
+// including the routes it serves from merged routers, whether it is called before or after the merge
+func (r *Router) Require(roles ...string) *Router {
+	r.requirements = append(r.requirements, Requirement{Kind: RoleRequirement, Values: roles})
+	return r
+}
+
+// Can restricts every route of the router to principals having all of the given permissions,
+// including the routes it serves from merged routers, whether it is called before or after the merge
+func (r *Router) Can(permissions ...string) *Router {
+	r.requirements = append(r.requirements, Requirement{Kind: PermissionRequirement, Values: permissions})
+	return r
+}
+
+// UsePolicy replaces the policy used to evaluate route requirements, DefaultPolicy when none is set.
+// The policy of a router merged into another one keeps applying to the routes it registered.
+func (r *Router) UsePolicy(policy Policy) *Router {
+	r.policy = policy
+	return r
+}
 
-	route, exists := r.routes[routeKey]
+// UseTemplates sets the templates rendered by Response.Render
+func (r *Router) UseTemplates(templates *Templates) *Router {
+	r.templates = templates
+	return r
+}
+
+// Dispatch resolves req into a new response and runs its BeforeWrite hooks, it is used
+// to serve sub-requests that never come from a connection
+func (r *Router) Dispatch(req *Request) *Response {
+	res := NewHttpResponse()
+	r.Resolve(req, res)
+	res.runBeforeWrite()
+	return res
+}
+
+// DispatchHandler runs only the handler of the route matching req into a new response, without
+// the middlewares and the authorization. The request keeps the context set by the middlewares
+// that already let it through, it is used to produce a response again in the background.
+func (r *Router) DispatchHandler(req *Request) *Response {
+	res := NewHttpResponse()
+	res.request = req
+	if route, exists := r.findRoute(req.GetMethod(), req.GetPath()); exists {
+		route.handler(req, res)
+	} else {
+		res.NotFound()
+	}
+	res.runBeforeWrite()
+	return res
+}
+
+func (r *Router) Resolve(req *Request, res *Response) {
+	req.router = r
+	res.request = req
+	route, exists := r.findRoute(req.GetMethod(), req.GetPath())
+	req.route = route
 
 	// Execute global-pre-middlewares
 	for _, middleware := range r.globalPreMiddleware {
@@ -119,6 +284,11 @@ func (r *Router) Resolve(req *Request, res *Response) {
 			}
 		}
 
+		// Check the route requirements against the principal set by the auth middlewares
+		if !authorize(req, res, route) {
+			return
+		}
+
 		// Execute the handler
 		route.handler(req, res)
 
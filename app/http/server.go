@@ -1,16 +1,50 @@
 package http
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http/httputil"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+const (
+	// MaxHeaderBytes caps the size of the request line and headers
+	MaxHeaderBytes = 1 << 20
+	// MaxBodyBytes caps the size of a request body
+	MaxBodyBytes = 10 << 20
+)
+
+// errBodyTooLarge is returned by readRawRequest for bodies over MaxBodyBytes, they get a 413
+var errBodyTooLarge = fmt.Errorf("request body is larger than %d bytes", MaxBodyBytes)
+
 type HttpServer struct {
 	listener net.Listener
 	Port     string
 	Host     string
+	// H2C serves HTTP/2 over cleartext connections, to clients starting with the HTTP/2
+	// preface or asking for an upgrade to h2c. It is enabled by NewHttpServer.
+	H2C bool
+
+	// ctx is the parent of every request context, it is cancelled on Shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu           sync.Mutex
+	conns        map[net.Conn]bool
+	wg           sync.WaitGroup
+	shuttingDown bool
 }
 
 func NewHttpServer(host string, port string) *HttpServer {
@@ -18,6 +52,9 @@ func NewHttpServer(host string, port string) *HttpServer {
 	var err error
 	server.Host = host
 	server.Port = port
+	server.H2C = true
+	server.ctx, server.cancel = context.WithCancel(context.Background())
+	server.conns = make(map[net.Conn]bool)
 	server.listener, err = net.Listen("tcp", fmt.Sprintf("%s:%s", server.Host, server.Port))
 	if err != nil {
 		log.Fatalf("Error starting HTTP server: %v", err)
@@ -26,16 +63,31 @@ func NewHttpServer(host string, port string) *HttpServer {
 	return server
 }
 
+// Addr returns the address the server listens on, the port picked by the system included when Port is "0"
+func (s *HttpServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listener.Addr()
+}
+
 func (s *HttpServer) Listen(router *Router) {
 	defer s.listener.Close()
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
+			if s.isShuttingDown() {
+				return
+			}
 			log.Printf("Error accepting connection: %v", err)
 			continue
 		}
 
+		if !s.track(conn) {
+			conn.Close()
+			return
+		}
 		go func() {
+			defer s.untrack(conn)
 			if err := s.handleConnection(conn, router); err != nil {
 				log.Printf("Error handling connection: %v", err)
 			}
@@ -43,16 +95,111 @@ func (s *HttpServer) Listen(router *Router) {
 	}
 }
 
+// ListenTLS serves HTTPS, HTTP/2 is used with the clients that negotiate it with ALPN
+func (s *HttpServer) ListenTLS(router *Router, certFile string, keyFile string) error {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+	s.mu.Lock()
+	s.listener = tls.NewListener(s.listener, &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		NextProtos:   []string{"h2", "http/1.1"},
+		MinVersion:   tls.VersionTLS12,
+	})
+	s.mu.Unlock()
+	s.Listen(router)
+	return nil
+}
+
+// Shutdown stops accepting connections and cancels the context of the requests in flight,
+// which ends event streams, then waits for the connections to finish. Connections still open
+// when ctx is done are closed and ctx.Err() is returned.
+func (s *HttpServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	listener := s.listener
+	s.mu.Unlock()
+	listener.Close()
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *HttpServer) isShuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shuttingDown
+}
+
+// track registers a new connection, it returns false once the server is shutting down
+func (s *HttpServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown {
+		return false
+	}
+	s.conns[conn] = true
+	s.wg.Add(1)
+	return true
+}
+
+func (s *HttpServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
 func (s *HttpServer) handleConnection(conn net.Conn, router *Router) error {
-	var _ int
 	var err error
 	var request *Request
 
-	rawRequest := make([]byte, 2048)
+	defer conn.Close()
 
-	_, err = conn.Read(rawRequest)
+	reader := bufio.NewReader(conn)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("TLS handshake: %w", err)
+		}
+		tlsConn.SetDeadline(time.Time{})
+		if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+			return newHTTP2Conn(s, conn, reader, router).serve(nil, nil)
+		}
+	} else if s.H2C {
+		// No HTTP/1.1 method is PRI, so the start of the HTTP/2 preface tells them apart
+		if start, err := reader.Peek(3); err == nil && string(start) == "PRI" {
+			return newHTTP2Conn(s, conn, reader, router).serve(nil, nil)
+		}
+	}
 
+	rawRequest, err := readRawRequest(reader)
 	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.Is(err, errBodyTooLarge) {
+			response := NewHttpResponse()
+			response.ErrorResponse(StatusRequestEntityTooLarge, "request body is too large")
+			response.SetHeader("Connection", "close")
+			response.writeTo(conn, true)
+		}
 		return fmt.Errorf("reading request: %w", err)
 	}
 
@@ -60,16 +207,115 @@ func (s *HttpServer) handleConnection(conn net.Conn, router *Router) error {
 	if err != nil {
 		return err
 	}
+	request.remoteAddr = conn.RemoteAddr().String()
+	_, request.tls = conn.(*tls.Conn)
+	if settings, ok := s.h2cUpgrade(conn, request); ok {
+		return newHTTP2Conn(s, conn, reader, router).serve(request, settings)
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	request.ctx = ctx
 
 	response := NewHttpResponse()
+	defer response.runAfterWrite()
+	response.conn = conn
+	response.reader = reader
+	response.commit = func() (io.Writer, error) {
+		if _, _, err := response.Hijack(); err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(conn, response.head()); err != nil {
+			return nil, err
+		}
+		// Clients don't send anything once the response is committed, the read only returns when they disconnect
+		go func() {
+			io.Copy(io.Discard, reader)
+			cancel()
+		}()
+		return conn, nil
+	}
 	router.Resolve(request, response)
-	_, err = conn.Write([]byte(response.String()))
+	if response.hijacked {
+		if response.events != nil {
+			return response.events.Close()
+		}
+		return nil
+	}
+	response.runBeforeWrite()
+	return response.writeTo(conn, request.GetMethod() != HEAD)
+}
+
+// h2cUpgrade switches to HTTP/2 when the request asks for an upgrade to h2c, RFC 7540 section 3.2.
+// It returns the decoded HTTP2-Settings of the client, the request is answered on stream 1.
+// Requests with a body stay on HTTP/1.1, the body would have to be read before switching.
+func (s *HttpServer) h2cUpgrade(conn net.Conn, request *Request) ([]byte, bool) {
+	if !s.H2C || len(request.GetBody()) > 0 || !headerContainsToken(request.GetHeader("Upgrade"), "h2c") ||
+		!headerContainsToken(request.GetHeader("Connection"), "http2-settings") {
+		return nil, false
+	}
+	settings, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(request.GetHeader("HTTP2-Settings"), "="))
 	if err != nil {
-		return err
+		return nil, false
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"); err != nil {
+		return nil, false
+	}
+	for _, headerName := range []string{"Upgrade", "Connection", "HTTP2-Settings"} {
+		request.DeleteHeader(headerName)
+	}
+	return settings, true
+}
+
+// readRawRequest reads the request head up to the empty line, then the body announced by
+// Content-Length or sent with chunked transfer encoding, which is decoded on the fly
+func readRawRequest(reader *bufio.Reader) ([]byte, error) {
+	var raw bytes.Buffer
+	contentLength := int64(0)
+	chunked := false
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		raw.WriteString(line)
+		if raw.Len() > MaxHeaderBytes {
+			return nil, fmt.Errorf("request head is larger than %d bytes", MaxHeaderBytes)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "content-length":
+			contentLength, err = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil || contentLength < 0 {
+				return nil, fmt.Errorf("invalid Content-Length: %s", value)
+			}
+		case "transfer-encoding":
+			chunked = strings.EqualFold(strings.TrimSpace(value), "chunked")
+		}
+	}
+
+	var body io.Reader
+	switch {
+	case chunked:
+		body = httputil.NewChunkedReader(reader)
+	case contentLength > MaxBodyBytes:
+		return nil, errBodyTooLarge
+	default:
+		body = io.LimitReader(reader, contentLength)
 	}
-	err = conn.Close()
+	n, err := io.Copy(&raw, io.LimitReader(body, MaxBodyBytes+1))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	if n > MaxBodyBytes {
+		return nil, errBodyTooLarge
+	}
+	return raw.Bytes(), nil
 }
@@ -0,0 +1,77 @@
+package http
+
+import (
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// HashedAssetPattern recognizes file names carrying a content hash like "app.3f9a1c2b.js"
+// or "index-B7x9k2Qd.css", those never change and are cached for a year. The hash has to be
+// at least 8 characters long and contain a digit, so "favicon-32x32.png" or "jquery-3.js" don't match.
+var HashedAssetPattern = regexp.MustCompile(`[.-]([A-Za-z0-9_]{8,})\.[A-Za-z0-9]+$`)
+
+// isHashedAsset reports whether name matches HashedAssetPattern with a digit in its hash
+func isHashedAsset(name string) bool {
+	match := HashedAssetPattern.FindStringSubmatch(name)
+	return match != nil && strings.ContainsAny(match[1], "0123456789")
+}
+
+const (
+	cacheImmutable = "public, max-age=31536000, immutable"
+	cacheNoCache   = "no-cache"
+)
+
+// SPA serves a single page application built into fsys under prefix. Existing files are served
+// as with StaticFS, any other path without a file extension gets indexFile so the client-side
+// router can handle it. Paths under the exclude prefixes, like "/api", get a 404 instead.
+func (r *Router) SPA(prefix string, fsys fs.FS, indexFile string, exclude ...string) *Route {
+	prefix = strings.TrimSuffix(prefix, "/")
+	options := StaticOptions{Index: indexFile, Precompressed: true}
+	route := &Route{
+		handler: func(req *Request, res *Response) {
+			serveSPA(req, res, fsys, prefix, exclude, options)
+		},
+		preMiddleware:  make([]MiddlewareFunc, 0),
+		postMiddleware: make([]MiddlewareFunc, 0),
+	}
+	r.addPrefixRoute(GET, prefix, route)
+	r.addPrefixRoute(HEAD, prefix, route)
+	return route
+}
+
+func serveSPA(req *Request, res *Response, fsys fs.FS, prefix string, exclude []string, options StaticOptions) {
+	for _, excluded := range exclude {
+		excluded = strings.TrimSuffix(excluded, "/")
+		if req.GetPath() == excluded || strings.HasPrefix(req.GetPath(), excluded+"/") {
+			res.NotFound()
+			return
+		}
+	}
+
+	name, ok := cleanFSPath(strings.TrimPrefix(req.GetPath(), prefix), false)
+	if ok && name != "." {
+		if info, err := fs.Stat(fsys, name); err == nil && !info.IsDir() {
+			options.CacheControl = cacheNoCache
+			if name != options.Index && isHashedAsset(path.Base(name)) {
+				options.CacheControl = cacheImmutable
+			}
+			serveFile(req, res, fsys, name, info, options)
+			return
+		}
+		// A missing script or image must not be answered with the HTML of the index
+		if path.Ext(name) != "" {
+			res.NotFound()
+			return
+		}
+	}
+
+	info, err := fs.Stat(fsys, options.Index)
+	if err != nil || info.IsDir() {
+		res.NotFound()
+		return
+	}
+	options.CacheControl = cacheNoCache
+	serveFile(req, res, fsys, options.Index, info, options)
+}
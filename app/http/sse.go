@@ -0,0 +1,227 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SSEHeartbeatInterval is how often an idle event stream sends a comment, so proxies
+// don't time the connection out and disconnected clients are noticed
+var SSEHeartbeatInterval = 15 * time.Second
+
+// ErrStreamClosed is returned when sending on an event stream whose client disconnected
+// or whose server is shutting down
+var ErrStreamClosed = errors.New("http: event stream closed")
+
+// EventStream is a Server-Sent Events stream opened with Response.SSE
+type EventStream struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	closed bool
+
+	ctx         context.Context
+	cancel      context.CancelFunc
+	lastEventID string
+}
+
+// SSE starts a text/event-stream response and returns the stream to send events on.
+// The headers are written right away, so the BeforeWrite hooks run now, and every event
+// is flushed as soon as it is sent. The stream is done once the request context is, when
+// the client disconnects or the server shuts down. The handler keeps the request open until
+// it returns:
+//
+//	stream := res.SSE()
+//	for _, event := range events.Since(stream.LastEventID()) {
+//		stream.Send(event.Event, event.ID, event.Data)
+//	}
+//	for {
+//		select {
+//		case <-stream.Done():
+//			return
+//		case order := <-orders:
+//			stream.Send("order", order.ID, order.JSON())
+//		}
+//	}
+func (r *Response) SSE() *EventStream {
+	stream := &EventStream{}
+	stream.ctx, stream.cancel = context.WithCancel(context.Background())
+	if r.request != nil {
+		stream.ctx, stream.cancel = context.WithCancel(r.request.Context())
+		stream.lastEventID = r.request.GetHeader("Last-Event-ID")
+	}
+
+	r.SetStatusCode(StatusOK)
+	r.SetHeader("Date", time.Now().UTC().Format(time.RFC1123))
+	r.SetHeader("Server", "GoHTTP/1.0")
+	r.SetHeader("Connection", "close")
+	r.SetHeader("Content-Type", "text/event-stream; charset=utf-8")
+	r.SetHeader("Cache-Control", "no-cache")
+	r.SetHeader("X-Accel-Buffering", "no")
+	r.DeleteHeader("Content-Length")
+	r.body = ""
+	r.stream = nil
+
+	w, err := r.commitHead()
+	if err != nil {
+		stream.cancel()
+		r.ErrorResponse(StatusInternalServerError, "event streams can only be sent on a connection")
+		return stream
+	}
+	r.events = stream
+	if r.request.GetMethod() == HEAD {
+		stream.cancel()
+		return stream
+	}
+	stream.w = w
+	if r.wrapWriter != nil {
+		wrapped := r.wrapWriter(w)
+		stream.w, stream.closer = wrapped, wrapped
+	}
+
+	go stream.heartbeat()
+	return stream
+}
+
+// LastEventID returns the id of the last event the client received before reconnecting,
+// or an empty string for a new client
+func (s *EventStream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed once the client disconnects or the server shuts down
+func (s *EventStream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Send sends an event, event and id can be empty and data can span several lines
+func (s *EventStream) Send(event string, id string, data string) error {
+	if strings.ContainsAny(event, "\r\n") || strings.ContainsAny(id, "\r\n\x00") {
+		return errors.New("http: event names and ids cannot contain line breaks")
+	}
+	var message strings.Builder
+	if id != "" {
+		message.WriteString("id: " + id + "\n")
+	}
+	if event != "" {
+		message.WriteString("event: " + event + "\n")
+	}
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		message.WriteString("data: " + line + "\n")
+	}
+	message.WriteString("\n")
+	return s.write(message.String())
+}
+
+// Retry tells the client how long to wait before reconnecting once the stream ends
+func (s *EventStream) Retry(delay time.Duration) error {
+	return s.write("retry: " + strconv.FormatInt(delay.Milliseconds(), 10) + "\n\n")
+}
+
+// Close ends the stream, the server also closes it once the handler returns
+func (s *EventStream) Close() error {
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.closer != nil {
+		return s.closer.Close()
+	}
+	return nil
+}
+
+func (s *EventStream) heartbeat() {
+	ticker := time.NewTicker(SSEHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.write(": heartbeat\n\n")
+		}
+	}
+}
+
+// write sends a message and flushes the writers wrapping the connection,
+// the stream is cancelled when the connection fails
+func (s *EventStream) write(message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || s.w == nil || s.ctx.Err() != nil {
+		return ErrStreamClosed
+	}
+	_, err := io.WriteString(s.w, message)
+	if flusher, ok := s.w.(interface{ Flush() error }); ok && err == nil {
+		err = flusher.Flush()
+	}
+	if err != nil {
+		s.cancel()
+		return ErrStreamClosed
+	}
+	return nil
+}
+
+// Event is an event kept by an EventLog
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// EventLog keeps the last events sent on a topic with increasing ids, so clients
+// reconnecting with a Last-Event-ID can be sent the events they missed
+type EventLog struct {
+	mu     sync.Mutex
+	size   int
+	nextID uint64
+	events []Event
+}
+
+// NewEventLog creates a log keeping the last size events
+func NewEventLog(size int) *EventLog {
+	return &EventLog{size: size, nextID: 1}
+}
+
+// Append records an event and returns it with its id
+func (l *EventLog) Append(event string, data string) Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	appended := Event{ID: strconv.FormatUint(l.nextID, 10), Event: event, Data: data}
+	l.nextID++
+	l.events = append(l.events, appended)
+	if len(l.events) > l.size {
+		l.events = l.events[len(l.events)-l.size:]
+	}
+	return appended
+}
+
+// Since returns the events following lastEventID. Every event still kept is returned when
+// lastEventID is too old, and none when it is empty since the client didn't miss anything.
+func (l *EventLog) Since(lastEventID string) []Event {
+	if lastEventID == "" {
+		return nil
+	}
+	last, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	missed := make([]Event, 0)
+	for _, event := range l.events {
+		if id, _ := strconv.ParseUint(event.ID, 10, 64); id > last {
+			missed = append(missed, event)
+		}
+	}
+	return missed
+}
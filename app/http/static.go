@@ -0,0 +1,388 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	nethttp "net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StaticOptions configures how files are served by Router.Static and Router.StaticFS
+type StaticOptions struct {
+	// Index is the file served for a directory, "index.html" when empty
+	Index string
+	// Browse lists the content of directories that have no index file
+	Browse bool
+	// Precompressed serves "file.gz" instead of "file" to clients accepting gzip
+	Precompressed bool
+	// Dotfiles allows serving files and directories whose name starts with a dot
+	Dotfiles bool
+	// CacheControl is sent with every file when set
+	CacheControl string
+}
+
+// DefaultStaticOptions are used by Router.Static
+var DefaultStaticOptions = StaticOptions{
+	Index:         "index.html",
+	Precompressed: true,
+}
+
+// Static serves the files of dir under prefix, for GET and HEAD requests
+func (r *Router) Static(prefix string, dir string) *Route {
+	return r.StaticFS(prefix, os.DirFS(dir), DefaultStaticOptions)
+}
+
+// StaticFS serves the files of fsys under prefix, fsys can be an embed.FS
+func (r *Router) StaticFS(prefix string, fsys fs.FS, options StaticOptions) *Route {
+	if options.Index == "" {
+		options.Index = DefaultStaticOptions.Index
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	route := &Route{
+		handler: func(req *Request, res *Response) {
+			ServeFS(req, res, fsys, strings.TrimPrefix(req.GetPath(), prefix), options)
+		},
+		preMiddleware:  make([]MiddlewareFunc, 0),
+		postMiddleware: make([]MiddlewareFunc, 0),
+	}
+	r.addPrefixRoute(GET, prefix, route)
+	r.addPrefixRoute(HEAD, prefix, route)
+	return route
+}
+
+// ServeFS answers the request with the file of fsys found at urlPath, the percent-encoded
+// path relative to where fsys is mounted. It handles directories, conditional and range requests.
+func ServeFS(req *Request, res *Response, fsys fs.FS, urlPath string, options StaticOptions) {
+	name, ok := cleanFSPath(urlPath, options.Dotfiles)
+	if !ok {
+		res.NotFound()
+		return
+	}
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		res.NotFound()
+		return
+	}
+
+	if info.IsDir() {
+		// Relative links inside the directory only resolve when the URL ends with a slash
+		if !strings.HasSuffix(req.GetPath(), "/") {
+			res.SetStatusCode(StatusMovedPermanently)
+			res.SetHeader("Location", req.GetPath()+"/")
+			res.SetHeader("Content-Length", "0")
+			return
+		}
+		index := path.Join(name, options.Index)
+		if indexInfo, err := fs.Stat(fsys, index); err == nil && !indexInfo.IsDir() {
+			serveFile(req, res, fsys, index, indexInfo, options)
+			return
+		}
+		if options.Browse {
+			listDirectory(res, fsys, name, options)
+			return
+		}
+		res.NotFound()
+		return
+	}
+	serveFile(req, res, fsys, name, info, options)
+}
+
+// cleanFSPath turns a URL path into a valid fs.FS name, path.Clean removes every ".." so
+// the result can't escape the root of the file system
+func cleanFSPath(urlPath string, dotfiles bool) (string, bool) {
+	decoded, err := url.PathUnescape(urlPath)
+	if err != nil || strings.ContainsAny(decoded, "\x00\\") {
+		return "", false
+	}
+	name := strings.TrimPrefix(path.Clean("/"+decoded), "/")
+	if name == "" {
+		return ".", true
+	}
+	if !dotfiles {
+		for _, segment := range strings.Split(name, "/") {
+			if strings.HasPrefix(segment, ".") {
+				return "", false
+			}
+		}
+	}
+	return name, fs.ValidPath(name)
+}
+
+func serveFile(req *Request, res *Response, fsys fs.FS, name string, info fs.FileInfo, options StaticOptions) {
+	contentType, err := detectContentType(fsys, name)
+	if err != nil {
+		res.ErrorResponse(StatusInternalServerError, "file cannot be read")
+		return
+	}
+
+	if options.Precompressed {
+		if gzInfo, err := fs.Stat(fsys, name+".gz"); err == nil && !gzInfo.IsDir() {
+			res.AppendHeader("Vary", "Accept-Encoding")
+			if acceptsGzip(req.GetHeader("Accept-Encoding")) {
+				res.SetHeader("Content-Encoding", "gzip")
+				name, info = name+".gz", gzInfo
+			}
+		}
+	}
+
+	etag, err := fileETag(fsys, name, info)
+	if err != nil {
+		res.ErrorResponse(StatusInternalServerError, "file cannot be read")
+		return
+	}
+	if options.CacheControl != "" {
+		res.SetHeader("Cache-Control", options.CacheControl)
+	}
+	res.SetHeader("Accept-Ranges", "bytes")
+	if !res.CheckPreconditions(req, etag, info.ModTime()) {
+		return
+	}
+
+	size := info.Size()
+	ranges, err := parseRange(req.GetHeader("Range"), size)
+	if err != nil || !ifRangeMatches(req, etag, info.ModTime()) {
+		ranges = nil
+	}
+	if err == errUnsatisfiableRange && ifRangeMatches(req, etag, info.ModTime()) {
+		res.ErrorResponse(StatusRequestedRangeNotSatisfiable, "range not satisfiable")
+		res.SetHeader("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return
+	}
+
+	switch len(ranges) {
+	case 0:
+		res.Stream(StatusOK, contentType, func(w io.Writer) error {
+			return copyFileRange(w, fsys, name, 0, size)
+		})
+		res.SetHeader("Content-Length", strconv.FormatInt(size, 10))
+	case 1:
+		ra := ranges[0]
+		res.Stream(StatusPartialContent, contentType, func(w io.Writer) error {
+			return copyFileRange(w, fsys, name, ra.start, ra.length)
+		})
+		res.SetHeader("Content-Range", ra.contentRange(size))
+		res.SetHeader("Content-Length", strconv.FormatInt(ra.length, 10))
+	default:
+		boundary := multipart.NewWriter(io.Discard).Boundary()
+		res.Stream(StatusPartialContent, "multipart/byteranges; boundary="+boundary, func(w io.Writer) error {
+			parts := multipart.NewWriter(w)
+			parts.SetBoundary(boundary)
+			for _, ra := range ranges {
+				part, err := parts.CreatePart(textproto.MIMEHeader{
+					"Content-Type":  {contentType},
+					"Content-Range": {ra.contentRange(size)},
+				})
+				if err != nil {
+					return err
+				}
+				if err := copyFileRange(part, fsys, name, ra.start, ra.length); err != nil {
+					return err
+				}
+			}
+			return parts.Close()
+		})
+	}
+}
+
+// detectContentType uses the file extension, falling back to sniffing the first 512 bytes
+func detectContentType(fsys fs.FS, name string) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType, nil
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return nethttp.DetectContentType(buf[:n]), nil
+}
+
+// fileETag derives a strong ETag from the size and modification time, or from the content
+// when the file system has no modification times like embed.FS
+func fileETag(fsys fs.FS, name string, info fs.FileInfo) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()), nil
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`, nil
+}
+
+func acceptsGzip(acceptEncoding string) bool {
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q := strings.TrimSpace(params); strings.HasPrefix(q, "q=") {
+			if weight, err := strconv.ParseFloat(q[2:], 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func copyFileRange(w io.Writer, fsys fs.FS, name string, start int64, length int64) error {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if start > 0 {
+		if seeker, ok := file.(io.Seeker); ok {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		} else if _, err := io.CopyN(io.Discard, file, start); err != nil {
+			return err
+		}
+	}
+	_, err = io.CopyN(w, file, length)
+	return err
+}
+
+func listDirectory(res *Response, fsys fs.FS, name string, options StaticOptions) {
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		res.ErrorResponse(StatusInternalServerError, "directory cannot be read")
+		return
+	}
+	var b strings.Builder
+	b.WriteString("<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n")
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if !options.Dotfiles && strings.HasPrefix(entryName, ".") {
+			continue
+		}
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		link := url.URL{Path: entryName}
+		fmt.Fprintf(&b, "<a href=\"%s\">%s</a>\n", html.EscapeString(link.String()), html.EscapeString(entryName))
+	}
+	b.WriteString("</pre>\n")
+	res.HttpResponse(b.String(), StatusOK)
+	res.SetHeader("Content-Type", "text/html; charset=utf-8")
+}
+
+var errUnsatisfiableRange = errors.New("http: unsatisfiable range")
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a "bytes=" Range header. Ranges that don't overlap the file are dropped,
+// errUnsatisfiableRange is returned when none is left. Malformed headers are ignored as the RFC asks.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	if header == "" {
+		return nil, nil
+	}
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found {
+		return nil, errors.New("http: unsupported range unit")
+	}
+	ranges := make([]byteRange, 0)
+	total := int64(0)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		first, last, found := strings.Cut(item, "-")
+		if !found {
+			return nil, errors.New("http: invalid range")
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+		var ra byteRange
+		if first == "" {
+			// suffix range: the last N bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errors.New("http: invalid range")
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			ra = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errors.New("http: invalid range")
+			}
+			if start >= size {
+				continue
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errors.New("http: invalid range")
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			ra = byteRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, ra)
+		total += ra.length
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	// Asking for more than the file in several ranges is cheaper served whole
+	if total > size {
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// ifRangeMatches reports whether the Range header applies, If-Range turns it off when the
+// representation changed since the client got its validator
+func ifRangeMatches(req *Request, etag string, modTime time.Time) bool {
+	ifRange := req.GetHeader("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return !strings.HasPrefix(ifRange, "W/") && ifRange == etag
+	}
+	since, ok := parseHTTPDate(ifRange)
+	return ok && !modTime.IsZero() && modTime.Truncate(time.Second).Equal(since)
+}
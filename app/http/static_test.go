@@ -0,0 +1,186 @@
+package http
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	nethttp "net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []byteRange
+		err    error
+	}{
+		{"", nil, nil},
+		{"bytes=0-4", []byteRange{{0, 5}}, nil},
+		{"bytes=5-", []byteRange{{5, 5}}, nil},
+		{"bytes=-3", []byteRange{{7, 3}}, nil},
+		{"bytes=-20", []byteRange{{0, 10}}, nil},
+		{"bytes=8-100", []byteRange{{8, 2}}, nil},
+		{"bytes=0-1, 4-5", []byteRange{{0, 2}, {4, 2}}, nil},
+		{"bytes=10-", nil, errUnsatisfiableRange},
+		{"bytes=-0", nil, errUnsatisfiableRange},
+		// Overlapping ranges larger than the file are served whole
+		{"bytes=0-9, 0-9", nil, nil},
+	}
+	for _, test := range tests {
+		got, err := parseRange(test.header, 10)
+		if err != test.err || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseRange(%q) = %v, %v, want %v, %v", test.header, got, err, test.want, test.err)
+		}
+	}
+	for _, header := range []string{"items=0-1", "bytes=5-2", "bytes=a-b"} {
+		if _, err := parseRange(header, 10); err == nil || err == errUnsatisfiableRange {
+			t.Errorf("parseRange(%q) error = %v, want a malformed range error", header, err)
+		}
+	}
+}
+
+func staticTestServer(t *testing.T) string {
+	t.Helper()
+	modTime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"hello.txt":       {Data: []byte("0123456789"), ModTime: modTime},
+		"app.js":          {Data: []byte("console.log(1)"), ModTime: modTime},
+		"app.js.gz":       {Data: []byte("gzipped"), ModTime: modTime},
+		"docs/index.html": {Data: []byte("<h1>docs</h1>"), ModTime: modTime},
+		".env":            {Data: []byte("SECRET=1"), ModTime: modTime},
+	}
+	router := NewRouter()
+	router.StaticFS("/static", fsys, DefaultStaticOptions)
+	return StartTestServer(t, router)
+}
+
+func getStatic(t *testing.T, url string, headers map[string]string) (*nethttp.Response, string) {
+	t.Helper()
+	req, _ := nethttp.NewRequest("GET", url, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	client := &nethttp.Client{
+		Transport: &nethttp.Transport{DisableCompression: true},
+		CheckRedirect: func(req *nethttp.Request, via []*nethttp.Request) error {
+			return nethttp.ErrUseLastResponse
+		},
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	return response, string(body)
+}
+
+func TestStaticServesFiles(t *testing.T) {
+	baseURL := staticTestServer(t)
+
+	response, body := getStatic(t, baseURL+"/static/hello.txt", nil)
+	if response.StatusCode != 200 || body != "0123456789" {
+		t.Fatalf("status = %d, body = %q", response.StatusCode, body)
+	}
+	if response.Header.Get("Accept-Ranges") != "bytes" || response.Header.Get("Content-Length") != "10" {
+		t.Errorf("Accept-Ranges = %q, Content-Length = %q", response.Header.Get("Accept-Ranges"), response.Header.Get("Content-Length"))
+	}
+	if response.Header.Get("ETag") == "" || response.Header.Get("Last-Modified") != "Fri, 02 Jan 2026 03:04:05 GMT" {
+		t.Errorf("ETag = %q, Last-Modified = %q", response.Header.Get("ETag"), response.Header.Get("Last-Modified"))
+	}
+
+	response, _ = getStatic(t, baseURL+"/static/docs", nil)
+	if response.StatusCode != 301 || response.Header.Get("Location") != "/static/docs/" {
+		t.Errorf("directory without slash: status = %d, Location = %q", response.StatusCode, response.Header.Get("Location"))
+	}
+	if _, body := getStatic(t, baseURL+"/static/docs/", nil); body != "<h1>docs</h1>" {
+		t.Errorf("directory index body = %q", body)
+	}
+
+	for _, path := range []string{"/static/.env", "/static/..%2f..%2fetc/passwd", "/static/missing.txt"} {
+		if response, _ := getStatic(t, baseURL+path, nil); response.StatusCode != 404 {
+			t.Errorf("GET %s: status = %d, want 404", path, response.StatusCode)
+		}
+	}
+
+	response, body = getStatic(t, baseURL+"/static/app.js", map[string]string{"Accept-Encoding": "gzip"})
+	if response.Header.Get("Content-Encoding") != "gzip" || body != "gzipped" {
+		t.Errorf("precompressed: Content-Encoding = %q, body = %q", response.Header.Get("Content-Encoding"), body)
+	}
+	if response.Header.Get("Vary") != "Accept-Encoding" {
+		t.Errorf("precompressed: Vary = %q", response.Header.Get("Vary"))
+	}
+	if _, body := getStatic(t, baseURL+"/static/app.js", nil); body != "console.log(1)" {
+		t.Errorf("identity body = %q", body)
+	}
+}
+
+func TestStaticRanges(t *testing.T) {
+	baseURL := staticTestServer(t)
+	url := baseURL + "/static/hello.txt"
+
+	response, body := getStatic(t, url, map[string]string{"Range": "bytes=2-5"})
+	if response.StatusCode != 206 || body != "2345" || response.Header.Get("Content-Range") != "bytes 2-5/10" {
+		t.Errorf("single range: status = %d, body = %q, Content-Range = %q", response.StatusCode, body, response.Header.Get("Content-Range"))
+	}
+	if _, body := getStatic(t, url, map[string]string{"Range": "bytes=-3"}); body != "789" {
+		t.Errorf("suffix range body = %q", body)
+	}
+
+	response, body = getStatic(t, url, map[string]string{"Range": "bytes=0-1,8-9"})
+	mediaType, params, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if response.StatusCode != 206 || mediaType != "multipart/byteranges" {
+		t.Fatalf("multiple ranges: status = %d, Content-Type = %q", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	var parts []string
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		data, _ := io.ReadAll(part)
+		parts = append(parts, part.Header.Get("Content-Range")+" "+string(data))
+	}
+	if want := []string{"bytes 0-1/10 01", "bytes 8-9/10 89"}; !reflect.DeepEqual(parts, want) {
+		t.Errorf("parts = %q, want %q", parts, want)
+	}
+
+	response, _ = getStatic(t, url, map[string]string{"Range": "bytes=20-30"})
+	if response.StatusCode != 416 || response.Header.Get("Content-Range") != "bytes */10" {
+		t.Errorf("unsatisfiable: status = %d, Content-Range = %q", response.StatusCode, response.Header.Get("Content-Range"))
+	}
+
+	response, body = getStatic(t, url, map[string]string{"Range": "bytes=2-5", "If-Range": `"outdated"`})
+	if response.StatusCode != 200 || body != "0123456789" {
+		t.Errorf("If-Range mismatch: status = %d, body = %q", response.StatusCode, body)
+	}
+}
+
+func TestStaticConditionalRequests(t *testing.T) {
+	baseURL := staticTestServer(t)
+	url := baseURL + "/static/hello.txt"
+	response, _ := getStatic(t, url, nil)
+	etag := response.Header.Get("ETag")
+
+	response, body := getStatic(t, url, map[string]string{"If-None-Match": etag})
+	if response.StatusCode != 304 || body != "" {
+		t.Errorf("If-None-Match: status = %d, body = %q", response.StatusCode, body)
+	}
+	response, _ = getStatic(t, url, map[string]string{"If-Modified-Since": "Fri, 02 Jan 2026 03:04:05 GMT"})
+	if response.StatusCode != 304 {
+		t.Errorf("If-Modified-Since: status = %d, want 304", response.StatusCode)
+	}
+	response, _ = getStatic(t, url, map[string]string{"If-Modified-Since": "Thu, 01 Jan 2026 00:00:00 GMT"})
+	if response.StatusCode != 200 {
+		t.Errorf("older If-Modified-Since: status = %d, want 200", response.StatusCode)
+	}
+	response, _ = getStatic(t, url, map[string]string{"If-Match": `"other"`})
+	if response.StatusCode != 412 {
+		t.Errorf("If-Match mismatch: status = %d, want 412", response.StatusCode)
+	}
+}
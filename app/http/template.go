@@ -0,0 +1,185 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrTemplateNotFound is returned when rendering a page that isn't in the registry
+var ErrTemplateNotFound = errors.New("http: template not found")
+
+// TemplateOptions configures a template registry
+type TemplateOptions struct {
+	// Extension of the template files, ".tmpl" when empty
+	Extension string
+	// Layout is the layout pages are rendered in by default, like "layouts/main",
+	// pages are rendered on their own when empty
+	Layout string
+	Funcs  template.FuncMap
+	// RequestFuncs returns functions bound to the request being rendered, like the CSRF token
+	// or the CSP nonce. It is also called with an empty request when the templates are parsed.
+	RequestFuncs func(req *Request) template.FuncMap
+	// Reload parses the templates again on every render, for development
+	Reload bool
+}
+
+// Templates is a registry of html/template pages. Files under layouts/ are layouts and files
+// under partials/ are partials, every other file is a page named after its path without
+// extension, like "users/show". A page defines the blocks its layout uses:
+//
+//	{{define "content"}}<h1>{{.Name}}</h1>{{template "partials/avatar" .}}{{end}}
+//
+// Layouts and partials are shared, each page is parsed in its own set so they can all define "content".
+type Templates struct {
+	fsys    fs.FS
+	options TemplateOptions
+
+	pages map[string]*template.Template
+}
+
+// LoadTemplates parses every template under dir
+func LoadTemplates(dir string, options TemplateOptions) (*Templates, error) {
+	return NewTemplates(os.DirFS(dir), options)
+}
+
+// NewTemplates parses every template of fsys, which can be an embed.FS, so errors show up at startup
+func NewTemplates(fsys fs.FS, options TemplateOptions) (*Templates, error) {
+	if options.Extension == "" {
+		options.Extension = ".tmpl"
+	}
+	templates := &Templates{fsys: fsys, options: options}
+	pages, err := templates.parse()
+	if err != nil {
+		return nil, err
+	}
+	templates.pages = pages
+	return templates, nil
+}
+
+func (t *Templates) parse() (map[string]*template.Template, error) {
+	funcs := template.FuncMap{}
+	for name, fn := range t.options.Funcs {
+		funcs[name] = fn
+	}
+	if t.options.RequestFuncs != nil {
+		for name, fn := range t.options.RequestFuncs(&Request{}) {
+			funcs[name] = fn
+		}
+	}
+
+	base := template.New("").Funcs(funcs)
+	pageFiles := make([]string, 0)
+	err := fs.WalkDir(t.fsys, ".", func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || path.Ext(filePath) != t.options.Extension {
+			return err
+		}
+		if !strings.HasPrefix(filePath, "layouts/") && !strings.HasPrefix(filePath, "partials/") {
+			pageFiles = append(pageFiles, filePath)
+			return nil
+		}
+		return parseTemplateFile(base, t.fsys, filePath, t.options.Extension)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make(map[string]*template.Template, len(pageFiles))
+	for _, filePath := range pageFiles {
+		page, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		if err := parseTemplateFile(page, t.fsys, filePath, t.options.Extension); err != nil {
+			return nil, err
+		}
+		pages[strings.TrimSuffix(filePath, t.options.Extension)] = page
+	}
+	return pages, nil
+}
+
+func parseTemplateFile(set *template.Template, fsys fs.FS, filePath string, extension string) error {
+	content, err := fs.ReadFile(fsys, filePath)
+	if err != nil {
+		return err
+	}
+	if _, err := set.New(strings.TrimSuffix(filePath, extension)).Parse(string(content)); err != nil {
+		return fmt.Errorf("parsing template %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// Render executes the page inside layout, or on its own when layout is empty, and writes it to w
+func (t *Templates) Render(w io.Writer, req *Request, name string, layout string, data interface{}) error {
+	pages := t.pages
+	if t.options.Reload {
+		reloaded, err := t.parse()
+		if err != nil {
+			return err
+		}
+		pages = reloaded
+	}
+
+	page, exists := pages[name]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	if t.options.RequestFuncs != nil && req != nil {
+		// Executed templates can't be cloned, so the cached page itself is never executed
+		clone, err := page.Clone()
+		if err != nil {
+			return err
+		}
+		page = clone.Funcs(t.options.RequestFuncs(req))
+	}
+	if layout == "" {
+		return page.ExecuteTemplate(w, name, data)
+	}
+	return page.ExecuteTemplate(w, layout, data)
+}
+
+// Render sends a page of the router templates in the default layout
+func (r *Response) Render(name string, data interface{}) {
+	layout := ""
+	if templates := r.templates(); templates != nil {
+		layout = templates.options.Layout
+	}
+	r.RenderLayout(layout, name, data)
+}
+
+// RenderLayout sends a page of the router templates in layout, or on its own when layout is empty
+func (r *Response) RenderLayout(layout string, name string, data interface{}) {
+	templates := r.templates()
+	if templates == nil {
+		log.Printf("Error rendering %s: no templates registered on the router", name)
+		r.ErrorResponse(StatusInternalServerError, "the page could not be rendered")
+		return
+	}
+	var body bytes.Buffer
+	if err := templates.Render(&body, r.request, name, layout, data); err != nil {
+		log.Printf("Error rendering %s: %v", name, err)
+		r.ErrorResponse(StatusInternalServerError, "the page could not be rendered")
+		return
+	}
+	r.SetStatusCode(StatusOK)
+	r.SetHeader("Date", time.Now().UTC().Format(time.RFC1123))
+	r.SetHeader("Server", "GoHTTP/1.0")
+	r.SetHeader("Connection", "close")
+	r.SetHeader("Content-Type", "text/html; charset=utf-8")
+	r.SetBody(body.String())
+}
+
+func (r *Response) templates() *Templates {
+	if r.request == nil || r.request.router == nil {
+		return nil
+	}
+	return r.request.router.templates
+}
@@ -0,0 +1,20 @@
+package http
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// StartTestServer serves router on a free local port until the test ends and returns its base URL
+func StartTestServer(t testing.TB, router *Router) string {
+	t.Helper()
+	server := NewHttpServer("127.0.0.1", "0")
+	go server.Listen(router)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+	return "http://" + server.Addr().String()
+}
@@ -0,0 +1,432 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	nethttp "net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalanceStrategy chooses the upstream of each request sent through an UpstreamPool
+type BalanceStrategy int
+
+const (
+	// RoundRobin sends requests to each upstream in turn
+	RoundRobin BalanceStrategy = iota
+	// LeastConnections sends requests to the upstream with the fewest requests in flight
+	LeastConnections
+	// ConsistentHash sends the requests with the same key to the same upstream as long as it
+	// is available, only the keys of an upstream going down move to the others
+	ConsistentHash
+)
+
+func (s BalanceStrategy) String() string {
+	switch s {
+	case RoundRobin:
+		return "round_robin"
+	case LeastConnections:
+		return "least_connections"
+	case ConsistentHash:
+		return "consistent_hash"
+	default:
+		return "unknown"
+	}
+}
+
+// UpstreamPoolOptions configures an UpstreamPool
+type UpstreamPoolOptions struct {
+	Strategy BalanceStrategy
+	// HashKey returns the key of a request for ConsistentHash, the client IP when nil
+	HashKey func(req *Request) string
+	// HealthCheckPath is requested on every upstream each HealthCheckInterval, upstreams answering
+	// with an error status or not at all get no requests until they recover. Empty disables active checks.
+	HealthCheckPath     string
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+	// MaxFails is how many requests in a row may fail before the upstream is ejected for EjectDuration,
+	// a request fails when it can't reach the upstream or gets a 502, 503 or 504 from it.
+	// A negative value disables ejection.
+	MaxFails      int
+	EjectDuration time.Duration
+	// Retries is how many other upstreams are tried when an idempotent request can't reach its
+	// upstream, a negative value disables retries
+	Retries int
+}
+
+// DefaultUpstreamPoolOptions are used by NewUpstreamPool for every zero field of the given options
+var DefaultUpstreamPoolOptions = UpstreamPoolOptions{
+	HealthCheckInterval: 10 * time.Second,
+	HealthCheckTimeout:  2 * time.Second,
+	MaxFails:            3,
+	EjectDuration:       30 * time.Second,
+	Retries:             2,
+}
+
+// virtualNodes is how many points each upstream has on the consistent hashing ring,
+// enough for the keys to spread evenly
+const virtualNodes = 160
+
+// UpstreamPool balances requests over several upstreams, skipping the ones failing their
+// health checks or ejected after failing too many requests:
+//
+//	pool, err := http.NewUpstreamPool([]string{"http://10.0.0.12:8080", "http://10.0.0.13:8080"},
+//		http.UpstreamPoolOptions{Strategy: http.LeastConnections, HealthCheckPath: "/health"})
+//	router.Proxy("/legacy", http.NewBalancedProxy(pool, http.ProxyOptions{StripPrefix: "/legacy"}))
+//	router.Get("/admin/upstreams", pool.StatusHandler).Require("admin")
+type UpstreamPool struct {
+	options   UpstreamPoolOptions
+	upstreams []*upstream
+	ring      []hashNode
+	next      atomic.Uint64
+	client    *nethttp.Client
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type upstream struct {
+	url    *url.URL
+	active atomic.Int64
+
+	mu           sync.Mutex
+	healthy      bool
+	fails        int
+	ejectedUntil time.Time
+	requests     uint64
+	failures     uint64
+	lastCheck    time.Time
+	lastError    string
+}
+
+// hashNode is a point of the consistent hashing ring
+type hashNode struct {
+	hash     uint32
+	upstream *upstream
+}
+
+// UpstreamStatus describes an upstream of a pool
+type UpstreamStatus struct {
+	URL                 string     `json:"url"`
+	Healthy             bool       `json:"healthy"`
+	Ejected             bool       `json:"ejected"`
+	EjectedUntil        *time.Time `json:"ejected_until,omitempty"`
+	ActiveRequests      int64      `json:"active_requests"`
+	Requests            uint64     `json:"requests"`
+	Failures            uint64     `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastCheck           *time.Time `json:"last_check,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// NewUpstreamPool creates a pool of the target URLs and starts the health checks,
+// which run until Close is called. Zero durations take the default, negative ones are an error.
+func NewUpstreamPool(targets []string, options UpstreamPoolOptions) (*UpstreamPool, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("proxy: an upstream pool needs at least one target")
+	}
+	if options.Strategy < RoundRobin || options.Strategy > ConsistentHash {
+		return nil, fmt.Errorf("proxy: unknown balance strategy %d", options.Strategy)
+	}
+	if options.HealthCheckInterval == 0 {
+		options.HealthCheckInterval = DefaultUpstreamPoolOptions.HealthCheckInterval
+	}
+	if options.HealthCheckTimeout == 0 {
+		options.HealthCheckTimeout = DefaultUpstreamPoolOptions.HealthCheckTimeout
+	}
+	if options.MaxFails == 0 {
+		options.MaxFails = DefaultUpstreamPoolOptions.MaxFails
+	}
+	if options.EjectDuration == 0 {
+		options.EjectDuration = DefaultUpstreamPoolOptions.EjectDuration
+	}
+	if options.Retries == 0 {
+		options.Retries = DefaultUpstreamPoolOptions.Retries
+	}
+	if options.HealthCheckInterval <= 0 || options.HealthCheckTimeout <= 0 {
+		return nil, errors.New("proxy: the health check interval and timeout must be positive")
+	}
+
+	pool := &UpstreamPool{
+		options: options,
+		client: &nethttp.Client{
+			Timeout: options.HealthCheckTimeout,
+			// A redirect is a valid answer, following it would check another server
+			CheckRedirect: func(req *nethttp.Request, via []*nethttp.Request) error {
+				return nethttp.ErrUseLastResponse
+			},
+		},
+		stop: make(chan struct{}),
+	}
+	for _, target := range targets {
+		targetURL, err := parseProxyTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		added := &upstream{url: targetURL, healthy: true}
+		pool.upstreams = append(pool.upstreams, added)
+		for i := 0; i < virtualNodes; i++ {
+			hash := crc32.ChecksumIEEE([]byte(targetURL.String() + "#" + strconv.Itoa(i)))
+			pool.ring = append(pool.ring, hashNode{hash: hash, upstream: added})
+		}
+	}
+	sort.Slice(pool.ring, func(i, j int) bool {
+		return pool.ring[i].hash < pool.ring[j].hash
+	})
+
+	if options.HealthCheckPath != "" {
+		go pool.healthCheckLoop()
+	}
+	return pool, nil
+}
+
+// Close stops the health checks
+func (p *UpstreamPool) Close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+// Status returns the state of every upstream, in the order they were given
+func (p *UpstreamPool) Status() []UpstreamStatus {
+	now := time.Now()
+	statuses := make([]UpstreamStatus, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		u.mu.Lock()
+		status := UpstreamStatus{
+			URL:                 u.url.String(),
+			Healthy:             u.healthy,
+			Ejected:             now.Before(u.ejectedUntil),
+			ActiveRequests:      u.active.Load(),
+			Requests:            u.requests,
+			Failures:            u.failures,
+			ConsecutiveFailures: u.fails,
+			LastError:           u.lastError,
+		}
+		if status.Ejected {
+			ejectedUntil := u.ejectedUntil
+			status.EjectedUntil = &ejectedUntil
+		}
+		if !u.lastCheck.IsZero() {
+			lastCheck := u.lastCheck
+			status.LastCheck = &lastCheck
+		}
+		u.mu.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// StatusHandler answers with the strategy of the pool and the status of its upstreams as JSON,
+// it is meant for an admin route
+func (p *UpstreamPool) StatusHandler(req *Request, res *Response) {
+	res.JsonResponse(map[string]interface{}{
+		"strategy":  p.options.Strategy.String(),
+		"upstreams": p.Status(),
+	})
+}
+
+// serve forwards the request through proxy to the upstream chosen by the strategy. Idempotent
+// requests whose upstream can't be reached are retried on other upstreams, the others get
+// the error right away since the upstream may have processed them.
+func (p *UpstreamPool) serve(proxy *ReverseProxy, req *Request, res *Response) {
+	attempts := 1
+	if isIdempotent(req.GetMethod()) && p.options.Retries > 0 {
+		attempts += p.options.Retries
+	}
+	tried := make(map[*upstream]bool)
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		chosen := p.choose(req, tried)
+		if chosen == nil {
+			break
+		}
+		tried[chosen] = true
+		release := chosen.acquire()
+		err := proxy.forward(req, res, chosen.url)
+		if err == nil {
+			// A gateway error is relayed as is, it only counts against the upstream
+			if code := res.GetStatusCode(); code == StatusBadGateway || code == StatusServiceUnavailable || code == StatusGatewayTimeout {
+				chosen.failed(fmt.Errorf("upstream answered %d", code.Int()), p.options)
+			} else {
+				chosen.succeeded()
+			}
+			// The response is still being relayed, it is over once the request context is done
+			if req.Context().Done() == nil {
+				release()
+			} else {
+				context.AfterFunc(req.Context(), release)
+			}
+			return
+		}
+		release()
+		lastErr = err
+		if req.Context().Err() != nil {
+			// The client is gone, the upstream isn't to blame
+			break
+		}
+		chosen.failed(err, p.options)
+	}
+	if lastErr == nil {
+		res.ErrorResponse(StatusServiceUnavailable, "no upstream server is available")
+		return
+	}
+	proxyError(res, lastErr)
+}
+
+// choose returns the upstream for the request among the available ones not in exclude, or nil
+func (p *UpstreamPool) choose(req *Request, exclude map[*upstream]bool) *upstream {
+	now := time.Now()
+	usable := func(u *upstream) bool {
+		return !exclude[u] && u.available(now)
+	}
+	switch p.options.Strategy {
+	case LeastConnections:
+		// Starting at the next upstream in turn spreads the requests among the upstreams tied
+		start := p.next.Add(1)
+		var least *upstream
+		for i := range p.upstreams {
+			u := p.upstreams[(start+uint64(i))%uint64(len(p.upstreams))]
+			if usable(u) && (least == nil || u.active.Load() < least.active.Load()) {
+				least = u
+			}
+		}
+		return least
+	case ConsistentHash:
+		hash := crc32.ChecksumIEEE([]byte(p.hashKey(req)))
+		start := sort.Search(len(p.ring), func(i int) bool {
+			return p.ring[i].hash >= hash
+		})
+		for i := range p.ring {
+			if u := p.ring[(start+i)%len(p.ring)].upstream; usable(u) {
+				return u
+			}
+		}
+		return nil
+	default:
+		start := p.next.Add(1) - 1
+		for i := range p.upstreams {
+			if u := p.upstreams[(start+uint64(i))%uint64(len(p.upstreams))]; usable(u) {
+				return u
+			}
+		}
+		return nil
+	}
+}
+
+func (p *UpstreamPool) hashKey(req *Request) string {
+	if p.options.HashKey != nil {
+		return p.options.HashKey(req)
+	}
+	return req.ClientIP()
+}
+
+func (p *UpstreamPool) healthCheckLoop() {
+	ticker := time.NewTicker(p.options.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, u := range p.upstreams {
+			wg.Add(1)
+			go func(u *upstream) {
+				defer wg.Done()
+				p.check(u)
+			}(u)
+		}
+		wg.Wait()
+
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check requests the health check path of an upstream, any status below 400 means it is healthy
+func (p *UpstreamPool) check(u *upstream) {
+	checkURL := u.url.Scheme + "://" + u.url.Host + joinProxyPath(u.url.EscapedPath(), p.options.HealthCheckPath)
+	response, err := p.client.Get(checkURL)
+	if err == nil {
+		io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+		response.Body.Close()
+		if response.StatusCode >= 400 {
+			err = fmt.Errorf("health check answered %d", response.StatusCode)
+		}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	healthy := err == nil
+	if healthy != u.healthy {
+		if healthy {
+			log.Printf("Upstream %s is healthy again", u.url)
+		} else {
+			log.Printf("Upstream %s is unhealthy: %v", u.url, err)
+		}
+	}
+	u.healthy = healthy
+	u.lastCheck = time.Now()
+	if err != nil {
+		u.lastError = err.Error()
+	}
+}
+
+func (u *upstream) available(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.healthy && !now.Before(u.ejectedUntil)
+}
+
+// acquire counts a request in flight, the returned function must be called once it is over
+func (u *upstream) acquire() func() {
+	u.active.Add(1)
+	u.mu.Lock()
+	u.requests++
+	u.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			u.active.Add(-1)
+		})
+	}
+}
+
+func (u *upstream) succeeded() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails = 0
+}
+
+// failed records a request that couldn't reach the upstream or got a gateway error from it,
+// the upstream is ejected once too many failed in a row
+func (u *upstream) failed(err error, options UpstreamPoolOptions) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.failures++
+	u.fails++
+	u.lastError = err.Error()
+	if options.MaxFails > 0 && u.fails >= options.MaxFails {
+		u.ejectedUntil = time.Now().Add(options.EjectDuration)
+		u.fails = 0
+		log.Printf("Upstream %s ejected for %v after %d failed requests", u.url, options.EjectDuration, options.MaxFails)
+	}
+}
+
+// isIdempotent reports whether a request can be sent again without changing its effect, RFC 9110 section 9.2.2
+func isIdempotent(method Method) bool {
+	switch method {
+	case GET, HEAD, OPTIONS, PUT, DELETE:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,197 @@
+package http
+
+import (
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// namedUpstream starts an upstream answering with its name and the given status
+func namedUpstream(t *testing.T, name string, status int) string {
+	t.Helper()
+	upstream := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream.URL
+}
+
+// closedAddress returns the URL of a local port nothing listens on
+func closedAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	return "http://" + listener.Addr().String()
+}
+
+// poolTestServer serves a proxy balancing over targets and returns the base URL and the pool
+func poolTestServer(t *testing.T, targets []string, options UpstreamPoolOptions) (string, *UpstreamPool) {
+	t.Helper()
+	pool, err := NewUpstreamPool(targets, options)
+	if err != nil {
+		t.Fatalf("NewUpstreamPool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	router := NewRouter()
+	router.Proxy("/", NewBalancedProxy(pool, ProxyOptions{DialTimeout: time.Second}))
+	return StartTestServer(t, router), pool
+}
+
+func poolGet(t *testing.T, baseURL string, headers string) (int, string) {
+	t.Helper()
+	response, body := sendRawRequest(t, baseURL, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n"+headers+"\r\n")
+	return response.StatusCode, body
+}
+
+func TestUpstreamPoolRoundRobin(t *testing.T) {
+	baseURL, _ := poolTestServer(t, []string{
+		namedUpstream(t, "a", 200), namedUpstream(t, "b", 200), namedUpstream(t, "c", 200),
+	}, UpstreamPoolOptions{})
+
+	counts := make(map[string]int)
+	for i := 0; i < 9; i++ {
+		_, body := poolGet(t, baseURL, "")
+		counts[body]++
+	}
+	if counts["a"] != 3 || counts["b"] != 3 || counts["c"] != 3 {
+		t.Errorf("requests per upstream = %v, want 3 each", counts)
+	}
+}
+
+func TestUpstreamPoolRetriesAndEjects(t *testing.T) {
+	down := closedAddress(t)
+	baseURL, pool := poolTestServer(t, []string{down, namedUpstream(t, "up", 200)}, UpstreamPoolOptions{MaxFails: 2})
+
+	for i := 0; i < 4; i++ {
+		if status, body := poolGet(t, baseURL, ""); status != 200 || body != "up" {
+			t.Fatalf("request %d: status = %d, body = %q", i+1, status, body)
+		}
+	}
+	status := pool.Status()
+	if !status[0].Ejected || status[0].Failures != 2 || status[1].Requests != 4 {
+		t.Errorf("status = %+v", status)
+	}
+
+	// POST isn't idempotent, it isn't retried on another upstream
+	baseURL, _ = poolTestServer(t, []string{down, namedUpstream(t, "up", 200)}, UpstreamPoolOptions{})
+	response, _ := sendRawRequest(t, baseURL, "POST / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")
+	if response.StatusCode != 502 {
+		t.Errorf("POST to a down upstream: status = %d, want 502", response.StatusCode)
+	}
+}
+
+func TestUpstreamPoolCountsGatewayErrors(t *testing.T) {
+	baseURL, pool := poolTestServer(t, []string{namedUpstream(t, "overloaded", 503)}, UpstreamPoolOptions{MaxFails: 2})
+
+	for i := 0; i < 2; i++ {
+		if status, body := poolGet(t, baseURL, ""); status != 503 || body != "overloaded" {
+			t.Fatalf("request %d: status = %d, body = %q, want the relayed 503", i+1, status, body)
+		}
+	}
+	if status := pool.Status()[0]; !status.Ejected || status.Failures != 2 {
+		t.Errorf("status = %+v, want ejected after 2 failures", status)
+	}
+	if status, _ := poolGet(t, baseURL, ""); status != 503 {
+		t.Errorf("status = %d with every upstream ejected, want 503", status)
+	}
+}
+
+func TestUpstreamPoolConsistentHash(t *testing.T) {
+	targets := []string{namedUpstream(t, "a", 200), namedUpstream(t, "b", 200), namedUpstream(t, "c", 200)}
+	baseURL, pool := poolTestServer(t, targets, UpstreamPoolOptions{
+		Strategy: ConsistentHash,
+		HashKey:  func(req *Request) string { return req.GetHeader("X-User") },
+	})
+
+	chosen := make(map[string]string)
+	for _, user := range []string{"alice", "bob", "carol", "dave", "erin", "frank"} {
+		_, first := poolGet(t, baseURL, "X-User: "+user+"\r\n")
+		for i := 0; i < 3; i++ {
+			if _, body := poolGet(t, baseURL, "X-User: "+user+"\r\n"); body != first {
+				t.Fatalf("%s sent to %q then %q", user, first, body)
+			}
+		}
+		chosen[user] = first
+	}
+
+	// Only the keys of an unavailable upstream move
+	var down *upstream
+	for _, u := range pool.upstreams {
+		if "http://"+u.url.Host == targets[0] {
+			down = u
+		}
+	}
+	down.mu.Lock()
+	down.healthy = false
+	down.mu.Unlock()
+	for user, before := range chosen {
+		_, after := poolGet(t, baseURL, "X-User: "+user+"\r\n")
+		if before != "a" && after != before {
+			t.Errorf("%s moved from %q to %q", user, before, after)
+		}
+		if after == "a" {
+			t.Errorf("%s sent to the unhealthy upstream", user)
+		}
+	}
+}
+
+func TestUpstreamPoolHealthChecks(t *testing.T) {
+	var healthy atomic.Bool
+	upstream := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Path == "/base/health" && !healthy.Load() {
+			w.WriteHeader(nethttp.StatusInternalServerError)
+		}
+	}))
+	defer upstream.Close()
+	pool, err := NewUpstreamPool([]string{upstream.URL + "/base"}, UpstreamPoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	// Set once the pool is created, the checks are run here instead of by the loop
+	pool.options.HealthCheckPath = "/health"
+
+	pool.check(pool.upstreams[0])
+	if status := pool.Status()[0]; status.Healthy || status.LastError != "health check answered 500" {
+		t.Errorf("status = %+v, want unhealthy", status)
+	}
+	healthy.Store(true)
+	pool.check(pool.upstreams[0])
+	if status := pool.Status()[0]; !status.Healthy || status.LastCheck == nil {
+		t.Errorf("status = %+v, want healthy again", status)
+	}
+}
+
+func TestNewUpstreamPoolErrors(t *testing.T) {
+	if _, err := NewUpstreamPool(nil, UpstreamPoolOptions{}); err == nil {
+		t.Error("pool without targets created")
+	}
+	if _, err := NewUpstreamPool([]string{"ftp://example.com"}, UpstreamPoolOptions{}); err == nil {
+		t.Error("pool with an ftp target created")
+	}
+	if _, err := NewUpstreamPool([]string{"http://example.com"}, UpstreamPoolOptions{Strategy: BalanceStrategy(7)}); err == nil {
+		t.Error("pool with an unknown strategy created")
+	}
+	for _, options := range []UpstreamPoolOptions{
+		{HealthCheckPath: "/health", HealthCheckInterval: -time.Second},
+		{HealthCheckTimeout: -time.Second},
+	} {
+		if _, err := NewUpstreamPool([]string{"http://example.com"}, options); err == nil {
+			t.Errorf("pool with %+v created", options)
+		}
+	}
+	if got := BalanceStrategy(7).String(); got != "unknown" {
+		t.Errorf("BalanceStrategy(7).String() = %q", got)
+	}
+	if got := ConsistentHash.String(); got != "consistent_hash" {
+		t.Errorf("ConsistentHash.String() = %q", got)
+	}
+}
@@ -0,0 +1,582 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// websocketGUID is appended to Sec-WebSocket-Key to compute Sec-WebSocket-Accept, RFC 6455 section 1.3
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MessageType is the opcode of a WebSocket frame
+type MessageType int
+
+const (
+	continuationFrame MessageType = 0
+	TextMessage       MessageType = 1
+	BinaryMessage     MessageType = 2
+	CloseMessage      MessageType = 8
+	PingMessage       MessageType = 9
+	PongMessage       MessageType = 10
+)
+
+// WebSocket close codes, RFC 6455 section 7.4.1
+const (
+	CloseNormalClosure       = 1000
+	CloseGoingAway           = 1001
+	CloseProtocolError       = 1002
+	CloseUnsupportedData     = 1003
+	CloseNoStatusReceived    = 1005
+	CloseInvalidPayload      = 1007
+	ClosePolicyViolation     = 1008
+	CloseMessageTooBig       = 1009
+	CloseInternalServerError = 1011
+
+	// closeAbnormalClosure is reported when the connection drops without a close frame
+	closeAbnormalClosure    = 1006
+	maxControlPayloadLength = 125
+)
+
+// CloseError is returned by ReadMessage once the connection is closed, Code is the close
+// code sent by the client, or the one this side closed with after a protocol violation
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed with code %d: %s", e.Code, e.Reason)
+}
+
+// ErrWebSocketClosed is returned when writing on a connection that was closed
+var ErrWebSocketClosed = errors.New("websocket: connection closed")
+
+// WebSocketOptions configures the WebSocket upgrade
+type WebSocketOptions struct {
+	// Subprotocols the server speaks, in order of preference
+	Subprotocols []string
+	// MaxMessageSize caps the size of a message once reassembled and decompressed, in bytes
+	MaxMessageSize int64
+	// Compression negotiates the permessage-deflate extension when the client offers it
+	Compression bool
+	// CompressionThreshold is the smallest message worth compressing, in bytes
+	CompressionThreshold int
+	// CheckOrigin accepts or rejects the Origin of the upgrade request, by default
+	// browsers may only connect from the same host
+	CheckOrigin func(req *Request) bool
+}
+
+// DefaultWebSocketOptions are used by Router.WebSocket
+var DefaultWebSocketOptions = WebSocketOptions{
+	MaxMessageSize:       1 << 20,
+	Compression:          true,
+	CompressionThreshold: 256,
+}
+
+// WebSocketConn is an upgraded WebSocket connection. ReadMessage must be called from a
+// single goroutine, writes can happen from any goroutine.
+type WebSocketConn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	request     *Request
+	subprotocol string
+	options     WebSocketOptions
+	compress    bool
+
+	writeMu    sync.Mutex
+	closeSent  bool
+	closed     chan struct{}
+	closedOnce sync.Once
+}
+
+// WebSocket registers handler for upgrade requests on path with DefaultWebSocketOptions,
+// the route middlewares run before the upgrade so they can authenticate or reject the client
+func (r *Router) WebSocket(path string, handler func(conn *WebSocketConn)) *Route {
+	return r.WebSocketWithOptions(path, DefaultWebSocketOptions, handler)
+}
+
+// WebSocketWithOptions registers handler for upgrade requests on path
+func (r *Router) WebSocketWithOptions(path string, options WebSocketOptions, handler func(conn *WebSocketConn)) *Route {
+	return r.Get(path, func(req *Request, res *Response) {
+		conn, err := UpgradeWebSocket(req, res, options)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handler(conn)
+	})
+}
+
+// UpgradeWebSocket performs the opening handshake of RFC 6455 and takes the connection over.
+// When the request isn't a valid upgrade the error response is set and an error returned.
+func UpgradeWebSocket(req *Request, res *Response, options WebSocketOptions) (*WebSocketConn, error) {
+	if options.MaxMessageSize == 0 {
+		options.MaxMessageSize = DefaultWebSocketOptions.MaxMessageSize
+	}
+	if req.GetMethod() != GET || !headerContainsToken(req.GetHeader("Upgrade"), "websocket") ||
+		!headerContainsToken(req.GetHeader("Connection"), "upgrade") {
+		res.SetHeader("Upgrade", "websocket")
+		res.ErrorResponse(StatusUpgradeRequired, "this endpoint only accepts WebSocket connections")
+		return nil, errors.New("websocket: not an upgrade request")
+	}
+	if req.GetHeader("Sec-WebSocket-Version") != "13" {
+		res.SetHeader("Sec-WebSocket-Version", "13")
+		res.ErrorResponse(StatusUpgradeRequired, "unsupported WebSocket version")
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := req.GetHeader("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		res.ErrorResponse(StatusBadRequest, "invalid Sec-WebSocket-Key")
+		return nil, errors.New("websocket: invalid key")
+	}
+	checkOrigin := options.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameHostOrigin
+	}
+	if !checkOrigin(req) {
+		res.ErrorResponse(StatusForbidden, "origin not allowed")
+		return nil, errors.New("websocket: origin not allowed")
+	}
+
+	ws := &WebSocketConn{request: req, options: options, closed: make(chan struct{})}
+	for _, offered := range splitTokens(req.GetHeader("Sec-WebSocket-Protocol")) {
+		if ws.subprotocol == "" && containsToken(options.Subprotocols, offered) {
+			ws.subprotocol = offered
+		}
+	}
+	ws.compress = options.Compression && acceptsPerMessageDeflate(req.GetHeader("Sec-WebSocket-Extensions"))
+
+	res.SetStatusCode(StatusSwitchingProtocols)
+	res.SetHeader("Upgrade", "websocket")
+	res.SetHeader("Connection", "Upgrade")
+	res.SetHeader("Sec-WebSocket-Accept", websocketAccept(key))
+	if ws.subprotocol != "" {
+		res.SetHeader("Sec-WebSocket-Protocol", ws.subprotocol)
+	}
+	if ws.compress {
+		// Every message is compressed on its own, so neither side keeps a window between messages
+		res.SetHeader("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	}
+	res.DeleteHeader("Content-Length")
+	res.DeleteHeader("Content-Type")
+	res.body = ""
+	res.stream = nil
+
+	conn, reader, err := res.Hijack()
+	if err != nil {
+		res.ErrorResponse(StatusInternalServerError, "WebSocket connections need a connection")
+		return nil, err
+	}
+	res.runBeforeWrite()
+	if _, err := io.WriteString(conn, res.head()); err != nil {
+		return nil, err
+	}
+	ws.conn, ws.reader = conn, reader
+
+	// The server closes the connection with "going away" when it shuts down
+	go func() {
+		select {
+		case <-req.Context().Done():
+			ws.CloseWithReason(CloseGoingAway, "server shutting down")
+		case <-ws.closed:
+		}
+	}()
+	return ws, nil
+}
+
+// Request returns the upgrade request, with the context set by the middlewares
+func (c *WebSocketConn) Request() *Request {
+	return c.request
+}
+
+// Subprotocol returns the negotiated subprotocol, or an empty string
+func (c *WebSocketConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the network address of the client
+func (c *WebSocketConn) RemoteAddr() string {
+	return c.conn.RemoteAddr().String()
+}
+
+// SetReadDeadline sets the time after which ReadMessage fails, a zero time means no deadline
+func (c *WebSocketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the time after which writes fail, a zero time means no deadline
+func (c *WebSocketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// Done is closed once the connection is closed
+func (c *WebSocketConn) Done() <-chan struct{} {
+	return c.closed
+}
+
+// ReadMessage returns the next text or binary message, reassembled from its fragments and
+// decompressed. Pings are answered while waiting. A *CloseError is returned once the client
+// closes the connection or breaks the protocol, the connection is then closed.
+func (c *WebSocketConn) ReadMessage() (MessageType, []byte, error) {
+	var messageType MessageType
+	var message []byte
+	compressed := false
+	for {
+		frame, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frame.opcode {
+		case PingMessage:
+			if err := c.writeFrame(PongMessage, frame.payload, false); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(frame.payload)
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+			if frame.rsv1 {
+				return 0, nil, c.fail(CloseProtocolError, "compressed continuation frame")
+			}
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "new message before the previous one ended")
+			}
+			messageType = frame.opcode
+			compressed = frame.rsv1
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(message)+len(frame.payload)) > c.options.MaxMessageSize {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		message = append(message, frame.payload...)
+		if frame.fin {
+			break
+		}
+	}
+
+	if compressed {
+		inflated, err := inflateMessage(message, c.options.MaxMessageSize)
+		if err != nil {
+			return 0, nil, c.fail(CloseMessageTooBig, err.Error())
+		}
+		message = inflated
+	}
+	if messageType == TextMessage && !utf8.Valid(message) {
+		return 0, nil, c.fail(CloseInvalidPayload, "text message is not valid UTF-8")
+	}
+	return messageType, message, nil
+}
+
+// WriteMessage sends a text or binary message in a single frame, compressed when the
+// extension was negotiated and the message is large enough
+func (c *WebSocketConn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return errors.New("websocket: only text and binary messages can be written")
+	}
+	if c.compress && len(data) >= c.options.CompressionThreshold {
+		deflated, err := deflateMessage(data)
+		if err != nil {
+			return err
+		}
+		return c.writeFrame(messageType, deflated, true)
+	}
+	return c.writeFrame(messageType, data, false)
+}
+
+// Ping sends a ping, the client answers with a pong
+func (c *WebSocketConn) Ping(data []byte) error {
+	if len(data) > maxControlPayloadLength {
+		return errors.New("websocket: control frame payload too long")
+	}
+	return c.writeFrame(PingMessage, data, false)
+}
+
+// Close closes the connection normally
+func (c *WebSocketConn) Close() error {
+	return c.CloseWithReason(CloseNormalClosure, "")
+}
+
+// CloseWithReason sends a close frame with code and reason, then closes the connection.
+// Reasons too long for a control frame are cut on a character boundary.
+func (c *WebSocketConn) CloseWithReason(code int, reason string) error {
+	if limit := maxControlPayloadLength - 2; len(reason) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(reason[cut]) {
+			cut--
+		}
+		reason = reason[:cut]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	err := c.writeFrame(CloseMessage, payload, false)
+	c.closeConn()
+	if errors.Is(err, ErrWebSocketClosed) {
+		return nil
+	}
+	return err
+}
+
+func (c *WebSocketConn) closeConn() {
+	c.closedOnce.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+// handleClose answers the close frame of the client with the same code and closes the connection
+func (c *WebSocketConn) handleClose(payload []byte) error {
+	code, reason := CloseNoStatusReceived, ""
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "invalid close frame")
+	case len(payload) >= 2:
+		code = int(binary.BigEndian.Uint16(payload))
+		reason = string(payload[2:])
+		if !validCloseCode(code) {
+			return c.fail(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(reason) {
+			return c.fail(CloseInvalidPayload, "close reason is not valid UTF-8")
+		}
+	}
+	var reply []byte
+	if code != CloseNoStatusReceived {
+		reply = payload[:2]
+	}
+	c.writeFrame(CloseMessage, reply, false)
+	c.closeConn()
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// fail closes the connection after a protocol violation of the client
+func (c *WebSocketConn) fail(code int, reason string) error {
+	c.CloseWithReason(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
+
+type websocketFrame struct {
+	fin     bool
+	rsv1    bool
+	opcode  MessageType
+	payload []byte
+}
+
+// readFrame reads and unmasks one frame, RFC 6455 section 5.2
+func (c *WebSocketConn) readFrame() (*websocketFrame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		c.closeConn()
+		return nil, &CloseError{Code: closeAbnormalClosure, Reason: err.Error()}
+	}
+	frame := &websocketFrame{
+		fin:    header[0]&0x80 != 0,
+		rsv1:   header[0]&0x40 != 0,
+		opcode: MessageType(header[0] & 0x0f),
+	}
+	if header[0]&0x30 != 0 {
+		return nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if frame.rsv1 && (!c.compress || frame.opcode >= CloseMessage) {
+		return nil, c.fail(CloseProtocolError, "unexpected compressed frame")
+	}
+	if header[1]&0x80 == 0 {
+		return nil, c.fail(CloseProtocolError, "client frames must be masked")
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return nil, c.fail(CloseProtocolError, "truncated frame")
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return nil, c.fail(CloseProtocolError, "truncated frame")
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if frame.opcode >= CloseMessage && (!frame.fin || length > maxControlPayloadLength) {
+		return nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if length > uint64(c.options.MaxMessageSize) {
+		return nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return nil, c.fail(CloseProtocolError, "truncated frame")
+	}
+	frame.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, frame.payload); err != nil {
+		return nil, c.fail(CloseProtocolError, "truncated frame")
+	}
+	for i := range frame.payload {
+		frame.payload[i] ^= mask[i%4]
+	}
+	return frame, nil
+}
+
+// writeFrame sends an unmasked frame, nothing is written once a close frame was sent
+func (c *WebSocketConn) writeFrame(opcode MessageType, payload []byte, compressed bool) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrWebSocketClosed
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | byte(opcode)
+	if compressed {
+		header[0] |= 0x40
+	}
+	switch length := len(payload); {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return ErrWebSocketClosed
+	}
+	return nil
+}
+
+// deflateMessage compresses a message for permessage-deflate, RFC 7692 section 7.2.1
+func deflateMessage(data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	writer, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(compressed.Bytes(), []byte{0x00, 0x00, 0xff, 0xff}), nil
+}
+
+// inflateMessage decompresses a permessage-deflate message, up to limit bytes
+func inflateMessage(data []byte, limit int64) ([]byte, error) {
+	reader := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader([]byte{0x00, 0x00, 0xff, 0xff})))
+	defer reader.Close()
+	inflated, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	if int64(len(inflated)) > limit {
+		return nil, errors.New("message too big")
+	}
+	return inflated, nil
+}
+
+// acceptsPerMessageDeflate reports whether the client offers permessage-deflate with
+// parameters this server can honour, it can't shrink its window below 32KB
+func acceptsPerMessageDeflate(header string) bool {
+	for _, offer := range strings.Split(header, ",") {
+		params := strings.Split(offer, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "permessage-deflate") {
+			continue
+		}
+		acceptable := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "server_max_window_bits") && strings.Trim(value, `"`) != "15" {
+				acceptable = false
+			}
+		}
+		if acceptable {
+			return true
+		}
+	}
+	return false
+}
+
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code >= 3000 && code <= 4999:
+		return true
+	default:
+		return false
+	}
+}
+
+// sameHostOrigin accepts clients that don't send an Origin, which aren't browsers,
+// and browsers on a page of the same host
+func sameHostOrigin(req *Request) bool {
+	origin := req.GetHeader("Origin")
+	if origin == "" {
+		return true
+	}
+	_, host, found := strings.Cut(origin, "://")
+	return found && strings.EqualFold(host, req.Host())
+}
+
+func splitTokens(header string) []string {
+	tokens := make([]string, 0)
+	for _, token := range strings.Split(header, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+func headerContainsToken(header string, token string) bool {
+	for _, candidate := range splitTokens(header) {
+		if strings.EqualFold(candidate, token) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsToken(tokens []string, token string) bool {
+	for _, candidate := range tokens {
+		if candidate == token {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,315 @@
+package http
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	nethttp "net/http"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestWebSocketAccept(t *testing.T) {
+	// Example of RFC 6455 section 1.3
+	if got := websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("websocketAccept = %q", got)
+	}
+}
+
+// dialWebSocket sends an upgrade request for path with the given extra header lines
+func dialWebSocket(t *testing.T, baseURL string, path string, headers ...string) (net.Conn, *bufio.Reader, *nethttp.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(baseURL, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	request := "GET " + path + " HTTP/1.1\r\nHost: " + strings.TrimPrefix(baseURL, "http://") + "\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	for _, header := range headers {
+		request += header + "\r\n"
+	}
+	if !strings.Contains(request, "Sec-WebSocket-Version") {
+		request += "Sec-WebSocket-Version: 13\r\n"
+	}
+	if _, err := io.WriteString(conn, request+"\r\n"); err != nil {
+		t.Fatalf("writing handshake: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := nethttp.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("reading handshake: %v", err)
+	}
+	return conn, reader, response
+}
+
+// writeClientFrame sends a frame as a client, masked unless a test breaks the protocol on purpose
+func writeClientFrame(t *testing.T, conn net.Conn, fin bool, rsv1 bool, opcode MessageType, payload []byte, masked bool) {
+	t.Helper()
+	first := byte(opcode)
+	if fin {
+		first |= 0x80
+	}
+	if rsv1 {
+		first |= 0x40
+	}
+	frame := []byte{first}
+	maskBit := byte(0)
+	if masked {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	data := append([]byte(nil), payload...)
+	if masked {
+		mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+		frame = append(frame, mask...)
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	if _, err := conn.Write(append(frame, data...)); err != nil {
+		t.Fatalf("writing frame: %v", err)
+	}
+}
+
+// readServerFrame reads an unmasked frame sent by the server
+func readServerFrame(t *testing.T, reader *bufio.Reader) (websocketFrame, error) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return websocketFrame{}, err
+	}
+	if header[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		io.ReadFull(reader, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		io.ReadFull(reader, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return websocketFrame{}, err
+	}
+	return websocketFrame{
+		fin:     header[0]&0x80 != 0,
+		rsv1:    header[0]&0x40 != 0,
+		opcode:  MessageType(header[0] & 0x0f),
+		payload: payload,
+	}, nil
+}
+
+// echoWebSocketServer echoes every message and reports how the first connection ended on closed
+func echoWebSocketServer(t *testing.T, options WebSocketOptions) (string, chan error) {
+	t.Helper()
+	closed := make(chan error, 1)
+	report := func(err error) {
+		select {
+		case closed <- err:
+		default:
+		}
+	}
+	router := NewRouter()
+	router.WebSocketWithOptions("/ws", options, func(conn *WebSocketConn) {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				report(err)
+				return
+			}
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				report(err)
+				return
+			}
+		}
+	})
+	return StartTestServer(t, router), closed
+}
+
+func expectCloseCode(t *testing.T, reader *bufio.Reader, code int) {
+	t.Helper()
+	frame, err := readServerFrame(t, reader)
+	if err != nil {
+		t.Fatalf("reading close frame: %v", err)
+	}
+	if frame.opcode != CloseMessage || len(frame.payload) < 2 {
+		t.Fatalf("got opcode %d with %q, want a close frame", frame.opcode, frame.payload)
+	}
+	if got := int(binary.BigEndian.Uint16(frame.payload)); got != code {
+		t.Errorf("close code = %d (%s), want %d", got, frame.payload[2:], code)
+	}
+}
+
+func TestWebSocketHandshake(t *testing.T) {
+	baseURL, _ := echoWebSocketServer(t, WebSocketOptions{Subprotocols: []string{"chat.v2", "chat.v1"}})
+
+	_, _, response := dialWebSocket(t, baseURL, "/ws", "Sec-WebSocket-Protocol: chat.v1, chat.v2")
+	if response.StatusCode != 101 {
+		t.Fatalf("status = %d, want 101", response.StatusCode)
+	}
+	if got := response.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+	if got := response.Header.Get("Sec-WebSocket-Protocol"); got != "chat.v1" {
+		t.Errorf("Sec-WebSocket-Protocol = %q, want the first offer the server speaks", got)
+	}
+
+	_, _, response = dialWebSocket(t, baseURL, "/ws", "Sec-WebSocket-Version: 8")
+	if response.StatusCode != 426 || response.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("old version: status = %d, Sec-WebSocket-Version = %q", response.StatusCode, response.Header.Get("Sec-WebSocket-Version"))
+	}
+	_, _, response = dialWebSocket(t, baseURL, "/ws", "Origin: https://evil.example")
+	if response.StatusCode != 403 {
+		t.Errorf("foreign origin: status = %d, want 403", response.StatusCode)
+	}
+	host := strings.TrimPrefix(baseURL, "http://")
+	_, _, response = dialWebSocket(t, baseURL, "/ws", "Origin: http://"+host)
+	if response.StatusCode != 101 {
+		t.Errorf("same origin: status = %d, want 101", response.StatusCode)
+	}
+}
+
+func TestWebSocketMessages(t *testing.T) {
+	baseURL, closed := echoWebSocketServer(t, WebSocketOptions{})
+	conn, reader, _ := dialWebSocket(t, baseURL, "/ws")
+
+	writeClientFrame(t, conn, true, false, TextMessage, []byte("hello"), true)
+	frame, err := readServerFrame(t, reader)
+	if err != nil || frame.opcode != TextMessage || string(frame.payload) != "hello" {
+		t.Fatalf("echo = %d %q, %v", frame.opcode, frame.payload, err)
+	}
+
+	// A fragmented message with a ping in between, the ping is answered first
+	writeClientFrame(t, conn, false, false, BinaryMessage, []byte("frag"), true)
+	writeClientFrame(t, conn, true, false, PingMessage, []byte("are you there"), true)
+	writeClientFrame(t, conn, true, false, continuationFrame, []byte("mented"), true)
+	frame, _ = readServerFrame(t, reader)
+	if frame.opcode != PongMessage || string(frame.payload) != "are you there" {
+		t.Fatalf("got %d %q, want the pong", frame.opcode, frame.payload)
+	}
+	frame, _ = readServerFrame(t, reader)
+	if frame.opcode != BinaryMessage || string(frame.payload) != "fragmented" {
+		t.Fatalf("got %d %q, want the reassembled message", frame.opcode, frame.payload)
+	}
+
+	large := strings.Repeat("x", 70000)
+	writeClientFrame(t, conn, true, false, TextMessage, []byte(large), true)
+	if frame, _ = readServerFrame(t, reader); string(frame.payload) != large {
+		t.Fatalf("large echo of %d bytes", len(frame.payload))
+	}
+
+	writeClientFrame(t, conn, true, false, CloseMessage, append([]byte{0x03, 0xe8}, "bye"...), true)
+	expectCloseCode(t, reader, CloseNormalClosure)
+	var closeErr *CloseError
+	if err := <-closed; !errors.As(err, &closeErr) || closeErr.Code != CloseNormalClosure || closeErr.Reason != "bye" {
+		t.Errorf("handler got %v", err)
+	}
+}
+
+func TestWebSocketProtocolViolations(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(conn net.Conn)
+		code int
+	}{
+		{"unmasked frame", func(conn net.Conn) {
+			writeClientFrame(t, conn, true, false, TextMessage, []byte("hi"), false)
+		}, CloseProtocolError},
+		{"invalid UTF-8", func(conn net.Conn) {
+			writeClientFrame(t, conn, true, false, TextMessage, []byte{0xff, 0xfe}, true)
+		}, CloseInvalidPayload},
+		{"continuation without message", func(conn net.Conn) {
+			writeClientFrame(t, conn, true, false, continuationFrame, []byte("x"), true)
+		}, CloseProtocolError},
+		{"fragmented ping", func(conn net.Conn) {
+			writeClientFrame(t, conn, false, false, PingMessage, []byte("x"), true)
+		}, CloseProtocolError},
+		{"compressed without extension", func(conn net.Conn) {
+			writeClientFrame(t, conn, true, true, TextMessage, []byte("x"), true)
+		}, CloseProtocolError},
+		{"message too big", func(conn net.Conn) {
+			writeClientFrame(t, conn, true, false, BinaryMessage, make([]byte, 2048), true)
+		}, CloseMessageTooBig},
+		{"invalid close code", func(conn net.Conn) {
+			writeClientFrame(t, conn, true, false, CloseMessage, []byte{0x03, 0xed}, true)
+		}, CloseProtocolError},
+	}
+	baseURL, _ := echoWebSocketServer(t, WebSocketOptions{MaxMessageSize: 1024})
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, reader, _ := dialWebSocket(t, baseURL, "/ws")
+			test.send(conn)
+			expectCloseCode(t, reader, test.code)
+		})
+	}
+}
+
+func TestWebSocketPerMessageDeflate(t *testing.T) {
+	baseURL, _ := echoWebSocketServer(t, WebSocketOptions{Compression: true, CompressionThreshold: 16})
+	conn, reader, response := dialWebSocket(t, baseURL, "/ws", "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits")
+	if !strings.HasPrefix(response.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		t.Fatalf("Sec-WebSocket-Extensions = %q", response.Header.Get("Sec-WebSocket-Extensions"))
+	}
+
+	message := strings.Repeat("compress me ", 50)
+	deflated, err := deflateMessage([]byte(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeClientFrame(t, conn, true, true, TextMessage, deflated, true)
+	frame, err := readServerFrame(t, reader)
+	if err != nil || !frame.rsv1 {
+		t.Fatalf("echo rsv1 = %v, %v, want a compressed frame", frame.rsv1, err)
+	}
+	inflated, err := inflateMessage(frame.payload, 1<<20)
+	if err != nil || string(inflated) != message {
+		t.Errorf("inflated echo = %q, %v", inflated, err)
+	}
+
+	writeClientFrame(t, conn, true, false, TextMessage, []byte("short"), true)
+	if frame, _ := readServerFrame(t, reader); frame.rsv1 || string(frame.payload) != "short" {
+		t.Errorf("message under the threshold: rsv1 = %v, payload = %q", frame.rsv1, frame.payload)
+	}
+}
+
+func TestWebSocketCloseReasonKeepsUTF8(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	ws := &WebSocketConn{conn: server, closed: make(chan struct{})}
+	reader := bufio.NewReader(client)
+	frames := make(chan websocketFrame, 1)
+	go func() {
+		frame, _ := readServerFrame(t, reader)
+		frames <- frame
+	}()
+
+	// 2 bytes of code and 61 two-byte characters don't fit in 125 bytes
+	ws.CloseWithReason(CloseGoingAway, strings.Repeat("é", 61))
+	frame := <-frames
+	if len(frame.payload) > maxControlPayloadLength {
+		t.Fatalf("close payload of %d bytes", len(frame.payload))
+	}
+	if reason := frame.payload[2:]; !utf8.Valid(reason) || len(reason) != 122 {
+		t.Errorf("reason of %d bytes, valid UTF-8 = %v", len(reason), utf8.Valid(reason))
+	}
+}
@@ -12,6 +12,7 @@ func main() {
 	app := app.Application()
 
 	app.Add(routes.HomeRoutes())
+	app.Add(routes.UpstreamRoutes())
 
 	app.Get("/about", AboutController.Index)
 	app.Get("/contact", ContactController.Index)
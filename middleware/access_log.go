@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"fmt"
+	"http-server/app/http"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat selects how AccessLog writes its records
+type AccessLogFormat int
+
+const (
+	// AccessLogJSON writes one JSON object per request
+	AccessLogJSON AccessLogFormat = iota
+	// AccessLogLogfmt writes key=value pairs
+	AccessLogLogfmt
+	// AccessLogCommon writes the Common Log Format of Apache
+	AccessLogCommon
+	// AccessLogCombined writes the Common Log Format followed by the referrer and the user agent
+	AccessLogCombined
+)
+
+// AccessLogOptions configures AccessLog
+type AccessLogOptions struct {
+	Format AccessLogFormat
+	// Output receives the records, os.Stdout when nil
+	Output io.Writer
+	// Logger receives the records of the JSON and logfmt formats instead of a logger writing to Output,
+	// so they go through the handler the application already set up. The Common and Combined
+	// formats are plain lines that always go to Output, Logger is ignored for them.
+	Logger *slog.Logger
+	// Sample enables SampleRate, every request is logged otherwise
+	Sample bool
+	// SampleRate is the fraction of the successful requests that are logged when Sample is set,
+	// between 0 and 1, so 0 only logs the requests answered with a 4xx or 5xx status, which are always logged
+	SampleRate float64
+	// SkipPaths are never logged, along with the paths below them, like "/health"
+	SkipPaths []string
+}
+
+// DefaultAccessLogOptions are used by AccessLog for every zero field of the given options
+var DefaultAccessLogOptions = AccessLogOptions{
+	Format: AccessLogJSON,
+	Output: os.Stdout,
+}
+
+// AccessLog logs every request once its response is sent, with its status, size and duration.
+// It has to be the first global pre-middleware: the requests rejected by the middlewares running
+// before it wouldn't be logged.
+func AccessLog(options AccessLogOptions) http.MiddlewareFunc {
+	if options.Output == nil {
+		options.Output = DefaultAccessLogOptions.Output
+	}
+	logger := options.Logger
+	if logger == nil && options.Format == AccessLogLogfmt {
+		logger = slog.New(slog.NewTextHandler(options.Output, nil))
+	} else if logger == nil {
+		logger = slog.New(slog.NewJSONHandler(options.Output, nil))
+	}
+	var mu sync.Mutex
+
+	return func(req *http.Request, res *http.Response, next func()) {
+		if skipAccessLog(req.GetPath(), options.SkipPaths) {
+			next()
+			return
+		}
+		start := time.Now()
+		res.AfterWrite(func() {
+			status := res.GetStatusCode()
+			if status.Int() < 400 && options.Sample && rand.Float64() >= options.SampleRate {
+				return
+			}
+			switch options.Format {
+			case AccessLogCommon, AccessLogCombined:
+				line := commonLogLine(req, res, start, options.Format == AccessLogCombined)
+				mu.Lock()
+				io.WriteString(options.Output, line)
+				mu.Unlock()
+			default:
+				level := slog.LevelInfo
+				if status.Int() >= 500 {
+					level = slog.LevelError
+				}
+				logger.LogAttrs(req.Context(), level, "request",
+					slog.String("request_id", requestIDOf(req)),
+					slog.String("client_ip", req.ClientIP()),
+					slog.String("method", req.GetMethod().String()),
+					slog.String("path", req.GetPath()),
+					slog.String("query", req.GetRawQuery()),
+					slog.Int("status", status.Int()),
+					slog.Int64("bytes", res.GetBytesWritten()),
+					slog.Duration("duration", time.Since(start)),
+					slog.String("user_agent", req.GetHeader("User-Agent")),
+					slog.String("referrer", req.GetHeader("Referer")),
+				)
+			}
+		})
+		next()
+	}
+}
+
+// requestIDOf returns the id given to the request by RequestID, or the one received when
+// the middleware isn't used
+func requestIDOf(req *http.Request) string {
+	if id := GetRequestID(req); id != "" {
+		return id
+	}
+	return req.GetHeader(DefaultRequestIDOptions.Header)
+}
+
+func skipAccessLog(path string, skipPaths []string) bool {
+	for _, skipped := range skipPaths {
+		skipped = strings.TrimSuffix(skipped, "/")
+		if path == skipped || strings.HasPrefix(path, skipped+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// commonLogLine formats a request in the Common Log Format, host ident authuser [date] "request" status bytes,
+// the Combined Log Format adds "referrer" "user agent"
+func commonLogLine(req *http.Request, res *http.Response, start time.Time, combined bool) string {
+	user := "-"
+	if principal := req.Principal(); principal != nil && principal.ID != "" {
+		user = principal.ID
+	}
+	target := req.GetPath()
+	if query := req.GetRawQuery(); query != "" {
+		target += "?" + query
+	}
+	size := "-"
+	if written := res.GetBytesWritten(); written > 0 {
+		size = strconv.FormatInt(written, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s",
+		logField(req.ClientIP()), logField(user), start.Format("02/Jan/2006:15:04:05 -0700"),
+		req.GetMethod().String()+" "+target+" "+req.GetProto(), res.GetStatusCode().Int(), size)
+	if combined {
+		line += fmt.Sprintf(` "%s" "%s"`, logField(req.GetHeader("Referer")), logField(req.GetHeader("User-Agent")))
+	}
+	return line + "\n"
+}
+
+// logField replaces an empty field by "-" and escapes the characters that would break the line
+func logField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	quoted := strconv.Quote(value)
+	return quoted[1 : len(quoted)-1]
+}
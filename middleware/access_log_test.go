@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"http-server/app/http"
+	nethttp "net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer collects the records written by the server goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines waits for count records, they are written once the response is sent
+func (b *syncBuffer) lines(t *testing.T, count int) []string {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		b.mu.Lock()
+		lines := strings.Split(strings.TrimSuffix(b.buf.String(), "\n"), "\n")
+		empty := b.buf.Len() == 0
+		b.mu.Unlock()
+		if !empty && len(lines) >= count {
+			return lines
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d records logged, want %d", len(lines), count)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func accessLogServer(t *testing.T, options AccessLogOptions) string {
+	t.Helper()
+	router := http.NewRouter()
+	router.UseGlobalPreMiddlewares([]http.MiddlewareFunc{AccessLog(options), RequestID(RequestIDOptions{})})
+	router.Get("/hello", func(req *http.Request, res *http.Response) {
+		res.HttpResponse("hello", http.StatusOK)
+	})
+	return http.StartTestServer(t, router)
+}
+
+func getWithHeaders(t *testing.T, url string, headers map[string]string) *nethttp.Response {
+	t.Helper()
+	req, _ := nethttp.NewRequest("GET", url, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	response, err := nethttp.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	response.Body.Close()
+	return response
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	output := &syncBuffer{}
+	baseURL := accessLogServer(t, AccessLogOptions{Output: output})
+	response := getWithHeaders(t, baseURL+"/hello?a=1", map[string]string{"User-Agent": "tester"})
+
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(output.lines(t, 1)[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	for field, want := range map[string]interface{}{
+		"msg":        "request",
+		"level":      "INFO",
+		"method":     "GET",
+		"path":       "/hello",
+		"query":      "a=1",
+		"status":     float64(200),
+		"bytes":      float64(5),
+		"user_agent": "tester",
+		"request_id": response.Header.Get("X-Request-ID"),
+		"client_ip":  "127.0.0.1",
+	} {
+		if record[field] != want {
+			t.Errorf("%s = %v, want %v", field, record[field], want)
+		}
+	}
+}
+
+func TestAccessLogCombined(t *testing.T) {
+	output := &syncBuffer{}
+	baseURL := accessLogServer(t, AccessLogOptions{Format: AccessLogCombined, Output: output})
+	getWithHeaders(t, baseURL+"/missing", map[string]string{"User-Agent": `evil" agent`, "Referer": "https://example.com/"})
+
+	line := output.lines(t, 1)[0]
+	pattern := regexp.MustCompile(`^127\.0\.0\.1 - - \[[^\]]+\] "GET /missing HTTP/1\.1" 404 (\d+|-) "https://example\.com/" "evil\\" agent"$`)
+	if !pattern.MatchString(line) {
+		t.Errorf("line = %q", line)
+	}
+}
+
+func TestAccessLogSkipsAndSamples(t *testing.T) {
+	output := &syncBuffer{}
+	baseURL := accessLogServer(t, AccessLogOptions{Format: AccessLogCommon, Output: output, SkipPaths: []string{"/hello/"}, Sample: true})
+	getWithHeaders(t, baseURL+"/hello", nil)
+	getWithHeaders(t, baseURL+"/hello/x", nil)
+	getWithHeaders(t, baseURL+"/hellox", nil)
+	getWithHeaders(t, baseURL+"/missing", nil)
+
+	// Only the errors are logged with a zero sample rate, the /hello paths aren't logged at all
+	output.lines(t, 2)
+	time.Sleep(20 * time.Millisecond)
+	lines := strings.Join(output.lines(t, 2), "\n")
+	if strings.Count(lines, "\n") != 1 || !strings.Contains(lines, "GET /hellox ") || !strings.Contains(lines, "GET /missing ") {
+		t.Errorf("lines = %q", lines)
+	}
+}
@@ -1,15 +1,157 @@
 package middleware
 
 import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"http-server/app/http"
-	"log"
+	"strings"
 )
 
+// AuthMiddleware only checks that the request carries an Authorization header.
+//
+// Deprecated: use BasicAuth, BearerAuth or APIKeyAuth, which verify the credentials.
 func AuthMiddleware(req *http.Request, res *http.Response, next func()) {
-	if req.GetHeader("Authorisation") == "" {
+	if req.GetHeader("Authorization") == "" {
 		res.HttpResponse("Unauthorized", http.StatusUnauthorized)
-		log.Print(req.GetMethod(), " ", req.GetPath(), " ", res.GetStatusCode().Int(), " ", res.GetStatusCode())
 		return
 	}
 	next()
-}
\ No newline at end of file
+}
+
+// ErrInvalidCredentials is returned by verifiers when the credentials don't match any principal
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// BasicVerifier checks a username and password and returns the matching principal
+type BasicVerifier func(username, password string) (*http.Principal, error)
+
+// TokenVerifier checks a bearer token and returns the matching principal
+type TokenVerifier interface {
+	Verify(token string) (*http.Principal, error)
+}
+
+// APIKeyLookup returns the principal owning an API key
+type APIKeyLookup func(key string) (*http.Principal, error)
+
+// BasicAuth authenticates requests with HTTP Basic credentials, challenging the client
+// with a WWW-Authenticate header for realm when they are missing or wrong
+func BasicAuth(realm string, verify BasicVerifier) http.MiddlewareFunc {
+	challenge := fmt.Sprintf(`Basic realm=%q, charset="UTF-8"`, realm)
+	return func(req *http.Request, res *http.Response, next func()) {
+		username, password, ok := basicCredentials(req.GetHeader("Authorization"))
+		if !ok {
+			unauthorized(res, challenge)
+			return
+		}
+		principal, err := verify(username, password)
+		if err != nil || principal == nil {
+			unauthorized(res, challenge)
+			return
+		}
+		if principal.Scheme == "" {
+			principal.Scheme = "Basic"
+		}
+		req.SetPrincipal(principal)
+		next()
+	}
+}
+
+// StaticBasicCredentials is a BasicVerifier for a fixed username/password list,
+// passwords are compared in constant time
+func StaticBasicCredentials(credentials map[string]string) BasicVerifier {
+	return func(username, password string) (*http.Principal, error) {
+		expected, exists := credentials[username]
+		if !exists {
+			// Compare anyway so unknown users take as long as wrong passwords
+			expected = "\x00"
+		}
+		if subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 || !exists {
+			return nil, ErrInvalidCredentials
+		}
+		return &http.Principal{ID: username}, nil
+	}
+}
+
+// BearerAuth authenticates requests carrying an "Authorization: Bearer <token>" header
+func BearerAuth(realm string, verifier TokenVerifier) http.MiddlewareFunc {
+	return func(req *http.Request, res *http.Response, next func()) {
+		scheme, token, _ := strings.Cut(req.GetHeader("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			unauthorized(res, fmt.Sprintf("Bearer realm=%q", realm))
+			return
+		}
+		principal, err := verifier.Verify(strings.TrimSpace(token))
+		if err != nil || principal == nil {
+			unauthorized(res, fmt.Sprintf(`Bearer realm=%q, error="invalid_token"`, realm))
+			return
+		}
+		if principal.Scheme == "" {
+			principal.Scheme = "Bearer"
+		}
+		req.SetPrincipal(principal)
+		next()
+	}
+}
+
+// APIKeyAuth authenticates requests by the API key found in header or, when header
+// is empty or missing from the request, in the queryParam query parameter
+func APIKeyAuth(header string, queryParam string, lookup APIKeyLookup) http.MiddlewareFunc {
+	return func(req *http.Request, res *http.Response, next func()) {
+		key := ""
+		if header != "" {
+			key = req.GetHeader(header)
+		}
+		if key == "" && queryParam != "" {
+			key = req.GetQueryParam(queryParam)
+		}
+		if key == "" {
+			res.HttpResponse("Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		principal, err := lookup(key)
+		if err != nil || principal == nil {
+			res.HttpResponse("Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if principal.Scheme == "" {
+			principal.Scheme = "APIKey"
+		}
+		req.SetPrincipal(principal)
+		next()
+	}
+}
+
+// StaticAPIKeys is an APIKeyLookup for a fixed set of keys, keys are compared in constant time
+func StaticAPIKeys(keys map[string]*http.Principal) APIKeyLookup {
+	return func(key string) (*http.Principal, error) {
+		var found *http.Principal
+		for candidate, principal := range keys {
+			if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+				found = principal
+			}
+		}
+		if found == nil {
+			return nil, ErrInvalidCredentials
+		}
+		copied := *found
+		return &copied, nil
+	}
+}
+
+func basicCredentials(header string) (string, string, bool) {
+	scheme, encoded, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+func unauthorized(res *http.Response, challenge string) {
+	res.HttpResponse("Unauthorized", http.StatusUnauthorized)
+	res.SetHeader("WWW-Authenticate", challenge)
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"http-server/app/http"
+	"testing"
+	"time"
+)
+
+// authRouter serves the ID and scheme of the principal behind auth
+func authRouter(auth http.MiddlewareFunc) *http.Router {
+	router := http.NewRouter()
+	router.Get("/", func(req *http.Request, res *http.Response) {
+		principal := req.Principal()
+		res.HttpResponse(principal.ID+" "+principal.Scheme, http.StatusOK)
+	}).UsePreMiddlewares([]http.MiddlewareFunc{auth})
+	return router
+}
+
+func dispatchWithHeaders(t *testing.T, router *http.Router, target string, headers string) *http.Response {
+	t.Helper()
+	return router.Dispatch(newTestRequest(t, "GET "+target+" HTTP/1.1\r\nHost: example.com\r\n"+headers+"\r\n"))
+}
+
+func TestBasicAuth(t *testing.T) {
+	router := authRouter(BasicAuth("admin area", StaticBasicCredentials(map[string]string{"gopher": "s3cret"})))
+	basic := func(credentials string) string {
+		return "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)) + "\r\n"
+	}
+
+	res := dispatchWithHeaders(t, router, "/", basic("gopher:s3cret"))
+	if res.GetStatusCode() != http.StatusOK || res.GetBody() != "gopher Basic" {
+		t.Errorf("status = %d, body = %q", res.GetStatusCode().Int(), res.GetBody())
+	}
+	for name, headers := range map[string]string{
+		"missing":        "",
+		"wrong password": basic("gopher:wrong"),
+		"unknown user":   basic("nobody:s3cret"),
+		"no colon":       basic("gopher"),
+		"not base64":     "Authorization: Basic ***\r\n",
+		"other scheme":   "Authorization: Bearer abc\r\n",
+	} {
+		res := dispatchWithHeaders(t, router, "/", headers)
+		if res.GetStatusCode() != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", name, res.GetStatusCode().Int())
+		}
+		if res.GetHeader("WWW-Authenticate") != `Basic realm="admin area", charset="UTF-8"` {
+			t.Errorf("%s: WWW-Authenticate = %q", name, res.GetHeader("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	router := authRouter(BearerAuth("api", &JWTVerifier{Keys: testJWKS(t)}))
+	token := signToken(t, "ES256", "ec", map[string]interface{}{"sub": "user-1", "exp": time.Now().Add(time.Hour).Unix()})
+
+	res := dispatchWithHeaders(t, router, "/", "Authorization: bearer "+token+"\r\n")
+	if res.GetStatusCode() != http.StatusOK || res.GetBody() != "user-1 Bearer" {
+		t.Errorf("status = %d, body = %q", res.GetStatusCode().Int(), res.GetBody())
+	}
+
+	res = dispatchWithHeaders(t, router, "/", "")
+	if res.GetStatusCode() != http.StatusUnauthorized || res.GetHeader("WWW-Authenticate") != `Bearer realm="api"` {
+		t.Errorf("missing token: status = %d, WWW-Authenticate = %q", res.GetStatusCode().Int(), res.GetHeader("WWW-Authenticate"))
+	}
+	res = dispatchWithHeaders(t, router, "/", "Authorization: Bearer "+token+"x\r\n")
+	if res.GetStatusCode() != http.StatusUnauthorized || res.GetHeader("WWW-Authenticate") != `Bearer realm="api", error="invalid_token"` {
+		t.Errorf("invalid token: status = %d, WWW-Authenticate = %q", res.GetStatusCode().Int(), res.GetHeader("WWW-Authenticate"))
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	owner := &http.Principal{ID: "service-a", Roles: []string{"reader"}}
+	router := authRouter(APIKeyAuth("X-API-Key", "api_key", StaticAPIKeys(map[string]*http.Principal{"key-a": owner})))
+
+	for name, request := range map[string][2]string{
+		"header":      {"/", "X-API-Key: key-a\r\n"},
+		"query":       {"/?api_key=key-a", ""},
+		"header case": {"/", "x-api-key: key-a\r\n"},
+	} {
+		res := dispatchWithHeaders(t, router, request[0], request[1])
+		if res.GetStatusCode() != http.StatusOK || res.GetBody() != "service-a APIKey" {
+			t.Errorf("%s: status = %d, body = %q", name, res.GetStatusCode().Int(), res.GetBody())
+		}
+	}
+	if owner.Scheme != "" {
+		t.Error("StaticAPIKeys handed out the stored principal instead of a copy")
+	}
+	for name, request := range map[string][2]string{
+		"missing":   {"/", ""},
+		"wrong key": {"/", "X-API-Key: key-b\r\n"},
+	} {
+		if res := dispatchWithHeaders(t, router, request[0], request[1]); res.GetStatusCode() != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", name, res.GetStatusCode().Int())
+		}
+	}
+}
+
+func TestAuthMiddlewareChecksAuthorization(t *testing.T) {
+	router := http.NewRouter()
+	router.Get("/", func(req *http.Request, res *http.Response) {
+		res.HttpResponse("ok", http.StatusOK)
+	}).UsePreMiddlewares([]http.MiddlewareFunc{AuthMiddleware})
+
+	if res := dispatchWithHeaders(t, router, "/", "Authorisation: x\r\n"); res.GetStatusCode() != http.StatusUnauthorized {
+		t.Errorf("misspelled header accepted, status = %d", res.GetStatusCode().Int())
+	}
+	if res := dispatchWithHeaders(t, router, "/", "Authorization: x\r\n"); res.GetStatusCode() != http.StatusOK {
+		t.Errorf("status = %d with an Authorization header", res.GetStatusCode().Int())
+	}
+}
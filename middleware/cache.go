@@ -0,0 +1,234 @@
+package middleware
+
+import (
+	"http-server/app/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheSize is the size of the store of a ResponseCache without one
+const defaultCacheSize = 64 << 20
+
+// CacheOptions configures the ResponseCache middleware
+type CacheOptions struct {
+	// Store keeps the responses, a 64 MiB memory cache owned by the middleware when nil
+	Store CacheStore
+	// Key returns the primary cache key of a request, DefaultCacheKey when nil.
+	// Returning an empty key skips the cache for that request.
+	Key func(req *http.Request) string
+	// DefaultTTL caches responses without a Cache-Control header for that long, they aren't cached when zero
+	DefaultTTL time.Duration
+}
+
+// DefaultCacheKey identifies a response by its path and raw query string
+func DefaultCacheKey(req *http.Request) string {
+	if query := req.GetRawQuery(); query != "" {
+		return req.GetPath() + "?" + query
+	}
+	return req.GetPath()
+}
+
+// CacheTag tags the response so it can later be purged with CacheStore.PurgeTag,
+// the tags are sent in a Cache-Tag header that the cache removes before the response leaves
+func CacheTag(res *http.Response, tags ...string) {
+	for _, tag := range tags {
+		res.AppendHeader("Cache-Tag", tag)
+	}
+}
+
+// ResponseCache serves GET and HEAD requests from the store while the stored response is fresh.
+// It behaves as a shared cache: Cache-Control max-age and s-maxage set the freshness,
+// no-store, no-cache and private responses are never stored, Vary selects the variant,
+// and stale-while-revalidate serves a stale response while a fresh one is produced in the background.
+func ResponseCache(options CacheOptions) http.MiddlewareFunc {
+	if options.Key == nil {
+		options.Key = DefaultCacheKey
+	}
+	store := options.Store
+	if store == nil {
+		store = NewMemoryCache(defaultCacheSize)
+	}
+	revalidations := &cacheRevalidations{keys: make(map[string]bool)}
+
+	return func(req *http.Request, res *http.Response, next func()) {
+		method := req.GetMethod()
+		if method != http.GET && method != http.HEAD {
+			next()
+			return
+		}
+		primaryKey := options.Key(req)
+		if primaryKey == "" {
+			next()
+			return
+		}
+
+		requestDirectives := parseCacheControl(req.GetHeader("Cache-Control"))
+		_, noCache := requestDirectives["no-cache"]
+		if !noCache {
+			key := variantKey(primaryKey, store.Vary(primaryKey), req)
+			if cached, found := store.Get(key); found {
+				age := time.Since(cached.StoredAt)
+				if age < cached.TTL {
+					replayResponse(res, cached, age, "HIT")
+					return
+				}
+				if age < cached.TTL+cached.StaleTTL && req.Router() != nil {
+					replayResponse(res, cached, age, "STALE")
+					if revalidations.start(key) {
+						clone := req.Clone()
+						go func() {
+							defer revalidations.end(key)
+							revalidate(clone, store, primaryKey, options.DefaultTTL)
+						}()
+					}
+					return
+				}
+			}
+		}
+
+		res.BeforeWrite(func() {
+			storeResponse(req, res, store, primaryKey, options.DefaultTTL)
+		})
+		next()
+	}
+}
+
+// revalidate runs the route handler again for a copy of the request and stores its response.
+// The middlewares aren't run again, they already let the original request through.
+func revalidate(clone *http.Request, store CacheStore, primaryKey string, defaultTTL time.Duration) {
+	res := clone.Router().DispatchHandler(clone)
+	storeResponse(clone, res, store, primaryKey, defaultTTL)
+}
+
+// cacheRevalidations tracks the keys being revalidated, so only one revalidation of a
+// stale response runs at a time
+type cacheRevalidations struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func (r *cacheRevalidations) start(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.keys[key] {
+		return false
+	}
+	r.keys[key] = true
+	return true
+}
+
+func (r *cacheRevalidations) end(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.keys, key)
+}
+
+func storeResponse(req *http.Request, res *http.Response, store CacheStore, primaryKey string, defaultTTL time.Duration) {
+	tags := splitHeaderList(res.GetHeader("Cache-Tag"))
+	res.DeleteHeader("Cache-Tag")
+
+	if !isCacheableStatus(res.GetStatusCode()) || res.IsStreaming() || res.HasCookies() {
+		return
+	}
+	directives := parseCacheControl(res.GetHeader("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, found := directives[directive]; found {
+			return
+		}
+	}
+	_, public := directives["public"]
+	sharedMaxAge, hasSharedMaxAge := directives["s-maxage"]
+	if req.GetHeader("Authorization") != "" && !public && !hasSharedMaxAge {
+		return
+	}
+
+	ttl := time.Duration(0)
+	if hasSharedMaxAge {
+		ttl = directiveSeconds(sharedMaxAge)
+	} else if maxAge, found := directives["max-age"]; found {
+		ttl = directiveSeconds(maxAge)
+	} else if len(directives) == 0 {
+		ttl = defaultTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	vary := make([]string, 0)
+	for _, headerName := range splitHeaderList(res.GetHeader("Vary")) {
+		if headerName == "*" {
+			return
+		}
+		vary = append(vary, headerName)
+	}
+
+	headers := res.GetHeaders()
+	delete(headers, "Date")
+	store.Set(primaryKey, variantKey(primaryKey, vary, req), vary, &CachedResponse{
+		StatusCode: res.GetStatusCode(),
+		Headers:    headers,
+		Body:       res.GetBody(),
+		StoredAt:   time.Now(),
+		TTL:        ttl,
+		StaleTTL:   directiveSeconds(directives["stale-while-revalidate"]),
+		Tags:       tags,
+	})
+	res.SetHeader("X-Cache", "MISS")
+}
+
+func replayResponse(res *http.Response, cached *CachedResponse, age time.Duration, status string) {
+	for headerName, headerValue := range cached.Headers {
+		res.SetHeader(headerName, headerValue)
+	}
+	res.SetStatusCode(cached.StatusCode)
+	res.SetBody(cached.Body)
+	if cached.StatusCode == http.StatusNoContent {
+		res.DeleteHeader("Content-Length")
+	}
+	res.SetHeader("Date", time.Now().UTC().Format(time.RFC1123))
+	res.SetHeader("Age", strconv.Itoa(int(age.Seconds())))
+	res.SetHeader("X-Cache", status)
+}
+
+// variantKey appends the request values of the Vary headers to the primary key
+func variantKey(primaryKey string, vary []string, req *http.Request) string {
+	key := primaryKey
+	for _, headerName := range vary {
+		key += "\x00" + strings.ToLower(headerName) + "=" + req.GetHeader(headerName)
+	}
+	return key
+}
+
+func isCacheableStatus(code http.StatusCode) bool {
+	switch code {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseCacheControl returns the directives of a Cache-Control header with their
+// unquoted argument, directives without argument map to an empty string
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, item := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			directives[name] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return directives
+}
+
+func directiveSeconds(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
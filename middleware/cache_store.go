@@ -0,0 +1,177 @@
+package middleware
+
+import (
+	"container/list"
+	"http-server/app/http"
+	"sync"
+	"time"
+)
+
+// CachedResponse is a response stored by the ResponseCache middleware
+type CachedResponse struct {
+	StatusCode http.StatusCode
+	Headers    map[string]string
+	Body       string
+	StoredAt   time.Time
+	// TTL is how long the response is fresh, StaleTTL how long after that it may still
+	// be served while it is revalidated in the background
+	TTL      time.Duration
+	StaleTTL time.Duration
+	Tags     []string
+}
+
+func (c *CachedResponse) size() int {
+	size := len(c.Body)
+	for headerName, headerValue := range c.Headers {
+		size += len(headerName) + len(headerValue)
+	}
+	return size
+}
+
+// CacheStore keeps the responses of the ResponseCache middleware. The full key of a response is
+// its primary key followed by the request values of the headers returned by Vary.
+type CacheStore interface {
+	// Vary returns the header names the responses stored under primaryKey vary on
+	Vary(primaryKey string) []string
+	Get(key string) (*CachedResponse, bool)
+	Set(primaryKey string, key string, vary []string, response *CachedResponse)
+	// PurgeKey removes every variant of primaryKey and PurgeTag every response tagged with tag
+	PurgeKey(primaryKey string)
+	PurgeTag(tag string)
+	Purge()
+}
+
+type cacheItem struct {
+	key        string
+	primaryKey string
+	response   *CachedResponse
+}
+
+// MemoryCache is an in-memory LRU store for responses, bounded by the total size of the bodies and headers
+type MemoryCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	bytes    int
+	lru      *list.List
+	items    map[string]*list.Element
+	// vary keeps the Vary header names of every primary key, the full key of a variant
+	// is the primary key followed by the request values of those headers
+	vary map[string][]string
+	// variants and tags index the full keys to purge
+	variants map[string]map[string]bool
+	tags     map[string]map[string]bool
+}
+
+// NewMemoryCache creates a cache holding at most maxBytes of responses
+func NewMemoryCache(maxBytes int) *MemoryCache {
+	return &MemoryCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+		vary:     make(map[string][]string),
+		variants: make(map[string]map[string]bool),
+		tags:     make(map[string]map[string]bool),
+	}
+}
+
+// Vary returns the header names the responses stored under primaryKey vary on
+func (c *MemoryCache) Vary(primaryKey string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.vary[primaryKey]
+}
+
+// Get returns the response stored under the full key and marks it as recently used
+func (c *MemoryCache) Get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, exists := c.items[key]
+	if !exists {
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*cacheItem).response, true
+}
+
+// Set stores a response under its full key, evicting the least recently used ones to make room
+func (c *MemoryCache) Set(primaryKey string, key string, vary []string, response *CachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if response.size() > c.maxBytes {
+		return
+	}
+	if element, exists := c.items[key]; exists {
+		c.removeElement(element)
+	}
+	c.vary[primaryKey] = vary
+	element := c.lru.PushFront(&cacheItem{key: key, primaryKey: primaryKey, response: response})
+	c.items[key] = element
+	c.bytes += response.size()
+	addToIndex(c.variants, primaryKey, key)
+	for _, tag := range response.Tags {
+		addToIndex(c.tags, tag, key)
+	}
+	for c.bytes > c.maxBytes {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// PurgeKey removes every variant stored under a primary key
+func (c *MemoryCache) PurgeKey(primaryKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.variants[primaryKey] {
+		if element, exists := c.items[key]; exists {
+			c.removeElement(element)
+		}
+	}
+	delete(c.vary, primaryKey)
+}
+
+// PurgeTag removes every response tagged with tag
+func (c *MemoryCache) PurgeTag(tag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.tags[tag] {
+		if element, exists := c.items[key]; exists {
+			c.removeElement(element)
+		}
+	}
+}
+
+// Purge empties the cache
+func (c *MemoryCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.bytes = 0
+	c.items = make(map[string]*list.Element)
+	c.vary = make(map[string][]string)
+	c.variants = make(map[string]map[string]bool)
+	c.tags = make(map[string]map[string]bool)
+}
+
+func (c *MemoryCache) removeElement(element *list.Element) {
+	item := element.Value.(*cacheItem)
+	c.lru.Remove(element)
+	delete(c.items, item.key)
+	c.bytes -= item.response.size()
+	removeFromIndex(c.variants, item.primaryKey, item.key)
+	for _, tag := range item.response.Tags {
+		removeFromIndex(c.tags, tag, item.key)
+	}
+}
+
+func addToIndex(index map[string]map[string]bool, name string, key string) {
+	if index[name] == nil {
+		index[name] = make(map[string]bool)
+	}
+	index[name][key] = true
+}
+
+func removeFromIndex(index map[string]map[string]bool, name string, key string) {
+	delete(index[name], key)
+	if len(index[name]) == 0 {
+		delete(index, name)
+	}
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"http-server/app/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cacheRouter serves the number of handler calls with the raw query string as Cache-Control,
+// middlewareCalls counts the requests passing through the global middlewares
+func cacheRouter(store CacheStore, handlerCalls *int32, middlewareCalls *int32) *http.Router {
+	router := http.NewRouter()
+	router.UseGlobalPreMiddlewares([]http.MiddlewareFunc{
+		func(req *http.Request, res *http.Response, next func()) {
+			atomic.AddInt32(middlewareCalls, 1)
+			next()
+		},
+		ResponseCache(CacheOptions{Store: store}),
+	})
+	router.Get("/", func(req *http.Request, res *http.Response) {
+		calls := atomic.AddInt32(handlerCalls, 1)
+		res.SetHeader("Cache-Control", req.GetRawQuery())
+		res.SetHeader("Vary", "Accept-Language")
+		CacheTag(res, "home")
+		res.HttpResponse(strconv.Itoa(int(calls)), http.StatusOK)
+	})
+	return router
+}
+
+func TestResponseCacheHitsAndVariants(t *testing.T) {
+	store := NewMemoryCache(1 << 20)
+	var handlerCalls, middlewareCalls int32
+	router := cacheRouter(store, &handlerCalls, &middlewareCalls)
+
+	first := dispatchWithHeaders(t, router, "/?max-age=60", "Accept-Language: en\r\n")
+	if first.GetHeader("X-Cache") != "MISS" || first.GetHeader("Cache-Tag") != "" {
+		t.Errorf("X-Cache = %q, Cache-Tag = %q", first.GetHeader("X-Cache"), first.GetHeader("Cache-Tag"))
+	}
+	second := dispatchWithHeaders(t, router, "/?max-age=60", "Accept-Language: en\r\n")
+	if second.GetHeader("X-Cache") != "HIT" || second.GetBody() != "1" {
+		t.Errorf("X-Cache = %q, body = %q", second.GetHeader("X-Cache"), second.GetBody())
+	}
+	if other := dispatchWithHeaders(t, router, "/?max-age=60", "Accept-Language: fr\r\n"); other.GetBody() != "2" {
+		t.Errorf("other language served body %q", other.GetBody())
+	}
+	if refresh := dispatchWithHeaders(t, router, "/?max-age=60", "Accept-Language: en\r\nCache-Control: no-cache\r\n"); refresh.GetBody() != "3" {
+		t.Errorf("no-cache request served body %q", refresh.GetBody())
+	}
+
+	store.PurgeTag("home")
+	if res := dispatchWithHeaders(t, router, "/?max-age=60", "Accept-Language: en\r\n"); res.GetHeader("X-Cache") != "MISS" {
+		t.Errorf("purged response served, X-Cache = %q", res.GetHeader("X-Cache"))
+	}
+}
+
+func TestResponseCacheSkipsUncacheableResponses(t *testing.T) {
+	var handlerCalls, middlewareCalls int32
+	router := cacheRouter(NewMemoryCache(1<<20), &handlerCalls, &middlewareCalls)
+	for _, test := range []struct{ name, target, headers string }{
+		{"no-store", "/?no-store", ""},
+		{"private", "/?private,max-age=60", ""},
+		{"no Cache-Control", "/", ""},
+		{"authorized", "/?max-age=60", "Authorization: Bearer x\r\n"},
+	} {
+		dispatchWithHeaders(t, router, test.target, test.headers)
+		if res := dispatchWithHeaders(t, router, test.target, test.headers); res.GetHeader("X-Cache") == "HIT" {
+			t.Errorf("%s: response cached", test.name)
+		}
+	}
+}
+
+func TestResponseCacheRevalidatesWithoutMiddlewares(t *testing.T) {
+	store := NewMemoryCache(1 << 20)
+	var handlerCalls, middlewareCalls int32
+	router := cacheRouter(store, &handlerCalls, &middlewareCalls)
+	target := "/?max-age=1,stale-while-revalidate=60"
+
+	key := target + "\x00accept-language="
+	dispatchWithHeaders(t, router, target, "")
+	cached, found := store.Get(key)
+	if !found {
+		t.Fatal("response not stored")
+	}
+	cached.StoredAt = time.Now().Add(-2 * time.Second)
+
+	res := dispatchWithHeaders(t, router, target, "")
+	if res.GetHeader("X-Cache") != "STALE" || res.GetBody() != "1" {
+		t.Errorf("X-Cache = %q, body = %q", res.GetHeader("X-Cache"), res.GetBody())
+	}
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if fresh, _ := store.Get(key); fresh != cached {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if calls := atomic.LoadInt32(&middlewareCalls); calls != 2 {
+		t.Errorf("global middlewares ran %d times, want 2 with the revalidation skipping them", calls)
+	}
+	if res := dispatchWithHeaders(t, router, target, ""); res.GetHeader("X-Cache") != "HIT" || res.GetBody() != "2" {
+		t.Errorf("after revalidation: X-Cache = %q, body = %q", res.GetHeader("X-Cache"), res.GetBody())
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	var store CacheStore = NewMemoryCache(10)
+	response := func(body string) *CachedResponse {
+		return &CachedResponse{Body: body, StoredAt: time.Now(), TTL: time.Minute}
+	}
+	store.Set("a", "a", nil, response("aaaa"))
+	store.Set("b", "b", nil, response("bbbb"))
+	store.Get("a")
+	store.Set("c", "c", nil, response("cccc"))
+	if _, found := store.Get("b"); found {
+		t.Error("least recently used response kept")
+	}
+	if _, found := store.Get("a"); !found {
+		t.Error("recently used response evicted")
+	}
+	store.Set("big", "big", nil, response("far too large"))
+	if _, found := store.Get("big"); found {
+		t.Error("response larger than the cache stored")
+	}
+}
@@ -0,0 +1,253 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"http-server/app/http"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// CompressOptions configures the Compress middleware
+type CompressOptions struct {
+	// Level is a compress/flate level, flate.DefaultCompression when zero.
+	// NoCompressionLevel stands for flate.NoCompression since zero is taken.
+	Level int
+	// MinSize is the smallest buffered body worth compressing, in bytes
+	MinSize int
+	// SkipContentTypes lists content type prefixes that are already compressed
+	SkipContentTypes []string
+	// MaxRequestBodySize caps the size of decompressed request bodies
+	MaxRequestBodySize int64
+}
+
+// NoCompressionLevel is the Level sending bodies in the gzip or deflate format without compressing them
+const NoCompressionLevel = -3
+
+// DefaultCompressOptions are used by Compress for every zero field of the given options
+var DefaultCompressOptions = CompressOptions{
+	Level:   flate.DefaultCompression,
+	MinSize: 1024,
+	SkipContentTypes: []string{
+		"image/", "video/", "audio/", "font/woff",
+		"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+		"application/octet-stream", "application/pdf",
+	},
+	MaxRequestBodySize: http.MaxBodyBytes,
+}
+
+// Compress compresses responses with gzip or deflate according to the Accept-Encoding
+// of the request, for buffered as well as streamed bodies, and decompresses gzip or
+// deflate encoded request bodies before the handler reads them. It panics when Level is out of range.
+func Compress(options CompressOptions) http.MiddlewareFunc {
+	switch options.Level {
+	case 0:
+		options.Level = DefaultCompressOptions.Level
+	case NoCompressionLevel:
+		options.Level = flate.NoCompression
+	}
+	if options.Level < flate.HuffmanOnly || options.Level > flate.BestCompression {
+		panic("compress: invalid compression level " + strconv.Itoa(options.Level))
+	}
+	if options.MinSize == 0 {
+		options.MinSize = DefaultCompressOptions.MinSize
+	}
+	if options.SkipContentTypes == nil {
+		options.SkipContentTypes = DefaultCompressOptions.SkipContentTypes
+	}
+	if options.MaxRequestBodySize == 0 {
+		options.MaxRequestBodySize = DefaultCompressOptions.MaxRequestBodySize
+	}
+
+	return func(req *http.Request, res *http.Response, next func()) {
+		if encoding := strings.ToLower(req.GetHeader("Content-Encoding")); encoding != "" && encoding != "identity" {
+			body, err := decompress(encoding, req.GetBody(), options.MaxRequestBodySize)
+			if err != nil {
+				res.ErrorResponse(http.StatusUnsupportedMediaType, "request body cannot be decoded")
+				return
+			}
+			req.DeleteHeader("Content-Encoding")
+			if err := req.SetBody(body); err != nil {
+				res.ErrorResponse(http.StatusBadRequest, "request body cannot be parsed")
+				return
+			}
+		}
+
+		encoding := negotiateEncoding(req.GetHeader("Accept-Encoding"))
+		res.BeforeWrite(func() {
+			compressResponse(res, encoding, options)
+		})
+		next()
+	}
+}
+
+func compressResponse(res *http.Response, encoding string, options CompressOptions) {
+	if !compressible(res, options) {
+		return
+	}
+	res.AppendHeader("Vary", "Accept-Encoding")
+	if encoding == "" {
+		return
+	}
+
+	if res.IsStreaming() {
+		res.SetHeader("Content-Encoding", encoding)
+		res.DeleteHeader("Content-Length")
+		res.WrapStreamWriter(func(w io.Writer) io.WriteCloser {
+			writer, err := newCompressor(encoding, w, options.Level)
+			if err != nil {
+				return failedWriter{err}
+			}
+			return writer
+		})
+		return
+	}
+
+	body := res.GetBody()
+	if len(body) < options.MinSize {
+		return
+	}
+	var compressed bytes.Buffer
+	writer, err := newCompressor(encoding, &compressed, options.Level)
+	if err != nil {
+		return
+	}
+	io.WriteString(writer, body)
+	if err := writer.Close(); err != nil {
+		return
+	}
+	res.SetHeader("Content-Encoding", encoding)
+	res.SetBody(compressed.String())
+	// A strong ETag of the identity body no longer describes the bytes sent
+	if etag := res.GetHeader("ETag"); strings.HasPrefix(etag, `"`) {
+		res.SetHeader("ETag", "W/"+etag)
+	}
+}
+
+func compressible(res *http.Response, options CompressOptions) bool {
+	if res.GetHeader("Content-Encoding") != "" {
+		return false
+	}
+	code := res.GetStatusCode()
+	if code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent || code.IsInformational() {
+		return false
+	}
+	if strings.Contains(res.GetHeader("Cache-Control"), "no-transform") {
+		return false
+	}
+	contentType := strings.ToLower(res.GetHeader("Content-Type"))
+	for _, skip := range options.SkipContentTypes {
+		if strings.HasPrefix(contentType, skip) {
+			return false
+		}
+	}
+	return true
+}
+
+func newCompressor(encoding string, w io.Writer, level int) (io.WriteCloser, error) {
+	if encoding == "gzip" {
+		return gzip.NewWriterLevel(w, level)
+	}
+	return zlib.NewWriterLevel(w, level)
+}
+
+// failedWriter fails every write with err, it ends streams whose compressor couldn't be created
+type failedWriter struct {
+	err error
+}
+
+func (f failedWriter) Write(p []byte) (int, error) {
+	return 0, f.err
+}
+
+func (f failedWriter) Close() error {
+	return f.err
+}
+
+func decompress(encoding string, body []byte, limit int64) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	// Read one byte past the limit to tell a body of exactly limit bytes from a bomb
+	decoded, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > limit {
+		return nil, io.ErrShortBuffer
+	}
+	return decoded, nil
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header by q-value,
+// preferring gzip on ties, and returns an empty string when identity should be used
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	wildcardQ := -1.0
+	explicit := map[string]float64{}
+	for _, coding := range parseQualityList(acceptEncoding) {
+		if coding.value == "*" {
+			wildcardQ = coding.q
+			continue
+		}
+		explicit[coding.value] = coding.q
+	}
+	for _, encoding := range []string{"gzip", "deflate"} {
+		q, listed := explicit[encoding]
+		if !listed {
+			if encoding == "gzip" {
+				q, listed = explicit["x-gzip"]
+			}
+			if !listed {
+				q = wildcardQ
+			}
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+type qualityValue struct {
+	value string
+	q     float64
+}
+
+// parseQualityList parses headers like Accept-Encoding made of comma separated values
+// with an optional ";q=" weight, values without one weigh 1
+func parseQualityList(header string) []qualityValue {
+	values := make([]qualityValue, 0)
+	for _, item := range strings.Split(header, ",") {
+		parts := strings.Split(item, ";")
+		value := strings.ToLower(strings.TrimSpace(parts[0]))
+		if value == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range parts[1:] {
+			name, raw, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if parsed, err := strconv.ParseFloat(raw, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		values = append(values, qualityValue{value: value, q: q})
+	}
+	return values
+}
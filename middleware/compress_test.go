@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"http-server/app/http"
+	"io"
+	nethttp "net/http"
+	"strings"
+	"testing"
+)
+
+func gunzip(t *testing.T, body []byte) string {
+	t.Helper()
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("reading gzip body: %v", err)
+	}
+	return string(decoded)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"x-gzip", "gzip"},
+		{"*", "gzip"},
+		{"gzip;q=0, *", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"br", ""},
+		{"identity", ""},
+	}
+	for _, test := range tests {
+		if got := negotiateEncoding(test.acceptEncoding); got != test.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", test.acceptEncoding, got, test.want)
+		}
+	}
+}
+
+func compressRouter(options CompressOptions, contentType string, body string) *http.Router {
+	router := http.NewRouter()
+	router.UseGlobalPreMiddlewares([]http.MiddlewareFunc{Compress(options)})
+	router.Get("/", func(req *http.Request, res *http.Response) {
+		res.HttpResponse(body, http.StatusOK)
+		res.SetHeader("Content-Type", contentType)
+	})
+	return router
+}
+
+func TestCompressBufferedResponse(t *testing.T) {
+	body := strings.Repeat("compressible text ", 200)
+	router := compressRouter(CompressOptions{}, "text/plain; charset=utf-8", body)
+
+	res := router.Dispatch(newTestRequest(t, "GET / HTTP/1.1\r\nHost: example.com\r\nAccept-Encoding: gzip, deflate\r\n\r\n"))
+	if res.GetHeader("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", res.GetHeader("Content-Encoding"))
+	}
+	if res.GetHeader("Vary") != "Accept-Encoding" {
+		t.Errorf("Vary = %q", res.GetHeader("Vary"))
+	}
+	if got := gunzip(t, []byte(res.GetBody())); got != body {
+		t.Error("decompressed body differs from the original")
+	}
+	if res.GetHeader("Content-Length") == "" || len(res.GetBody()) >= len(body) {
+		t.Errorf("Content-Length = %q for %d compressed bytes", res.GetHeader("Content-Length"), len(res.GetBody()))
+	}
+
+	res = router.Dispatch(newTestRequest(t, "GET / HTTP/1.1\r\nHost: example.com\r\nAccept-Encoding: deflate\r\n\r\n"))
+	reader, err := zlib.NewReader(strings.NewReader(res.GetBody()))
+	if err != nil {
+		t.Fatalf("Content-Encoding = %q, zlib reader: %v", res.GetHeader("Content-Encoding"), err)
+	}
+	if decoded, _ := io.ReadAll(reader); string(decoded) != body {
+		t.Error("inflated body differs from the original")
+	}
+
+	res = router.Dispatch(newTestRequest(t, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	if res.GetHeader("Content-Encoding") != "" || res.GetBody() != body {
+		t.Error("body compressed for a client not accepting it")
+	}
+	if res.GetHeader("Vary") != "Accept-Encoding" {
+		t.Errorf("Vary = %q on the identity response", res.GetHeader("Vary"))
+	}
+}
+
+func TestCompressSkipsSmallAndCompressedBodies(t *testing.T) {
+	request := "GET / HTTP/1.1\r\nHost: example.com\r\nAccept-Encoding: gzip\r\n\r\n"
+	small := compressRouter(CompressOptions{}, "text/plain", "short")
+	if res := small.Dispatch(newTestRequest(t, request)); res.GetHeader("Content-Encoding") != "" {
+		t.Error("body under MinSize compressed")
+	}
+	image := compressRouter(CompressOptions{}, "image/png", strings.Repeat("x", 4096))
+	if res := image.Dispatch(newTestRequest(t, request)); res.GetHeader("Content-Encoding") != "" {
+		t.Error("image compressed")
+	}
+}
+
+func TestCompressLevels(t *testing.T) {
+	body := strings.Repeat("a", 4096)
+	router := compressRouter(CompressOptions{Level: NoCompressionLevel}, "text/plain", body)
+	res := router.Dispatch(newTestRequest(t, "GET / HTTP/1.1\r\nHost: example.com\r\nAccept-Encoding: gzip\r\n\r\n"))
+	if res.GetHeader("Content-Encoding") != "gzip" || len(res.GetBody()) <= len(body) {
+		t.Errorf("stored gzip body of %d bytes for %d bytes", len(res.GetBody()), len(body))
+	}
+	if got := gunzip(t, []byte(res.GetBody())); got != body {
+		t.Error("stored body differs from the original")
+	}
+
+	for _, level := range []int{-4, 10} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Compress with level %d didn't panic", level)
+				}
+			}()
+			Compress(CompressOptions{Level: level})
+		}()
+	}
+}
+
+func TestCompressDecodesRequestBody(t *testing.T) {
+	var encoded bytes.Buffer
+	writer := gzip.NewWriter(&encoded)
+	io.WriteString(writer, "name=gopher")
+	writer.Close()
+
+	router := http.NewRouter()
+	router.UseGlobalPreMiddlewares([]http.MiddlewareFunc{Compress(CompressOptions{})})
+	router.Post("/", func(req *http.Request, res *http.Response) {
+		res.HttpResponse(string(req.GetBody())+" "+req.GetBodyParam("name"), http.StatusOK)
+	})
+	raw := "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Type: application/x-www-form-urlencoded\r\n" +
+		"Content-Encoding: gzip\r\n\r\n" + encoded.String()
+	res := router.Dispatch(newTestRequest(t, raw))
+	if res.GetBody() != "name=gopher gopher" {
+		t.Errorf("body = %q", res.GetBody())
+	}
+
+	raw = "POST / HTTP/1.1\r\nHost: example.com\r\nContent-Encoding: gzip\r\n\r\nnot gzip"
+	if res := router.Dispatch(newTestRequest(t, raw)); res.GetStatusCode() != http.StatusUnsupportedMediaType {
+		t.Errorf("status = %d for a corrupt body, want 415", res.GetStatusCode().Int())
+	}
+}
+
+func TestCompressStreamedResponse(t *testing.T) {
+	router := http.NewRouter()
+	router.UseGlobalPreMiddlewares([]http.MiddlewareFunc{Compress(CompressOptions{})})
+	router.Get("/events", func(req *http.Request, res *http.Response) {
+		res.Stream(http.StatusOK, "text/plain", func(w io.Writer) error {
+			for i := 0; i < 3; i++ {
+				if _, err := io.WriteString(w, "chunk\n"); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	baseURL := http.StartTestServer(t, router)
+
+	req, _ := nethttp.NewRequest("GET", baseURL+"/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	response, err := (&nethttp.Transport{DisableCompression: true}).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer response.Body.Close()
+	if response.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q", response.Header.Get("Content-Encoding"))
+	}
+	raw, _ := io.ReadAll(response.Body)
+	if got := gunzip(t, raw); got != "chunk\nchunk\nchunk\n" {
+		t.Errorf("body = %q", got)
+	}
+}
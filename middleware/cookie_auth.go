@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"http-server/app/http"
+)
+
+// SignedCookieAuth only lets requests through when they carry the named cookie
+// with a valid signature from keys, making it a stateless replacement for AuthMiddleware
+func SignedCookieAuth(cookieName string, keys *http.Keyring) http.MiddlewareFunc {
+	return func(req *http.Request, res *http.Response, next func()) {
+		if _, err := req.SignedCookie(cookieName, keys); err != nil {
+			res.HttpResponse("Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next()
+	}
+}
@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"http-server/app/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures the CORS middleware
+type CORSOptions struct {
+	// AllowedOrigins lists exact origins, "*" for any origin, or patterns
+	// with a single "*" wildcard such as "https://*.example.com"
+	AllowedOrigins []string
+	// AllowOriginFunc, when set, is consulted for origins not matched by AllowedOrigins
+	AllowOriginFunc func(origin string) bool
+	AllowedMethods  []string
+	AllowedHeaders  []string
+	ExposedHeaders  []string
+	// AllowCredentials can't be combined with the "*" origin, the allowed origins have to be
+	// listed or checked by AllowOriginFunc
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// DefaultCORSOptions are used by CORS for the methods and headers left empty
+var DefaultCORSOptions = CORSOptions{
+	AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"},
+	AllowedHeaders: []string{"Accept", "Accept-Language", "Content-Language", "Content-Type", "Authorization"},
+}
+
+// CORS answers preflight requests and adds the CORS headers to actual requests.
+// Preflights are sent with the OPTIONS method to paths that usually have no OPTIONS route,
+// so it has to be registered as a global pre-middleware of the application.
+// It panics when the "*" origin is allowed with credentials.
+func CORS(options CORSOptions) http.MiddlewareFunc {
+	if len(options.AllowedMethods) == 0 {
+		options.AllowedMethods = DefaultCORSOptions.AllowedMethods
+	}
+	if len(options.AllowedHeaders) == 0 {
+		options.AllowedHeaders = DefaultCORSOptions.AllowedHeaders
+	}
+	allowAll := false
+	for _, origin := range options.AllowedOrigins {
+		if origin == "*" {
+			allowAll = true
+		}
+	}
+	if allowAll && options.AllowCredentials {
+		panic(`cors: the "*" origin can't be allowed with credentials, list the origins or use AllowOriginFunc`)
+	}
+
+	allowOrigin := func(origin string) bool {
+		if allowAll {
+			return true
+		}
+		for _, allowed := range options.AllowedOrigins {
+			if matchOrigin(allowed, origin) {
+				return true
+			}
+		}
+		return options.AllowOriginFunc != nil && options.AllowOriginFunc(origin)
+	}
+
+	// With credentials the browser refuses "*", so the origins are always echoed back then
+	setOrigin := func(res *http.Response, origin string) {
+		if allowAll {
+			res.SetHeader("Access-Control-Allow-Origin", "*")
+		} else {
+			res.SetHeader("Access-Control-Allow-Origin", origin)
+			res.AppendHeader("Vary", "Origin")
+		}
+		if options.AllowCredentials {
+			res.SetHeader("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	return func(req *http.Request, res *http.Response, next func()) {
+		origin := req.GetHeader("Origin")
+		requestedMethod := req.GetHeader("Access-Control-Request-Method")
+
+		if req.GetMethod() == http.OPTIONS && origin != "" && requestedMethod != "" {
+			res.NoContent()
+			res.AppendHeader("Vary", "Origin")
+			res.AppendHeader("Vary", "Access-Control-Request-Method")
+			res.AppendHeader("Vary", "Access-Control-Request-Headers")
+			if !allowOrigin(origin) || !containsFold(options.AllowedMethods, requestedMethod) {
+				return
+			}
+			requestedHeaders := splitHeaderList(req.GetHeader("Access-Control-Request-Headers"))
+			for _, header := range requestedHeaders {
+				if !containsFold(options.AllowedHeaders, header) {
+					return
+				}
+			}
+			setOrigin(res, origin)
+			res.SetHeader("Access-Control-Allow-Methods", strings.Join(options.AllowedMethods, ", "))
+			if len(requestedHeaders) > 0 {
+				res.SetHeader("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+			}
+			if options.MaxAge > 0 {
+				res.SetHeader("Access-Control-Max-Age", strconv.Itoa(int(options.MaxAge.Seconds())))
+			}
+			return
+		}
+
+		if origin != "" {
+			if allowOrigin(origin) {
+				setOrigin(res, origin)
+				if len(options.ExposedHeaders) > 0 {
+					res.SetHeader("Access-Control-Expose-Headers", strings.Join(options.ExposedHeaders, ", "))
+				}
+			} else if !allowAll {
+				res.AppendHeader("Vary", "Origin")
+			}
+		}
+		next()
+	}
+}
+
+// matchOrigin compares an origin against an allowed origin that may contain one "*" wildcard
+func matchOrigin(allowed string, origin string) bool {
+	prefix, suffix, wildcard := strings.Cut(allowed, "*")
+	if !wildcard {
+		return strings.EqualFold(allowed, origin)
+	}
+	origin = strings.ToLower(origin)
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, strings.ToLower(prefix)) &&
+		strings.HasSuffix(origin, strings.ToLower(suffix))
+}
+
+func splitHeaderList(value string) []string {
+	values := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"http-server/app/http"
+	"strings"
+	"testing"
+)
+
+func corsRouter(options CORSOptions) *http.Router {
+	router := http.NewRouter()
+	router.UseGlobalPreMiddlewares([]http.MiddlewareFunc{CORS(options)})
+	router.Get("/", func(req *http.Request, res *http.Response) {
+		res.HttpResponse("ok", http.StatusOK)
+	})
+	return router
+}
+
+func corsRequest(t *testing.T, router *http.Router, method string, headers string) *http.Response {
+	t.Helper()
+	return router.Dispatch(newTestRequest(t, method+" / HTTP/1.1\r\nHost: api.example.com\r\n"+headers+"\r\n"))
+}
+
+func TestCORSPreflight(t *testing.T) {
+	router := corsRouter(CORSOptions{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true})
+
+	res := corsRequest(t, router, "OPTIONS", "Origin: https://app.example.com\r\nAccess-Control-Request-Method: PUT\r\nAccess-Control-Request-Headers: content-type\r\n")
+	if res.GetStatusCode() != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", res.GetStatusCode().Int())
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     strings.Join(DefaultCORSOptions.AllowedMethods, ", "),
+		"Access-Control-Allow-Headers":     "content-type",
+	} {
+		if got := res.GetHeader(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	for name, headers := range map[string]string{
+		"other origin":      "Origin: https://evil.com\r\nAccess-Control-Request-Method: GET\r\n",
+		"bare domain":       "Origin: https://example.com\r\nAccess-Control-Request-Method: GET\r\n",
+		"method not listed": "Origin: https://app.example.com\r\nAccess-Control-Request-Method: TRACE\r\n",
+		"header not listed": "Origin: https://app.example.com\r\nAccess-Control-Request-Method: GET\r\nAccess-Control-Request-Headers: X-Secret\r\n",
+	} {
+		if res := corsRequest(t, router, "OPTIONS", headers); res.GetHeader("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s: preflight allowed", name)
+		}
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	router := corsRouter(CORSOptions{AllowedOrigins: []string{"*"}, ExposedHeaders: []string{"X-Total"}})
+	res := corsRequest(t, router, "GET", "Origin: https://anywhere.test\r\n")
+	if res.GetBody() != "ok" || res.GetHeader("Access-Control-Allow-Origin") != "*" || res.GetHeader("Access-Control-Expose-Headers") != "X-Total" {
+		t.Errorf("body = %q, headers = %v", res.GetBody(), res.GetHeaders())
+	}
+
+	router = corsRouter(CORSOptions{AllowOriginFunc: func(origin string) bool { return origin == "https://trusted.test" }})
+	if res := corsRequest(t, router, "GET", "Origin: https://trusted.test\r\n"); res.GetHeader("Access-Control-Allow-Origin") != "https://trusted.test" {
+		t.Errorf("AllowOriginFunc ignored, headers = %v", res.GetHeaders())
+	}
+	res = corsRequest(t, router, "GET", "Origin: https://other.test\r\n")
+	if res.GetBody() != "ok" || res.GetHeader("Access-Control-Allow-Origin") != "" || res.GetHeader("Vary") != "Origin" {
+		t.Errorf("disallowed origin: body = %q, headers = %v", res.GetBody(), res.GetHeaders())
+	}
+}
+
+func TestCORSRejectsAnyOriginWithCredentials(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error(`"*" origin allowed with credentials`)
+		}
+	}()
+	CORS(CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+}
@@ -0,0 +1,246 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"http-server/app/http"
+	"net/url"
+	"strings"
+)
+
+const csrfTokenLength = 32
+
+type csrfContextKey struct{}
+
+// csrfExemptKey marks the routes set with CSRFExempt
+type csrfExemptKey struct{}
+
+// CSRFMode selects where the reference token is kept
+type CSRFMode int
+
+const (
+	// CSRFSynchronizer keeps the token in the session, the Session middleware has to run first
+	CSRFSynchronizer CSRFMode = iota
+	// CSRFDoubleSubmit keeps the token in a cookie the client must echo in the form or a header
+	CSRFDoubleSubmit
+)
+
+// CSRFOptions configures the CSRF middleware
+type CSRFOptions struct {
+	Mode       CSRFMode
+	FieldName  string
+	HeaderName string
+	// CookieName, Secure and Keys only apply to CSRFDoubleSubmit. Keys is required in that mode,
+	// the cookie is signed so a subdomain able to set cookies can't plant a token of its own
+	CookieName string
+	Secure     bool
+	Keys       *http.Keyring
+	// TrustedOrigins are origins other than the request host allowed to submit unsafe requests
+	TrustedOrigins []string
+	// ExemptPaths skips verification for exact paths, or for a path and everything below it
+	// when it ends with "*", so "/webhooks*" exempts "/webhooks/stripe" but not "/webhooksx".
+	// Single routes can be exempted with CSRFExempt.
+	ExemptPaths []string
+}
+
+// DefaultCSRFOptions are used by CSRF for every empty name of the given options
+var DefaultCSRFOptions = CSRFOptions{
+	FieldName:  "_csrf",
+	HeaderName: "X-CSRF-Token",
+	CookieName: "csrf_token",
+}
+
+// CSRFToken returns the token to embed in forms or send in the header, a fresh mask is
+// applied for every request so the token never appears twice in compressed pages
+func CSRFToken(req *http.Request) string {
+	token, _ := req.Context().Value(csrfContextKey{}).([]byte)
+	if token == nil {
+		return ""
+	}
+	return maskCSRFToken(token)
+}
+
+// CSRFExempt skips CSRF verification for route, for webhooks and other endpoints called by servers
+func CSRFExempt(route *http.Route) *http.Route {
+	return route.WithValue(csrfExemptKey{}, true)
+}
+
+// CSRF rejects POST, PUT, PATCH and DELETE requests that don't carry the CSRF token
+// in the form field or header, or that come from a foreign Origin or Referer.
+// It panics when CSRFDoubleSubmit is used without Keys.
+func CSRF(options CSRFOptions) http.MiddlewareFunc {
+	if options.Mode == CSRFDoubleSubmit && options.Keys == nil {
+		panic("csrf: the double-submit mode needs Keys to sign its cookie")
+	}
+	if options.FieldName == "" {
+		options.FieldName = DefaultCSRFOptions.FieldName
+	}
+	if options.HeaderName == "" {
+		options.HeaderName = DefaultCSRFOptions.HeaderName
+	}
+	if options.CookieName == "" {
+		options.CookieName = DefaultCSRFOptions.CookieName
+	}
+
+	return func(req *http.Request, res *http.Response, next func()) {
+		token, ok := csrfReferenceToken(req, res, options)
+		if !ok {
+			res.ErrorResponse(http.StatusInternalServerError, "CSRF protection needs the Session middleware")
+			return
+		}
+		req.WithValue(csrfContextKey{}, token)
+
+		exempt, _ := req.RouteValue(csrfExemptKey{}).(bool)
+		if exempt || isSafeMethod(req.GetMethod()) || isExemptPath(options.ExemptPaths, req.GetPath()) {
+			next()
+			return
+		}
+		if !sameOrigin(req, options.TrustedOrigins) {
+			res.ErrorResponse(http.StatusForbidden, "cross-origin request rejected")
+			return
+		}
+		submitted := req.GetHeader(options.HeaderName)
+		if submitted == "" {
+			submitted = req.GetBodyParam(options.FieldName)
+		}
+		unmasked, ok := unmaskCSRFToken(submitted)
+		if !ok && options.Mode == CSRFDoubleSubmit {
+			unmasked, ok = cookieCSRFToken(submitted)
+		}
+		if !ok || subtle.ConstantTimeCompare(unmasked, token) != 1 {
+			res.ErrorResponse(http.StatusForbidden, "invalid CSRF token")
+			return
+		}
+		next()
+	}
+}
+
+// csrfReferenceToken returns the token submitted values are checked against, creating it when needed
+func csrfReferenceToken(req *http.Request, res *http.Response, options CSRFOptions) ([]byte, bool) {
+	if options.Mode == CSRFSynchronizer {
+		session := GetSession(req)
+		if session == nil {
+			return nil, false
+		}
+		if encoded, ok := session.Get("_csrf_token").(string); ok {
+			if token, err := base64.RawURLEncoding.DecodeString(encoded); err == nil && len(token) == csrfTokenLength {
+				return token, true
+			}
+		}
+		token := newCSRFToken()
+		session.Set("_csrf_token", base64.RawURLEncoding.EncodeToString(token))
+		return token, true
+	}
+
+	if cookie, err := req.SignedCookie(options.CookieName, options.Keys); err == nil {
+		if token, err := base64.RawURLEncoding.DecodeString(cookie.Value); err == nil && len(token) == csrfTokenLength {
+			return token, true
+		}
+	}
+
+	// The cookie is readable by scripts so they can echo the token in the header
+	token := newCSRFToken()
+	cookie := &http.Cookie{
+		Name:     options.CookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(token),
+		Path:     "/",
+		Secure:   options.Secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	res.SetSignedCookie(cookie, options.Keys)
+	return token, true
+}
+
+func isSafeMethod(method http.Method) bool {
+	return method == http.GET || method == http.HEAD || method == http.OPTIONS
+}
+
+// isExemptPath matches path against the exempt paths, prefixes only match whole segments
+func isExemptPath(exemptPaths []string, path string) bool {
+	for _, exempt := range exemptPaths {
+		if prefix, ok := strings.CutSuffix(exempt, "*"); ok {
+			prefix = strings.TrimSuffix(prefix, "/")
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				return true
+			}
+		} else if exempt == path {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin checks the Origin header, or the Referer when the browser didn't send one,
+// against the Host of the request and the trusted origins. Requests without both are let through
+// to the token check since non-browser clients don't send them.
+func sameOrigin(req *http.Request, trustedOrigins []string) bool {
+	source := req.GetHeader("Origin")
+	if source == "" {
+		source = req.GetHeader("Referer")
+		if source == "" {
+			return true
+		}
+	}
+	if source == "null" {
+		return false
+	}
+	parsed, err := url.Parse(source)
+	if err != nil || parsed.Host == "" {
+		return false
+	}
+	if strings.EqualFold(parsed.Host, req.Host()) {
+		return true
+	}
+	origin := parsed.Scheme + "://" + parsed.Host
+	for _, trusted := range trustedOrigins {
+		if matchOrigin(trusted, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// cookieCSRFToken decodes a token copied from the double-submit cookie by a script,
+// the signature of signed cookies is ignored since the token is compared to the verified cookie
+func cookieCSRFToken(value string) ([]byte, bool) {
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		value = value[:i]
+	}
+	token, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(token) != csrfTokenLength {
+		return nil, false
+	}
+	return token, true
+}
+
+func newCSRFToken() []byte {
+	token := make([]byte, csrfTokenLength)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	return token
+}
+
+// maskCSRFToken returns base64(pad + (pad XOR token)) with a random pad
+func maskCSRFToken(token []byte) string {
+	pad := newCSRFToken()
+	masked := make([]byte, 2*csrfTokenLength)
+	copy(masked, pad)
+	for i := range token {
+		masked[csrfTokenLength+i] = pad[i] ^ token[i]
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+func unmaskCSRFToken(masked string) ([]byte, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(masked)
+	if err != nil || len(raw) != 2*csrfTokenLength {
+		return nil, false
+	}
+	token := make([]byte, csrfTokenLength)
+	for i := range token {
+		token[i] = raw[i] ^ raw[csrfTokenLength+i]
+	}
+	return token, true
+}
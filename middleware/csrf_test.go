@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"http-server/app/http"
+	nethttp "net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// csrfTestServer serves the CSRF token on GET /token and accepts posts on the other routes
+func csrfTestServer(t *testing.T, options CSRFOptions) string {
+	t.Helper()
+	store := NewMemoryStore(time.Minute)
+	t.Cleanup(store.Close)
+	ok := func(req *http.Request, res *http.Response) {
+		res.HttpResponse("ok", http.StatusOK)
+	}
+	router := http.NewRouter()
+	router.UseGlobalPreMiddlewares([]http.MiddlewareFunc{Session(store, SessionOptions{}), CSRF(options)})
+	router.Get("/token", func(req *http.Request, res *http.Response) {
+		res.HttpResponse(CSRFToken(req), http.StatusOK)
+	})
+	router.Post("/form", ok)
+	router.Post("/hooks", ok)
+	router.Post("/hooks/stripe", ok)
+	router.Post("/hooksx", ok)
+	CSRFExempt(router.Post("/callback", ok))
+	return http.StartTestServer(t, router)
+}
+
+func postWith(t *testing.T, client *nethttp.Client, url string, headers map[string]string) int {
+	t.Helper()
+	req, _ := nethttp.NewRequest("POST", url, strings.NewReader(""))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	response.Body.Close()
+	return response.StatusCode
+}
+
+func TestCSRFModes(t *testing.T) {
+	for name, options := range map[string]CSRFOptions{
+		"synchronizer":  {},
+		"double submit": {Mode: CSRFDoubleSubmit, Keys: http.NewKeyring([]byte("secret"))},
+	} {
+		baseURL := csrfTestServer(t, options)
+		client := newCookieClient(t)
+		_, token := getBody(t, client, baseURL+"/token")
+		if token == "" {
+			t.Fatalf("%s: no token", name)
+		}
+		if _, again := getBody(t, client, baseURL+"/token"); again == token {
+			t.Errorf("%s: token served twice with the same mask", name)
+		}
+
+		// A character in the middle is replaced, the padding bits of the last ones could leave the token unchanged
+		replacement := "A"
+		if token[10] == 'A' {
+			replacement = "B"
+		}
+		wrongToken := token[:10] + replacement + token[11:]
+		tests := []struct {
+			name    string
+			headers map[string]string
+			want    int
+		}{
+			{"token", map[string]string{"X-CSRF-Token": token}, nethttp.StatusOK},
+			{"token from the same origin", map[string]string{"X-CSRF-Token": token, "Origin": baseURL}, nethttp.StatusOK},
+			{"no token", nil, nethttp.StatusForbidden},
+			{"wrong token", map[string]string{"X-CSRF-Token": wrongToken}, nethttp.StatusForbidden},
+			{"foreign origin", map[string]string{"X-CSRF-Token": token, "Origin": "https://evil.example"}, nethttp.StatusForbidden},
+			{"foreign referer", map[string]string{"X-CSRF-Token": token, "Referer": "https://evil.example/page"}, nethttp.StatusForbidden},
+		}
+		for _, test := range tests {
+			if status := postWith(t, client, baseURL+"/form", test.headers); status != test.want {
+				t.Errorf("%s, %s: status = %d, want %d", name, test.name, status, test.want)
+			}
+		}
+		if status := postWith(t, newCookieClient(t), baseURL+"/form", map[string]string{"X-CSRF-Token": token}); status != nethttp.StatusForbidden {
+			t.Errorf("%s: token accepted from another client, status = %d", name, status)
+		}
+	}
+}
+
+func TestCSRFDoubleSubmitRejectsUnsignedCookie(t *testing.T) {
+	baseURL := csrfTestServer(t, CSRFOptions{Mode: CSRFDoubleSubmit, Keys: http.NewKeyring([]byte("secret"))})
+	token := base64.RawURLEncoding.EncodeToString([]byte(strings.Repeat("x", csrfTokenLength)))
+	req, _ := nethttp.NewRequest("POST", baseURL+"/form", nil)
+	req.AddCookie(&nethttp.Cookie{Name: "csrf_token", Value: token})
+	req.Header.Set("X-CSRF-Token", token)
+	response, err := nethttp.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != nethttp.StatusForbidden {
+		t.Errorf("planted cookie accepted, status = %d", response.StatusCode)
+	}
+}
+
+func TestCSRFExemptions(t *testing.T) {
+	baseURL := csrfTestServer(t, CSRFOptions{ExemptPaths: []string{"/hooks*"}})
+	client := newCookieClient(t)
+	for path, want := range map[string]int{
+		"/callback":     nethttp.StatusOK,
+		"/hooks":        nethttp.StatusOK,
+		"/hooks/stripe": nethttp.StatusOK,
+		"/hooksx":       nethttp.StatusForbidden,
+		"/form":         nethttp.StatusForbidden,
+	} {
+		if status := postWith(t, client, baseURL+path, nil); status != want {
+			t.Errorf("%s: status = %d, want %d", path, status, want)
+		}
+	}
+}
+
+func TestCSRFDoubleSubmitNeedsKeys(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("double-submit mode without Keys didn't panic")
+		}
+	}()
+	CSRF(CSRFOptions{Mode: CSRFDoubleSubmit})
+}
@@ -5,6 +5,9 @@ import (
 	"log"
 )
 
+// LoggerMiddleware prints the method, path and status of the requests reaching it.
+//
+// Deprecated: use AccessLog, which also logs the requests rejected by other middlewares.
 func LoggerMiddleware(req *http.Request, res *http.Response, next func()) {
 	log.Print(req.GetMethod(), " ", req.GetPath(), " ", res.GetStatusCode().Int(), " ", res.GetStatusCode())
 	next()
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"http-server/app/http"
+	"time"
+)
+
+// ETagOptions configures the ETag middleware
+type ETagOptions struct {
+	// Weak generates weak ETags, for representations that may differ byte for byte
+	// while being equivalent, like JSON with a varying key order
+	Weak bool
+	// RequirePreconditions answers PUT, PATCH and DELETE requests carrying neither
+	// If-Match nor If-Unmodified-Since with a 428, forcing clients to use optimistic concurrency
+	RequirePreconditions bool
+}
+
+// ETag adds an ETag computed from the body to buffered successful responses to GET and HEAD
+// requests, and turns them into a 304 when the client already has that version.
+// Handlers of unsafe methods check If-Match themselves with Response.CheckPreconditions,
+// since the current version of the resource must be known before it is modified.
+//
+// BeforeWrite hooks run in reverse order of registration, so when ETag is listed before Compress
+// the tag is computed on the compressed body and differs per encoding, as it should. Listed after
+// Compress it is computed on the identity body, which Compress then downgrades to a weak ETag.
+func ETag(options ETagOptions) http.MiddlewareFunc {
+	return func(req *http.Request, res *http.Response, next func()) {
+		method := req.GetMethod()
+		if method != http.GET && method != http.HEAD {
+			if options.RequirePreconditions && (method == http.PUT || method == http.PATCH || method == http.DELETE) &&
+				req.GetHeader("If-Match") == "" && req.GetHeader("If-Unmodified-Since") == "" {
+				res.ErrorResponse(http.StatusPreconditionRequired, "this request must be conditional, send If-Match")
+				return
+			}
+			next()
+			return
+		}
+
+		res.BeforeWrite(func() {
+			if res.GetStatusCode() != http.StatusOK || res.IsStreaming() {
+				return
+			}
+			etag := res.GetHeader("ETag")
+			if etag == "" {
+				etag = http.ComputeETag(res.GetBody(), options.Weak)
+			}
+			var modTime time.Time
+			if lastModified := res.GetHeader("Last-Modified"); lastModified != "" {
+				modTime, _ = time.Parse(http.TimeFormat, lastModified)
+			}
+			res.CheckPreconditions(req, etag, modTime)
+		})
+		next()
+	}
+}
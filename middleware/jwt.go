@@ -0,0 +1,323 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"http-server/app/http"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken   = errors.New("jwt: malformed token")
+	ErrUnknownKey       = errors.New("jwt: no key matches the token")
+	ErrTokenSignature   = errors.New("jwt: invalid signature")
+	ErrTokenExpired     = errors.New("jwt: token is expired")
+	ErrTokenNoExpiry    = errors.New("jwt: token has no expiry")
+	ErrTokenNotYetValid = errors.New("jwt: token is not valid yet")
+	ErrTokenIssuer      = errors.New("jwt: unexpected issuer")
+	ErrTokenAudience    = errors.New("jwt: unexpected audience")
+)
+
+// JWK is a single key of a JSON Web Key Set, only the fields needed for
+// HS256 ("oct"), RS256 ("RSA") and ES256 ("EC" on P-256) are supported
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	key interface{}
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// LoadJWKS reads and decodes a JSON Web Key Set from a local file
+func LoadJWKS(path string) (*JWKS, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(raw)
+}
+
+// ParseJWKS decodes a JSON Web Key Set, failing on keys it cannot use
+func ParseJWKS(raw []byte) (*JWKS, error) {
+	set := &JWKS{}
+	if err := json.Unmarshal(raw, set); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+	for _, jwk := range set.Keys {
+		if err := jwk.decode(); err != nil {
+			return nil, fmt.Errorf("decoding key %q: %w", jwk.Kid, err)
+		}
+	}
+	return set, nil
+}
+
+func (k *JWK) decode() error {
+	switch k.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return err
+		}
+		// Shorter secrets can be guessed, an empty one would let anybody sign tokens, RFC 7518 section 3.2
+		if len(secret) < sha256.Size {
+			return errors.New("HS256 keys must be at least 256 bits long")
+		}
+		k.key = secret
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return err
+		}
+		k.key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case "EC":
+		if k.Crv != "P-256" {
+			return fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return errors.New("point is not on curve P-256")
+		}
+		k.key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	default:
+		return fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	return nil
+}
+
+// algorithm returns the JWS algorithm the key can verify
+func (k *JWK) algorithm() string {
+	switch k.Kty {
+	case "oct":
+		return "HS256"
+	case "RSA":
+		return "RS256"
+	default:
+		return "ES256"
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// JWTVerifier is a TokenVerifier for signed JSON Web Tokens. The signing algorithm is
+// derived from the key type, never from the token header, so tokens can't downgrade it.
+type JWTVerifier struct {
+	Keys     *JWKS
+	Issuer   string
+	Audience string
+	// Leeway tolerates clock skew when checking exp and nbf
+	Leeway time.Duration
+	// AllowMissingExp accepts tokens without an exp claim, they never expire.
+	// By default such tokens are rejected with ErrTokenNoExpiry.
+	AllowMissingExp bool
+	// RolesClaim and PermissionsClaim name the claims mapped onto the principal,
+	// they default to "roles" and "scope"
+	RolesClaim       string
+	PermissionsClaim string
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks the signature and the registered claims of token
+func (v *JWTVerifier) Verify(token string) (*http.Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrMalformedToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, jwk := range v.Keys.Keys {
+		if header.Kid != "" && jwk.Kid != header.Kid {
+			continue
+		}
+		if jwk.algorithm() != header.Alg || (jwk.Alg != "" && jwk.Alg != header.Alg) {
+			continue
+		}
+		if verifySignature(jwk, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		if !v.hasKeyFor(header) {
+			return nil, ErrUnknownKey
+		}
+		return nil, ErrTokenSignature
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return v.principal(claims), nil
+}
+
+func (v *JWTVerifier) hasKeyFor(header jwtHeader) bool {
+	for _, jwk := range v.Keys.Keys {
+		if (header.Kid == "" || jwk.Kid == header.Kid) && jwk.algorithm() == header.Alg {
+			return true
+		}
+	}
+	return false
+}
+
+func verifySignature(jwk *JWK, signed []byte, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch key := jwk.key.(type) {
+	case []byte:
+		h := hmac.New(sha256.New, key)
+		h.Write(signed)
+		return hmac.Equal(signature, h.Sum(nil))
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case *ecdsa.PublicKey:
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	default:
+		return false
+	}
+}
+
+func (v *JWTVerifier) validateClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	switch exp := claims["exp"].(type) {
+	case float64:
+		if now.After(time.Unix(int64(exp), 0).Add(v.Leeway)) {
+			return ErrTokenExpired
+		}
+	case nil:
+		if !v.AllowMissingExp {
+			return ErrTokenNoExpiry
+		}
+	default:
+		return ErrMalformedToken
+	}
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Add(v.Leeway).Before(time.Unix(int64(nbf), 0)) {
+			return ErrTokenNotYetValid
+		}
+	}
+	if v.Issuer != "" && claims["iss"] != v.Issuer {
+		return ErrTokenIssuer
+	}
+	if v.Audience != "" && !containsClaim(claims["aud"], v.Audience) {
+		return ErrTokenAudience
+	}
+	return nil
+}
+
+func (v *JWTVerifier) principal(claims map[string]interface{}) *http.Principal {
+	rolesClaim := v.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	permissionsClaim := v.PermissionsClaim
+	if permissionsClaim == "" {
+		permissionsClaim = "scope"
+	}
+	subject, _ := claims["sub"].(string)
+	return &http.Principal{
+		ID:          subject,
+		Scheme:      "Bearer",
+		Roles:       stringsClaim(claims[rolesClaim]),
+		Permissions: stringsClaim(claims[permissionsClaim]),
+		Claims:      claims,
+	}
+}
+
+// stringsClaim reads a claim that is either a space separated string (like "scope") or an array of strings
+func stringsClaim(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+func containsClaim(claim interface{}, expected string) bool {
+	if s, ok := claim.(string); ok {
+		return s == expected
+	}
+	for _, value := range stringsClaim(claim) {
+		if value == expected {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
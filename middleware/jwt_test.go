@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testHMACSecret = []byte("0123456789abcdef0123456789abcdef")
+	testRSAKey, _  = rsa.GenerateKey(rand.Reader, 2048)
+	testECKey, _   = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// testJWKS holds the public halves of the test keys
+func testJWKS(t *testing.T) *JWKS {
+	t.Helper()
+	raw := fmt.Sprintf(`{"keys": [
+		{"kty": "oct", "kid": "hmac", "k": %q},
+		{"kty": "RSA", "kid": "rsa", "n": %q, "e": %q},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": %q, "y": %q}
+	]}`,
+		b64(testHMACSecret),
+		b64(testRSAKey.N.Bytes()), b64(big.NewInt(int64(testRSAKey.E)).Bytes()),
+		b64(testECKey.X.FillBytes(make([]byte, 32))), b64(testECKey.Y.FillBytes(make([]byte, 32))))
+	keys, err := ParseJWKS([]byte(raw))
+	if err != nil {
+		t.Fatalf("ParseJWKS: %v", err)
+	}
+	return keys
+}
+
+// signToken builds a token signed with alg by the test key kid
+func signToken(t *testing.T, alg string, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "HS256":
+		h := hmac.New(sha256.New, testHMACSecret)
+		h.Write([]byte(signed))
+		signature = h.Sum(nil)
+	case "RS256":
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, testRSAKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, testECKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case "none":
+	}
+	return signed + "." + b64(signature)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "user-1",
+		"iss":   "https://issuer.example",
+		"aud":   []string{"api", "other"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"admin"},
+		"scope": "orders:read orders:write",
+	}
+}
+
+func TestJWTVerifierAlgorithms(t *testing.T) {
+	verifier := &JWTVerifier{Keys: testJWKS(t), Issuer: "https://issuer.example", Audience: "api"}
+	for _, test := range []struct{ alg, kid string }{{"HS256", "hmac"}, {"RS256", "rsa"}, {"ES256", "ec"}, {"ES256", ""}} {
+		principal, err := verifier.Verify(signToken(t, test.alg, test.kid, validClaims()))
+		if err != nil {
+			t.Errorf("%s: %v", test.alg, err)
+			continue
+		}
+		if principal.ID != "user-1" || !principal.HasRole("admin") || !principal.HasPermission("orders:write") || principal.Scheme != "Bearer" {
+			t.Errorf("%s: principal = %+v", test.alg, principal)
+		}
+	}
+}
+
+func TestJWTVerifierRejectsTokens(t *testing.T) {
+	verifier := &JWTVerifier{Keys: testJWKS(t), Issuer: "https://issuer.example", Audience: "api"}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := validClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	valid := signToken(t, "HS256", "hmac", validClaims())
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"expired", signToken(t, "HS256", "hmac", with("exp", time.Now().Add(-time.Minute).Unix())), ErrTokenExpired},
+		{"without exp", signToken(t, "HS256", "hmac", with("exp", nil)), ErrTokenNoExpiry},
+		{"exp not a number", signToken(t, "HS256", "hmac", with("exp", "tomorrow")), ErrMalformedToken},
+		{"not valid yet", signToken(t, "HS256", "hmac", with("nbf", time.Now().Add(time.Hour).Unix())), ErrTokenNotYetValid},
+		{"other issuer", signToken(t, "HS256", "hmac", with("iss", "https://evil.example")), ErrTokenIssuer},
+		{"other audience", signToken(t, "HS256", "hmac", with("aud", "web")), ErrTokenAudience},
+		{"tampered payload", parts[0] + "." + b64([]byte(`{"sub":"admin"}`)) + "." + parts[2], ErrTokenSignature},
+		{"alg none", signToken(t, "none", "hmac", validClaims()), ErrUnknownKey},
+		{"alg not matching the key", signToken(t, "HS256", "rsa", validClaims()), ErrUnknownKey},
+		{"unknown kid", signToken(t, "HS256", "missing", validClaims()), ErrUnknownKey},
+		{"two segments", parts[0] + "." + parts[1], ErrMalformedToken},
+		{"garbage", "a.b.c", ErrMalformedToken},
+	}
+	for _, test := range tests {
+		if _, err := verifier.Verify(test.token); err != test.want {
+			t.Errorf("%s: error = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestJWTVerifierOptions(t *testing.T) {
+	claims := validClaims()
+	delete(claims, "exp")
+	claims["nbf"] = time.Now().Add(20 * time.Second).Unix()
+	claims["groups"] = []string{"staff"}
+	verifier := &JWTVerifier{Keys: testJWKS(t), AllowMissingExp: true, Leeway: time.Minute, RolesClaim: "groups"}
+	principal, err := verifier.Verify(signToken(t, "RS256", "rsa", claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !principal.HasRole("staff") || principal.HasRole("admin") {
+		t.Errorf("roles = %v, want the groups claim", principal.Roles)
+	}
+}
+
+func TestParseJWKSRejectsUnusableKeys(t *testing.T) {
+	for name, raw := range map[string]string{
+		"empty oct key":     `{"keys": [{"kty": "oct", "k": ""}]}`,
+		"short oct key":     `{"keys": [{"kty": "oct", "k": "c2hvcnQ"}]}`,
+		"unsupported curve": `{"keys": [{"kty": "EC", "crv": "P-384", "x": "AA", "y": "AA"}]}`,
+		"point off curve":   `{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`,
+		"unknown type":      `{"keys": [{"kty": "OKP"}]}`,
+		"not JSON":          `keys`,
+	} {
+		if _, err := ParseJWKS([]byte(raw)); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
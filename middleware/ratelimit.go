@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"fmt"
+	"http-server/app/http"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Algorithm selects how a rate limit counts requests
+type Algorithm int
+
+const (
+	// TokenBucket refills Requests tokens per Window and allows bursts up to Burst
+	TokenBucket Algorithm = iota
+	// SlidingWindow allows Requests per rolling Window, weighting the previous window
+	SlidingWindow
+)
+
+// Limit describes how many requests a client can make
+type Limit struct {
+	Requests  int
+	Window    time.Duration
+	Algorithm Algorithm
+	// Burst is the token bucket capacity, it defaults to Requests
+	Burst int
+}
+
+// RateLimitResult is the decision of a store for one request
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the counters of every client. The memory store is the default,
+// implementations backed by an external service let several servers share limits.
+type RateLimitStore interface {
+	Take(key string, limit Limit, now time.Time) (RateLimitResult, error)
+}
+
+// KeyFunc extracts the client identity a limit applies to, an empty key skips the limit
+type KeyFunc func(req *http.Request) string
+
+// KeyByIP limits clients by their IP address, the one behind trusted proxies included
+func KeyByIP(req *http.Request) string {
+	return req.ClientIP()
+}
+
+// KeyByHeader limits clients by the value of a header such as an API key
+func KeyByHeader(header string) KeyFunc {
+	return func(req *http.Request) string {
+		return req.GetHeader(header)
+	}
+}
+
+// KeyByPrincipal limits clients by their authenticated identity, falling back to the IP address
+func KeyByPrincipal(req *http.Request) string {
+	if principal := req.Principal(); principal != nil {
+		return "principal:" + principal.ID
+	}
+	return "ip:" + KeyByIP(req)
+}
+
+// RateLimitOptions configures the RateLimit middleware
+type RateLimitOptions struct {
+	Store RateLimitStore
+	Key   KeyFunc
+	// Name separates the counters of limits sharing a store, a unique one is generated when empty
+	Name string
+}
+
+var (
+	rateLimitCount atomic.Int64
+
+	// defaultRateLimitStore is shared by every limit that doesn't set its own store
+	defaultRateLimitStore     *MemoryRateLimitStore
+	defaultRateLimitStoreOnce sync.Once
+)
+
+// RateLimit rejects requests over limit with a 429. Used on a route it limits that route,
+// used as a router pre-middleware the limit is shared by every route of the group.
+// It panics when the limit doesn't allow at least one request per positive window.
+func RateLimit(limit Limit, options RateLimitOptions) http.MiddlewareFunc {
+	if limit.Requests <= 0 || limit.Window <= 0 || limit.Burst < 0 {
+		panic(fmt.Sprintf("rate limit: invalid limit of %d requests per %v", limit.Requests, limit.Window))
+	}
+	if options.Store == nil {
+		defaultRateLimitStoreOnce.Do(func() {
+			defaultRateLimitStore = NewMemoryRateLimitStore(time.Minute)
+		})
+		options.Store = defaultRateLimitStore
+	}
+	if options.Key == nil {
+		options.Key = KeyByIP
+	}
+	if options.Name == "" {
+		options.Name = "limit" + strconv.FormatInt(rateLimitCount.Add(1), 10)
+	}
+	if limit.Burst == 0 {
+		limit.Burst = limit.Requests
+	}
+	policy := fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Window.Seconds()))
+
+	return func(req *http.Request, res *http.Response, next func()) {
+		key := options.Key(req)
+		if key == "" {
+			next()
+			return
+		}
+		result, err := options.Store.Take(options.Name+":"+key, limit, time.Now())
+		if err != nil {
+			// Fail open, an unavailable store must not take the whole API down
+			log.Printf("Error taking rate limit: %v", err)
+			next()
+			return
+		}
+		if !result.Allowed {
+			res.ErrorResponse(http.StatusTooManyRequests, "rate limit exceeded")
+			res.SetHeader("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		}
+		res.SetHeader("RateLimit-Policy", policy)
+		res.SetHeader("RateLimit-Limit", strconv.Itoa(result.Limit))
+		res.SetHeader("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		res.SetHeader("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if result.Allowed {
+			next()
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+const rateLimitShards = 32
+
+type rateLimitEntry struct {
+	// token bucket state
+	tokens     float64
+	lastRefill time.Time
+
+	// sliding window state
+	windowStart   time.Time
+	previousCount int
+	currentCount  int
+
+	lastSeen time.Time
+	window   time.Duration
+}
+
+type rateLimitShard struct {
+	mu      sync.Mutex
+	entries map[string]*rateLimitEntry
+}
+
+// MemoryRateLimitStore keeps counters in memory, split into shards each with its own lock
+// so concurrent clients rarely contend
+type MemoryRateLimitStore struct {
+	shards [rateLimitShards]*rateLimitShard
+	done   chan struct{}
+}
+
+// NewMemoryRateLimitStore creates a store forgetting idle clients every cleanupInterval,
+// or every minute when it isn't positive
+func NewMemoryRateLimitStore(cleanupInterval time.Duration) *MemoryRateLimitStore {
+	if cleanupInterval <= 0 {
+		cleanupInterval = defaultCleanupInterval
+	}
+	store := &MemoryRateLimitStore{done: make(chan struct{})}
+	for i := range store.shards {
+		store.shards[i] = &rateLimitShard{entries: make(map[string]*rateLimitEntry)}
+	}
+	go store.evictLoop(cleanupInterval)
+	return store
+}
+
+func (s *MemoryRateLimitStore) shard(key string) *rateLimitShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%rateLimitShards]
+}
+
+func (s *MemoryRateLimitStore) Take(key string, limit Limit, now time.Time) (RateLimitResult, error) {
+	shard := s.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, exists := shard.entries[key]
+	if !exists {
+		entry = &rateLimitEntry{
+			tokens:      float64(limit.Burst),
+			lastRefill:  now,
+			windowStart: now.Truncate(limit.Window),
+			window:      limit.Window,
+		}
+		shard.entries[key] = entry
+	}
+	entry.lastSeen = now
+
+	if limit.Algorithm == SlidingWindow {
+		return takeSlidingWindow(entry, limit, now), nil
+	}
+	return takeTokenBucket(entry, limit, now), nil
+}
+
+func takeTokenBucket(entry *rateLimitEntry, limit Limit, now time.Time) RateLimitResult {
+	perToken := limit.Window / time.Duration(limit.Requests)
+	elapsed := now.Sub(entry.lastRefill)
+	entry.tokens = math.Min(float64(limit.Burst), entry.tokens+float64(elapsed)/float64(perToken))
+	entry.lastRefill = now
+
+	result := RateLimitResult{Limit: limit.Burst}
+	if entry.tokens >= 1 {
+		entry.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - entry.tokens) * float64(perToken))
+	}
+	result.Remaining = int(entry.tokens)
+	result.Reset = time.Duration((float64(limit.Burst) - entry.tokens) * float64(perToken))
+	return result
+}
+
+func takeSlidingWindow(entry *rateLimitEntry, limit Limit, now time.Time) RateLimitResult {
+	start := now.Truncate(limit.Window)
+	if !start.Equal(entry.windowStart) {
+		if start.Sub(entry.windowStart) == limit.Window {
+			entry.previousCount = entry.currentCount
+		} else {
+			entry.previousCount = 0
+		}
+		entry.currentCount = 0
+		entry.windowStart = start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(limit.Window)
+	estimate := float64(entry.previousCount)*weight + float64(entry.currentCount)
+
+	result := RateLimitResult{Limit: limit.Requests, Reset: limit.Window - elapsed}
+	if estimate+1 <= float64(limit.Requests) {
+		entry.currentCount++
+		estimate++
+		result.Allowed = true
+	} else if entry.previousCount > 0 && entry.currentCount < limit.Requests {
+		// Wait until the previous window weighs little enough to let one more request in
+		free := float64(limit.Requests-entry.currentCount-1) / float64(entry.previousCount)
+		result.RetryAfter = time.Duration((1-free)*float64(limit.Window)) - elapsed
+	} else {
+		result.RetryAfter = limit.Window - elapsed
+	}
+	result.Remaining = int(math.Max(0, float64(limit.Requests)-estimate))
+	return result
+}
+
+// Close stops the background eviction
+func (s *MemoryRateLimitStore) Close() {
+	close(s.done)
+}
+
+func (s *MemoryRateLimitStore) evictLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.evictIdle(now)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// evictIdle forgets clients that have been quiet long enough for their counters to be back to full
+func (s *MemoryRateLimitStore) evictIdle(now time.Time) {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		for key, entry := range shard.entries {
+			if now.Sub(entry.lastSeen) > 2*entry.window {
+				delete(shard.entries, key)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"http-server/app/http"
+	"testing"
+	"time"
+)
+
+func newTestRequest(t *testing.T, raw string) *http.Request {
+	t.Helper()
+	req, err := http.ParseToRequest([]byte(raw))
+	if err != nil {
+		t.Fatalf("parsing request: %v", err)
+	}
+	return req
+}
+
+func TestTokenBucketAllowsBurstThenRefills(t *testing.T) {
+	store := NewMemoryRateLimitStore(time.Minute)
+	defer store.Close()
+	limit := Limit{Requests: 3, Window: time.Minute, Burst: 3}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		result, _ := store.Take("client", limit, now)
+		if !result.Allowed {
+			t.Fatalf("request %d denied", i+1)
+		}
+		if result.Remaining != 2-i {
+			t.Errorf("request %d: remaining = %d, want %d", i+1, result.Remaining, 2-i)
+		}
+	}
+	result, _ := store.Take("client", limit, now)
+	if result.Allowed {
+		t.Fatal("request over the burst allowed")
+	}
+	if result.RetryAfter != 20*time.Second {
+		t.Errorf("retry after = %v, want 20s", result.RetryAfter)
+	}
+
+	result, _ = store.Take("client", limit, now.Add(20*time.Second))
+	if !result.Allowed {
+		t.Error("request denied once a token was refilled")
+	}
+	result, _ = store.Take("other", limit, now)
+	if !result.Allowed {
+		t.Error("limit shared between keys")
+	}
+}
+
+func TestSlidingWindowWeighsPreviousWindow(t *testing.T) {
+	store := NewMemoryRateLimitStore(time.Minute)
+	defer store.Close()
+	limit := Limit{Requests: 4, Window: time.Minute, Algorithm: SlidingWindow}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 4; i++ {
+		if result, _ := store.Take("client", limit, start.Add(time.Second)); !result.Allowed {
+			t.Fatalf("request %d denied", i+1)
+		}
+	}
+	if result, _ := store.Take("client", limit, start.Add(2*time.Second)); result.Allowed {
+		t.Fatal("request over the limit allowed")
+	}
+
+	// A quarter into the next window the previous one still counts for 3 requests
+	quarter := start.Add(75 * time.Second)
+	if result, _ := store.Take("client", limit, quarter); !result.Allowed {
+		t.Fatal("request denied while the estimate is under the limit")
+	}
+	result, _ := store.Take("client", limit, quarter)
+	if result.Allowed {
+		t.Fatal("request allowed while the estimate is at the limit")
+	}
+	if result.RetryAfter <= 0 || result.RetryAfter > 45*time.Second {
+		t.Errorf("retry after = %v, want within the current window", result.RetryAfter)
+	}
+
+	// Two windows later the counters are back to zero
+	for i := 0; i < 4; i++ {
+		if result, _ := store.Take("client", limit, start.Add(3*time.Minute)); !result.Allowed {
+			t.Fatalf("request %d denied after two quiet windows", i+1)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	store := NewMemoryRateLimitStore(time.Minute)
+	defer store.Close()
+	router := http.NewRouter()
+	router.Get("/", func(req *http.Request, res *http.Response) {
+		res.HttpResponse("ok", http.StatusOK)
+	}).UsePreMiddlewares([]http.MiddlewareFunc{
+		RateLimit(Limit{Requests: 2, Window: time.Minute}, RateLimitOptions{Store: store, Key: KeyByHeader("X-API-Key")}),
+	})
+
+	send := func(key string) *http.Response {
+		return router.Dispatch(newTestRequest(t, "GET / HTTP/1.1\r\nHost: example.com\r\nX-API-Key: "+key+"\r\n\r\n"))
+	}
+	for i := 0; i < 2; i++ {
+		if res := send("a"); res.GetStatusCode() != http.StatusOK {
+			t.Fatalf("request %d: status = %d", i+1, res.GetStatusCode().Int())
+		}
+	}
+	res := send("a")
+	if res.GetStatusCode() != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", res.GetStatusCode().Int())
+	}
+	if res.GetHeader("Retry-After") != "30" || res.GetHeader("RateLimit-Remaining") != "0" {
+		t.Errorf("Retry-After = %q, RateLimit-Remaining = %q", res.GetHeader("Retry-After"), res.GetHeader("RateLimit-Remaining"))
+	}
+	if res.GetHeader("RateLimit-Policy") != "2;w=60" {
+		t.Errorf("RateLimit-Policy = %q", res.GetHeader("RateLimit-Policy"))
+	}
+	if res := send("b"); res.GetStatusCode() != http.StatusOK {
+		t.Errorf("other key limited, status = %d", res.GetStatusCode().Int())
+	}
+}
+
+func TestRateLimitRejectsInvalidLimits(t *testing.T) {
+	for _, limit := range []Limit{
+		{Requests: 0, Window: time.Minute},
+		{Requests: 10, Window: 0},
+		{Requests: -1, Window: time.Second},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RateLimit(%+v) didn't panic", limit)
+				}
+			}()
+			RateLimit(limit, RateLimitOptions{})
+		}()
+	}
+}
+
+func TestMemoryRateLimitStoreDefaultsCleanupInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		store := NewMemoryRateLimitStore(interval)
+		if result, _ := store.Take("client", Limit{Requests: 1, Window: time.Minute, Burst: 1}, time.Now()); !result.Allowed {
+			t.Errorf("interval %v: first request denied", interval)
+		}
+		store.Close()
+	}
+}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"http-server/app/http"
+	"log/slog"
+	"time"
+)
+
+// RequestIDOptions configures RequestID
+type RequestIDOptions struct {
+	// Header carries the id in requests and responses
+	Header string
+	// Generate creates the id of requests arriving without a valid one
+	Generate func() string
+	// MaxLength is the longest id accepted from clients, longer ones are replaced
+	MaxLength int
+}
+
+// DefaultRequestIDOptions are used by RequestID for every zero field of the given options
+var DefaultRequestIDOptions = RequestIDOptions{
+	Header:    "X-Request-ID",
+	Generate:  NewUUIDv7,
+	MaxLength: 128,
+}
+
+type requestIDContextKey struct{}
+
+// GetRequestID returns the id given to the request by the RequestID middleware, or an empty string
+func GetRequestID(req *http.Request) string {
+	return requestIDFromContext(req.Context())
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// RequestID gives every request an id, the one sent by the client or by a proxy in front of
+// the server when it is valid, a new one otherwise. The id is stored on the request context,
+// forwarded by ReverseProxy since it stays in the request headers, and sent back in the response.
+func RequestID(options RequestIDOptions) http.MiddlewareFunc {
+	if options.Header == "" {
+		options.Header = DefaultRequestIDOptions.Header
+	}
+	if options.Generate == nil {
+		options.Generate = DefaultRequestIDOptions.Generate
+	}
+	if options.MaxLength == 0 {
+		options.MaxLength = DefaultRequestIDOptions.MaxLength
+	}
+	return func(req *http.Request, res *http.Response, next func()) {
+		id := req.GetHeader(options.Header)
+		if !validRequestID(id, options.MaxLength) {
+			id = options.Generate()
+			req.DeleteHeader(options.Header)
+			req.SetHeader(options.Header, id)
+		}
+		req.WithValue(requestIDContextKey{}, id)
+		res.BeforeWrite(func() {
+			res.SetHeader(options.Header, id)
+		})
+		next()
+	}
+}
+
+// validRequestID accepts the ids made of printable ASCII characters without spaces,
+// so they can't break the headers and log lines they are copied to
+func validRequestID(id string, maxLength int) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' || id[i] == '"' || id[i] == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewUUIDv7 returns a random UUID starting with the current time in milliseconds, RFC 9562,
+// so ids sort by creation time
+func NewUUIDv7() string {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[6:]); err != nil {
+		panic("request id: " + err.Error())
+	}
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], uint64(time.Now().UnixMilli()))
+	copy(uuid[:6], timestamp[2:])
+	uuid[6] = uuid[6]&0x0f | 0x70
+	uuid[8] = uuid[8]&0x3f | 0x80
+
+	encoded := make([]byte, 36)
+	hex.Encode(encoded[0:8], uuid[0:4])
+	encoded[8] = '-'
+	hex.Encode(encoded[9:13], uuid[4:6])
+	encoded[13] = '-'
+	hex.Encode(encoded[14:18], uuid[6:8])
+	encoded[18] = '-'
+	hex.Encode(encoded[19:23], uuid[8:10])
+	encoded[23] = '-'
+	hex.Encode(encoded[24:], uuid[10:])
+	return string(encoded)
+}
+
+// RequestIDLogHandler adds the request id found in the context of every record, the logs written
+// with slog.InfoContext(req.Context(), ...) and the like can then be correlated with the access log
+func RequestIDLogHandler(handler slog.Handler) slog.Handler {
+	return requestIDLogHandler{Handler: handler}
+}
+
+type requestIDLogHandler struct {
+	slog.Handler
+}
+
+func (h requestIDLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDLogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDLogHandler) WithGroup(name string) slog.Handler {
+	return requestIDLogHandler{Handler: h.Handler.WithGroup(name)}
+}
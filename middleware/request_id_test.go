@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"http-server/app/http"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func requestIDRouter(options RequestIDOptions) *http.Router {
+	router := http.NewRouter()
+	router.UseGlobalPreMiddlewares([]http.MiddlewareFunc{RequestID(options)})
+	router.Get("/", func(req *http.Request, res *http.Response) {
+		res.HttpResponse(GetRequestID(req)+" "+req.GetHeader("X-Request-ID"), http.StatusOK)
+	})
+	return router
+}
+
+func TestRequestIDKeepsValidIDs(t *testing.T) {
+	res := dispatchWithHeaders(t, requestIDRouter(RequestIDOptions{}), "/", "x-request-id: abc-123\r\n")
+	if res.GetBody() != "abc-123 abc-123" || res.GetHeader("X-Request-ID") != "abc-123" {
+		t.Errorf("body = %q, X-Request-ID = %q", res.GetBody(), res.GetHeader("X-Request-ID"))
+	}
+}
+
+func TestRequestIDReplacesInvalidIDs(t *testing.T) {
+	router := requestIDRouter(RequestIDOptions{Generate: func() string { return "generated" }, MaxLength: 16})
+	for name, headers := range map[string]string{
+		"missing":   "",
+		"space":     "X-Request-ID: a b\r\n",
+		"quote":     "X-Request-ID: a\"b\r\n",
+		"too long":  "X-Request-ID: " + strings.Repeat("a", 17) + "\r\n",
+		"non ASCII": "X-Request-ID: é\r\n",
+	} {
+		res := dispatchWithHeaders(t, router, "/", headers)
+		// The handler and the upstreams of a proxy see the new id in the request header
+		if res.GetBody() != "generated generated" || res.GetHeader("X-Request-ID") != "generated" {
+			t.Errorf("%s: body = %q, X-Request-ID = %q", name, res.GetBody(), res.GetHeader("X-Request-ID"))
+		}
+	}
+}
+
+func TestNewUUIDv7(t *testing.T) {
+	format := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	previous := ""
+	for i := 0; i < 100; i++ {
+		id := NewUUIDv7()
+		if !format.MatchString(id) {
+			t.Fatalf("id = %q, not a version 7 UUID", id)
+		}
+		if id[:13] < previous {
+			t.Errorf("id %q created before %q", id, previous)
+		}
+		previous = id[:13]
+	}
+}
+
+func TestRequestIDLogHandler(t *testing.T) {
+	var output bytes.Buffer
+	logger := slog.New(RequestIDLogHandler(slog.NewTextHandler(&output, nil))).With("app", "test")
+	ctx := context.WithValue(context.Background(), requestIDContextKey{}, "abc-123")
+
+	logger.InfoContext(ctx, "with id")
+	logger.Info("without id")
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "request_id=abc-123") || strings.Contains(lines[1], "request_id") {
+		t.Errorf("lines = %q", lines)
+	}
+	if !strings.Contains(lines[0], "app=test") {
+		t.Errorf("attributes of the wrapped logger lost: %q", lines[0])
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"http-server/app/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func secureHeadersRouter(global SecureHeadersOptions) *http.Router {
+	router := http.NewRouter()
+	router.UseGlobalPreMiddlewares([]http.MiddlewareFunc{SecureHeaders(global)})
+	router.Get("/", func(req *http.Request, res *http.Response) {
+		res.HttpResponse(CSPNonce(req), http.StatusOK)
+	})
+	return router
+}
+
+func TestSecureHeadersDefaults(t *testing.T) {
+	router := secureHeadersRouter(DefaultSecureHeadersOptions)
+	res := dispatchWithHeaders(t, router, "/", "")
+	for header, want := range map[string]string{
+		"Strict-Transport-Security":    "max-age=31536000; includeSubDomains",
+		"X-Content-Type-Options":       "nosniff",
+		"X-Frame-Options":              "DENY",
+		"Referrer-Policy":              "strict-origin-when-cross-origin",
+		"Cross-Origin-Opener-Policy":   "same-origin",
+		"Cross-Origin-Embedder-Policy": "",
+	} {
+		if got := res.GetHeader(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+
+	nonce := res.GetBody()
+	if nonce == "" || !strings.Contains(res.GetHeader("Content-Security-Policy"), "'nonce-"+nonce+"'") {
+		t.Errorf("nonce %q not in the policy %q", nonce, res.GetHeader("Content-Security-Policy"))
+	}
+	if next := dispatchWithHeaders(t, router, "/", "").GetBody(); next == nonce {
+		t.Error("nonce reused across requests")
+	}
+}
+
+func TestSecureHeadersRouteOverridesGlobal(t *testing.T) {
+	router := http.NewRouter()
+	router.UseGlobalPreMiddlewares([]http.MiddlewareFunc{SecureHeaders(DefaultSecureHeadersOptions)})
+	router.Get("/embed", func(req *http.Request, res *http.Response) {
+		res.HttpResponse(CSPNonce(req), http.StatusOK)
+	}).UsePreMiddlewares([]http.MiddlewareFunc{SecureHeaders(SecureHeadersOptions{
+		HSTSMaxAge:            time.Hour,
+		HSTSPreload:           true,
+		ContentSecurityPolicy: "script-src 'nonce-{nonce}'",
+		CSPReportOnly:         true,
+	})})
+
+	res := dispatchWithHeaders(t, router, "/embed", "")
+	if got := res.GetHeader("Strict-Transport-Security"); got != "max-age=3600; preload" {
+		t.Errorf("Strict-Transport-Security = %q", got)
+	}
+	for _, header := range []string{"X-Frame-Options", "X-Content-Type-Options", "Content-Security-Policy"} {
+		if got := res.GetHeader(header); got != "" {
+			t.Errorf("%s = %q, want it removed by the route options", header, got)
+		}
+	}
+	// The nonce of the global middleware is kept so both policies agree
+	if want := "script-src 'nonce-" + res.GetBody() + "'"; res.GetHeader("Content-Security-Policy-Report-Only") != want {
+		t.Errorf("Content-Security-Policy-Report-Only = %q, want %q", res.GetHeader("Content-Security-Policy-Report-Only"), want)
+	}
+}
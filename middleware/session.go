@@ -0,0 +1,287 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"http-server/app/http"
+	"log"
+	"sync"
+	"time"
+)
+
+type sessionContextKey struct{}
+
+// SessionOptions configures the Session middleware
+type SessionOptions struct {
+	CookieName string
+	Path       string
+	Domain     string
+	Secure     bool
+	SameSite   http.SameSite
+
+	// IdleTimeout ends a session that has not been used for that long
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends a session that long after it was created, however active it is
+	AbsoluteTimeout time.Duration
+}
+
+// DefaultSessionOptions are used by Session for every zero field of the given options
+var DefaultSessionOptions = SessionOptions{
+	CookieName:      "session_id",
+	Path:            "/",
+	SameSite:        http.SameSiteLaxMode,
+	IdleTimeout:     30 * time.Minute,
+	AbsoluteTimeout: 24 * time.Hour,
+}
+
+// sessionRecord is what gets persisted in the store, encoded as JSON
+type sessionRecord struct {
+	Values     map[string]interface{} `json:"values"`
+	Flashes    map[string][]string    `json:"flashes,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+	LastSeenAt time.Time              `json:"last_seen_at"`
+}
+
+// SessionData holds the server-side data of one client. Values round-trip through JSON
+// so numbers come back as float64 and structs as maps once the session is reloaded.
+type SessionData struct {
+	mu        sync.Mutex
+	id        string
+	oldID     string
+	data      sessionRecord
+	isNew     bool
+	modified  bool
+	destroyed bool
+}
+
+// ID returns the current session ID
+func (s *SessionData) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// Get returns the value stored under key or nil
+func (s *SessionData) Get(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Values[key]
+}
+
+// Set stores value under key
+func (s *SessionData) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Values[key] = value
+	s.modified = true
+}
+
+// Delete removes the value stored under key
+func (s *SessionData) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data.Values, key)
+	s.modified = true
+}
+
+// AddFlash queues a message of the given kind to be read on a later request
+func (s *SessionData) AddFlash(kind string, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Flashes == nil {
+		s.data.Flashes = make(map[string][]string)
+	}
+	s.data.Flashes[kind] = append(s.data.Flashes[kind], message)
+	s.modified = true
+}
+
+// Flashes returns the queued messages of the given kind and removes them from the session
+func (s *SessionData) Flashes(kind string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := s.data.Flashes[kind]
+	if len(messages) > 0 {
+		delete(s.data.Flashes, kind)
+		s.modified = true
+	}
+	return messages
+}
+
+// Regenerate gives the session a new ID while keeping its data, it must be called
+// when the privilege level changes (login, logout) to prevent session fixation.
+// The creation time is kept too, so regenerating doesn't extend the absolute timeout.
+func (s *SessionData) Regenerate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isNew && s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = newSessionID()
+	s.isNew = true
+	s.modified = true
+}
+
+// Destroy deletes the session from the store and expires the cookie on the client
+func (s *SessionData) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Values = make(map[string]interface{})
+	s.data.Flashes = nil
+	s.destroyed = true
+}
+
+// GetSession returns the session attached to the request by the Session middleware, or nil
+func GetSession(req *http.Request) *SessionData {
+	session, _ := req.Context().Value(sessionContextKey{}).(*SessionData)
+	return session
+}
+
+// Session loads the session of the client from store before the handler runs and saves it
+// back right before the response is written. A cookie is only issued once the session holds data.
+func Session(store SessionStore, options SessionOptions) http.MiddlewareFunc {
+	options = withSessionDefaults(options)
+	return func(req *http.Request, res *http.Response, next func()) {
+		session, err := loadSession(req, store, options)
+		if err != nil {
+			log.Printf("Error loading session: %v", err)
+			res.HttpResponse("Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		req.WithValue(sessionContextKey{}, session)
+		res.BeforeWrite(func() {
+			if err := saveSession(res, session, store, options); err != nil {
+				log.Printf("Error saving session: %v", err)
+			}
+		})
+		next()
+	}
+}
+
+func withSessionDefaults(options SessionOptions) SessionOptions {
+	if options.CookieName == "" {
+		options.CookieName = DefaultSessionOptions.CookieName
+	}
+	if options.Path == "" {
+		options.Path = DefaultSessionOptions.Path
+	}
+	if options.SameSite == http.SameSiteDefaultMode {
+		options.SameSite = DefaultSessionOptions.SameSite
+	}
+	if options.IdleTimeout == 0 {
+		options.IdleTimeout = DefaultSessionOptions.IdleTimeout
+	}
+	if options.AbsoluteTimeout == 0 {
+		options.AbsoluteTimeout = DefaultSessionOptions.AbsoluteTimeout
+	}
+	return options
+}
+
+func loadSession(req *http.Request, store SessionStore, options SessionOptions) (*SessionData, error) {
+	now := time.Now()
+	fresh := &SessionData{
+		id:    newSessionID(),
+		isNew: true,
+		data: sessionRecord{
+			Values:     make(map[string]interface{}),
+			CreatedAt:  now,
+			LastSeenAt: now,
+		},
+	}
+
+	cookie, err := req.Cookie(options.CookieName)
+	if err != nil || !isSessionID(cookie.Value) {
+		return fresh, nil
+	}
+	raw, err := store.Load(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return fresh, nil
+	}
+
+	var data sessionRecord
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	if now.Sub(data.LastSeenAt) > options.IdleTimeout || now.Sub(data.CreatedAt) > options.AbsoluteTimeout {
+		if err := store.Delete(cookie.Value); err != nil {
+			return nil, err
+		}
+		return fresh, nil
+	}
+	if data.Values == nil {
+		data.Values = make(map[string]interface{})
+	}
+	data.LastSeenAt = now
+	return &SessionData{id: cookie.Value, data: data}, nil
+}
+
+func saveSession(res *http.Response, session *SessionData, store SessionStore, options SessionOptions) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.oldID != "" {
+		if err := store.Delete(session.oldID); err != nil {
+			return err
+		}
+	}
+
+	cookie := &http.Cookie{
+		Name:     options.CookieName,
+		Path:     options.Path,
+		Domain:   options.Domain,
+		Secure:   options.Secure,
+		HttpOnly: true,
+		SameSite: options.SameSite,
+	}
+
+	if session.destroyed {
+		if !session.isNew {
+			if err := store.Delete(session.id); err != nil {
+				return err
+			}
+		}
+		cookie.MaxAge = -1
+		return res.SetCookie(cookie)
+	}
+
+	// A brand new session that was never written to is not worth storing
+	if session.isNew && !session.modified {
+		return nil
+	}
+
+	raw, err := json.Marshal(session.data)
+	if err != nil {
+		return err
+	}
+	expiry := session.data.LastSeenAt.Add(options.IdleTimeout)
+	if absolute := session.data.CreatedAt.Add(options.AbsoluteTimeout); absolute.Before(expiry) {
+		expiry = absolute
+	}
+	if err := store.Save(session.id, raw, expiry); err != nil {
+		return err
+	}
+
+	if session.isNew {
+		cookie.Value = session.id
+		cookie.MaxAge = int(time.Until(session.data.CreatedAt.Add(options.AbsoluteTimeout)).Seconds())
+		return res.SetCookie(cookie)
+	}
+	return nil
+}
+
+func newSessionID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// isSessionID checks the shape of IDs coming from clients, stores can then safely use them as keys or file names
+func isSessionID(id string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(id)
+	return err == nil && len(b) == 32
+}
@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SessionStore persists encoded session data by session ID.
+// Load returns nil data and no error when the session does not exist or has expired.
+type SessionStore interface {
+	Load(id string) ([]byte, error)
+	Save(id string, data []byte, expiresAt time.Time) error
+	Delete(id string) error
+}
+
+type memoryEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// MemoryStore keeps sessions in memory, they are lost when the server restarts
+type MemoryStore struct {
+	mu       sync.RWMutex
+	sessions map[string]memoryEntry
+	done     chan struct{}
+}
+
+// defaultCleanupInterval is how often memory stores evict expired entries when no valid interval is given
+const defaultCleanupInterval = time.Minute
+
+// NewMemoryStore creates a memory store evicting expired sessions every cleanupInterval,
+// or every minute when it isn't positive
+func NewMemoryStore(cleanupInterval time.Duration) *MemoryStore {
+	if cleanupInterval <= 0 {
+		cleanupInterval = defaultCleanupInterval
+	}
+	store := &MemoryStore{
+		sessions: make(map[string]memoryEntry),
+		done:     make(chan struct{}),
+	}
+	go store.evictLoop(cleanupInterval)
+	return store
+}
+
+func (s *MemoryStore) Load(id string) ([]byte, error) {
+	s.mu.RLock()
+	entry, exists := s.sessions[id]
+	s.mu.RUnlock()
+	if !exists || time.Now().After(entry.expiresAt) {
+		return nil, nil
+	}
+	return entry.data, nil
+}
+
+func (s *MemoryStore) Save(id string, data []byte, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[id] = memoryEntry{data: data, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// Close stops the background eviction
+func (s *MemoryStore) Close() {
+	close(s.done)
+}
+
+func (s *MemoryStore) evictLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.evictExpired()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *MemoryStore) evictExpired() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, entry := range s.sessions {
+		if now.After(entry.expiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+type fileEntry struct {
+	Data      json.RawMessage `json:"data"`
+	ExpiresAt time.Time       `json:"expires_at"`
+}
+
+// FileStore keeps one JSON file per session in a directory so sessions survive restarts
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a file store in dir, creating the directory if needed
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *FileStore) Load(id string) ([]byte, error) {
+	raw, err := os.ReadFile(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry fileEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil, err
+	}
+	if time.Now().After(entry.ExpiresAt) {
+		return nil, s.Delete(id)
+	}
+	return entry.Data, nil
+}
+
+func (s *FileStore) Save(id string, data []byte, expiresAt time.Time) error {
+	raw, err := json.Marshal(fileEntry{Data: data, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so a concurrent Load never reads a partial session
+	tmp, err := os.CreateTemp(s.dir, id+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(id))
+}
+
+func (s *FileStore) Delete(id string) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Cleanup removes the files of every expired session, it is meant to be called periodically
+func (s *FileStore) Cleanup() error {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		id := filepath.Base(match)
+		if _, err := s.Load(id[:len(id)-len(".json")]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
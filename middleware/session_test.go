@@ -0,0 +1,264 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"http-server/app/http"
+	"io"
+	nethttp "net/http"
+	"net/http/cookiejar"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sessionTestServer serves routes reading and writing the session of the client
+func sessionTestServer(t *testing.T, store SessionStore, options SessionOptions) string {
+	t.Helper()
+	router := http.NewRouter()
+	router.UseGlobalPreMiddlewares([]http.MiddlewareFunc{Session(store, options)})
+	router.Get("/get", func(req *http.Request, res *http.Response) {
+		res.HttpResponse(fmt.Sprint(GetSession(req).Get("user")), http.StatusOK)
+	})
+	router.Get("/set", func(req *http.Request, res *http.Response) {
+		GetSession(req).Set("user", req.GetQueryParam("user"))
+		res.HttpResponse("ok", http.StatusOK)
+	})
+	router.Get("/flash", func(req *http.Request, res *http.Response) {
+		GetSession(req).AddFlash("notice", "saved")
+		res.HttpResponse("ok", http.StatusOK)
+	})
+	router.Get("/flashes", func(req *http.Request, res *http.Response) {
+		res.HttpResponse(strings.Join(GetSession(req).Flashes("notice"), ","), http.StatusOK)
+	})
+	router.Get("/login", func(req *http.Request, res *http.Response) {
+		GetSession(req).Regenerate()
+		res.HttpResponse(GetSession(req).ID(), http.StatusOK)
+	})
+	router.Get("/id", func(req *http.Request, res *http.Response) {
+		res.HttpResponse(GetSession(req).ID(), http.StatusOK)
+	})
+	router.Get("/logout", func(req *http.Request, res *http.Response) {
+		GetSession(req).Destroy()
+		res.HttpResponse("ok", http.StatusOK)
+	})
+	return http.StartTestServer(t, router)
+}
+
+func newCookieClient(t *testing.T) *nethttp.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &nethttp.Client{Jar: jar}
+}
+
+func getBody(t *testing.T, client *nethttp.Client, url string) (*nethttp.Response, string) {
+	t.Helper()
+	response, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	return response, string(body)
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	store := NewMemoryStore(time.Minute)
+	defer store.Close()
+	baseURL := sessionTestServer(t, store, SessionOptions{})
+	client := newCookieClient(t)
+
+	response, _ := getBody(t, client, baseURL+"/get")
+	if len(response.Cookies()) != 0 {
+		t.Errorf("cookie issued for an empty session: %v", response.Cookies())
+	}
+
+	response, _ = getBody(t, client, baseURL+"/set?user=gopher")
+	cookies := response.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session_id" || !cookies[0].HttpOnly || cookies[0].SameSite != nethttp.SameSiteLaxMode {
+		t.Fatalf("cookies = %v", cookies)
+	}
+	if cookies[0].MaxAge <= 0 || cookies[0].MaxAge > int((24*time.Hour).Seconds()) {
+		t.Errorf("Max-Age = %d, want the absolute timeout", cookies[0].MaxAge)
+	}
+	if _, body := getBody(t, client, baseURL+"/get"); body != "gopher" {
+		t.Errorf("user = %q on the next request", body)
+	}
+	if _, body := getBody(t, newCookieClient(t), baseURL+"/get"); body != "<nil>" {
+		t.Errorf("session shared with another client: %q", body)
+	}
+}
+
+func TestSessionFlashes(t *testing.T) {
+	store := NewMemoryStore(time.Minute)
+	defer store.Close()
+	baseURL := sessionTestServer(t, store, SessionOptions{})
+	client := newCookieClient(t)
+
+	getBody(t, client, baseURL+"/flash")
+	getBody(t, client, baseURL+"/flash")
+	if _, body := getBody(t, client, baseURL+"/flashes"); body != "saved,saved" {
+		t.Errorf("flashes = %q", body)
+	}
+	if _, body := getBody(t, client, baseURL+"/flashes"); body != "" {
+		t.Errorf("flashes read twice: %q", body)
+	}
+}
+
+func TestSessionRegenerate(t *testing.T) {
+	store := NewMemoryStore(time.Minute)
+	defer store.Close()
+	baseURL := sessionTestServer(t, store, SessionOptions{})
+	client := newCookieClient(t)
+
+	getBody(t, client, baseURL+"/set?user=gopher")
+	_, oldID := getBody(t, client, baseURL+"/id")
+	oldRecord := loadRecord(t, store, oldID)
+
+	_, newID := getBody(t, client, baseURL+"/login")
+	if newID == oldID {
+		t.Fatal("session ID kept on regeneration")
+	}
+	if raw, _ := store.Load(oldID); raw != nil {
+		t.Error("old session still in the store")
+	}
+	if _, body := getBody(t, client, baseURL+"/get"); body != "gopher" {
+		t.Errorf("user = %q after regeneration", body)
+	}
+	if newRecord := loadRecord(t, store, newID); !newRecord.CreatedAt.Equal(oldRecord.CreatedAt) {
+		t.Errorf("created at %v after regeneration, was %v", newRecord.CreatedAt, oldRecord.CreatedAt)
+	}
+}
+
+func TestSessionDestroy(t *testing.T) {
+	store := NewMemoryStore(time.Minute)
+	defer store.Close()
+	baseURL := sessionTestServer(t, store, SessionOptions{})
+	client := newCookieClient(t)
+
+	getBody(t, client, baseURL+"/set?user=gopher")
+	_, id := getBody(t, client, baseURL+"/id")
+	response, _ := getBody(t, client, baseURL+"/logout")
+	if cookies := response.Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("cookies = %v, want the session cookie expired", cookies)
+	}
+	if raw, _ := store.Load(id); raw != nil {
+		t.Error("destroyed session still in the store")
+	}
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	store := NewMemoryStore(time.Minute)
+	defer store.Close()
+	baseURL := sessionTestServer(t, store, SessionOptions{IdleTimeout: time.Hour, AbsoluteTimeout: 24 * time.Hour})
+
+	save := func(lastSeen time.Duration, created time.Duration) string {
+		id := newSessionID()
+		raw, _ := json.Marshal(sessionRecord{
+			Values:     map[string]interface{}{"user": "gopher"},
+			CreatedAt:  time.Now().Add(-created),
+			LastSeenAt: time.Now().Add(-lastSeen),
+		})
+		store.Save(id, raw, time.Now().Add(time.Hour))
+		return id
+	}
+	tests := []struct {
+		name    string
+		id      string
+		want    string
+		inStore bool
+	}{
+		{"active", save(time.Minute, time.Hour), "gopher", true},
+		{"idle", save(2*time.Hour, 3*time.Hour), "<nil>", false},
+		{"past the absolute timeout", save(time.Minute, 25*time.Hour), "<nil>", false},
+		{"malformed ID", "../../etc/passwd", "<nil>", false},
+	}
+	for _, test := range tests {
+		req, _ := nethttp.NewRequest("GET", baseURL+"/get", nil)
+		req.AddCookie(&nethttp.Cookie{Name: "session_id", Value: test.id})
+		response, err := nethttp.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		if string(body) != test.want {
+			t.Errorf("%s: user = %q, want %q", test.name, body, test.want)
+		}
+		if raw, _ := store.Load(test.id); (raw != nil) != test.inStore {
+			t.Errorf("%s: in store = %v, want %v", test.name, raw != nil, test.inStore)
+		}
+	}
+}
+
+func loadRecord(t *testing.T, store SessionStore, id string) sessionRecord {
+	t.Helper()
+	raw, err := store.Load(id)
+	if err != nil || raw == nil {
+		t.Fatalf("session %q not stored: %v", id, err)
+	}
+	var record sessionRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+func TestMemoryStore(t *testing.T) {
+	// A non-positive interval falls back to the default instead of crashing the sweeper
+	for _, interval := range []time.Duration{0, -time.Second} {
+		NewMemoryStore(interval).Close()
+	}
+
+	store := NewMemoryStore(time.Minute)
+	defer store.Close()
+	store.Save("live", []byte("1"), time.Now().Add(time.Minute))
+	store.Save("expired", []byte("2"), time.Now().Add(-time.Second))
+	if raw, _ := store.Load("live"); string(raw) != "1" {
+		t.Errorf("live = %q", raw)
+	}
+	if raw, _ := store.Load("expired"); raw != nil {
+		t.Errorf("expired session loaded: %q", raw)
+	}
+	store.evictExpired()
+	if len(store.sessions) != 1 {
+		t.Errorf("%d sessions left after eviction, want 1", len(store.sessions))
+	}
+	store.Delete("live")
+	if raw, _ := store.Load("live"); raw != nil {
+		t.Error("deleted session loaded")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("live", []byte(`{"a":1}`), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	store.Save("expired", []byte(`{"b":2}`), time.Now().Add(-time.Second))
+	if raw, err := store.Load("live"); err != nil || string(raw) != `{"a":1}` {
+		t.Errorf("live = %q, %v", raw, err)
+	}
+	if raw, err := store.Load("missing"); raw != nil || err != nil {
+		t.Errorf("missing = %q, %v", raw, err)
+	}
+	if err := store.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.path("expired")); err == nil {
+		t.Error("expired session file kept by Cleanup")
+	}
+	if err := store.Delete("live"); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := store.Load("live"); raw != nil {
+		t.Error("deleted session loaded")
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"http-server/app/http"
+	"strings"
+)
+
+// WebSocketToken moves the token of a WebSocket upgrade request from the queryParam query
+// parameter to an "Authorization: Bearer" header, since browsers can't set headers on
+// WebSocket connections. Register it before BearerAuth on WebSocket routes.
+func WebSocketToken(queryParam string) http.MiddlewareFunc {
+	return func(req *http.Request, res *http.Response, next func()) {
+		token := req.GetQueryParam(queryParam)
+		if token != "" && req.GetHeader("Authorization") == "" && strings.EqualFold(req.GetHeader("Upgrade"), "websocket") {
+			req.SetHeader("Authorization", "Bearer "+token)
+		}
+		next()
+	}
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"http-server/app/http"
+	"testing"
+)
+
+func TestWebSocketToken(t *testing.T) {
+	router := http.NewRouter()
+	router.Get("/ws", func(req *http.Request, res *http.Response) {
+		res.HttpResponse(req.GetHeader("Authorization"), http.StatusOK)
+	}).UsePreMiddlewares([]http.MiddlewareFunc{WebSocketToken("access_token")})
+
+	tests := []struct {
+		name    string
+		target  string
+		headers string
+		want    string
+	}{
+		{"upgrade", "/ws?access_token=abc", "Upgrade: websocket\r\n", "Bearer abc"},
+		{"header kept", "/ws?access_token=abc", "Upgrade: websocket\r\nAuthorization: Bearer xyz\r\n", "Bearer xyz"},
+		{"not an upgrade", "/ws?access_token=abc", "", ""},
+		{"no token", "/ws", "Upgrade: websocket\r\n", ""},
+	}
+	for _, test := range tests {
+		if got := dispatchWithHeaders(t, router, test.target, test.headers).GetBody(); got != test.want {
+			t.Errorf("%s: Authorization = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"http-server/app/config"
 	"http-server/app/http"
 	"http-server/controllers/HomeController"
 	"http-server/middleware"
@@ -14,6 +15,11 @@ func HomeRoutes() *http.Router {
 	router.Get("/home/2", HomeController.Index)
 	router.Get("/home/3", HomeController.Index)
 
-	router.UseGlobalPreMiddlewares([]http.MiddlewareFunc{middleware.AuthMiddleware})
+	// Bearer tokens are verified once a key set is configured with JWKS_FILE
+	auth := middleware.AuthMiddleware
+	if verifier := config.TokenVerifier(); verifier != nil {
+		auth = middleware.BearerAuth("home", verifier)
+	}
+	router.UseGlobalPreMiddlewares([]http.MiddlewareFunc{auth})
 	return router
 }
\ No newline at end of file
@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"http-server/app/config"
+	"http-server/app/http"
+	"http-server/middleware"
+)
+
+func UpstreamRoutes() *http.Router {
+	router := http.NewRouter()
+	pool := config.UpstreamPool()
+	if pool == nil {
+		return router
+	}
+
+	router.Proxy("/legacy", http.NewBalancedProxy(pool, http.ProxyOptions{StripPrefix: "/legacy"}))
+
+	// The status of the upstreams is for admins, it answers 401 until JWKS_FILE is configured
+	status := router.Get("/admin/upstreams", pool.StatusHandler).Require("admin")
+	if verifier := config.TokenVerifier(); verifier != nil {
+		status.UsePreMiddlewares([]http.MiddlewareFunc{middleware.BearerAuth("upstreams", verifier)})
+	}
+	return router
+}